@@ -0,0 +1,245 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// sortRank orders the kinds of values for SORT: numbers come first, then text, then booleans, then
+// blanks.
+func sortRank(v value) int {
+	switch v.kind {
+	case valNumber:
+		return 0
+	case valString:
+		return 1
+	case valBool:
+		return 2
+	}
+	return 3
+}
+
+// orderValues compares a and b for sorting, returning -1, 0 or 1 as a comes before, with or after
+// b. Values of the same kind are compared by compareValues, and kinds are ordered by sortRank.
+func orderValues(a, b value) int {
+	ra, rb := sortRank(a), sortRank(b)
+	switch {
+	case ra < rb:
+		return -1
+	case ra > rb:
+		return 1
+	}
+	cmp, _ := compareValues(a, b)
+	return cmp
+}
+
+// rowKey returns a string that is the same for rows whose values are equal, with text compared
+// ignoring case.
+func rowKey(row []value) string {
+	var b strings.Builder
+	for _, v := range row {
+		switch v.kind {
+		case valString:
+			fmt.Fprintf(&b, "s%q", strings.ToLower(v.str))
+		case valBlank:
+			b.WriteString("_")
+		default:
+			fmt.Fprintf(&b, "%d%v,", v.kind, v.num)
+		}
+	}
+	return b.String()
+}
+
+// boolArg returns the optional boolean argument i of vals, or false if there is none.
+func boolArg(vals []value, i int) (bool, error) {
+	if len(vals) <= i {
+		return false, nil
+	}
+	f, err := vals[i].toNumber()
+	return f != 0, err
+}
+
+// SEQUENCE(rows, [columns], [start], [step]) returns an array of rows rows and columns columns
+// holding the numbers from start, which defaults to 1, counting by step, which defaults to 1, row
+// by row.
+func fnSequence(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalArgs(s, "SEQUENCE", args, 1, 4)
+	if err != nil {
+		return value{}, err
+	}
+	height, width, start, step := math.Trunc(vals[0]), 1.0, 1.0, 1.0
+	if len(vals) > 1 {
+		width = math.Trunc(vals[1])
+	}
+	if len(vals) > 2 {
+		start = vals[2]
+	}
+	if len(vals) > 3 {
+		step = vals[3]
+	}
+	if height < 1 || width < 1 {
+		return value{}, fmt.Errorf("SEQUENCE: rows and columns must be at least 1")
+	}
+	if height*width > maxRangeCells {
+		return value{}, fmt.Errorf("SEQUENCE: %v by %v values is too many", height, width)
+	}
+	rows := make([][]value, int(height))
+	for i := range rows {
+		rows[i] = make([]value, int(width))
+		for j := range rows[i] {
+			n := start + step*float64(i*len(rows[i])+j)
+			if rows[i][j], err = mathResult("SEQUENCE", n, vals...); err != nil {
+				return value{}, err
+			}
+		}
+	}
+	return arrayValue(rows), nil
+}
+
+// SORT(array, [index], [order], [by_column]) returns the rows of array sorted by the values in
+// their column index, which defaults to 1. order is 1 for ascending order, the default, or -1 for
+// descending order. If by_column is TRUE, the columns of array are sorted by the values in their
+// row index instead. Numbers sort before text, which sorts before booleans, with blanks last.
+// Rows with equal values keep their order.
+func fnSort(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "SORT", args, 1, 4)
+	if err != nil {
+		return value{}, err
+	}
+	byCol, err := boolArg(vals, 3)
+	if err != nil {
+		return value{}, err
+	}
+	rows := append([][]value(nil), vals[0].rows()...)
+	if byCol {
+		rows = transpose(rows)
+	}
+	idx := 1
+	if len(vals) > 1 {
+		if idx, err = indexArg("SORT", vals[1], len(rows[0]), false); err != nil {
+			return value{}, err
+		}
+	}
+	order := 1
+	if len(vals) > 2 {
+		o, err := vals[2].toNumber()
+		if err != nil {
+			return value{}, err
+		}
+		if o != 1 && o != -1 {
+			return value{}, fmt.Errorf("SORT: order must be 1 or -1, but got %v", o)
+		}
+		order = int(o)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i][idx-1], rows[j][idx-1]
+		if a.kind == valBlank || b.kind == valBlank {
+			// Blanks are last in either order.
+			return b.kind == valBlank && a.kind != valBlank
+		}
+		return orderValues(a, b)*order < 0
+	})
+	if byCol {
+		rows = transpose(rows)
+	}
+	return arrayValue(rows), nil
+}
+
+// FILTER(array, include, [if_empty]) returns the rows of array for which the value in the same
+// row of include, a single column, is TRUE or a number other than 0, as in
+// FILTER(A1:B9, B1:B9>10). If include is a single row,
+// the columns of array are filtered instead. if_empty is returned if nothing is included; without
+// it, that is an error.
+func fnFilter(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "FILTER", args, 2, 3)
+	if err != nil {
+		return value{}, err
+	}
+	rows, include := vals[0].rows(), vals[1].rows()
+	byCol := false
+	switch {
+	case len(include) == len(rows) && len(include[0]) == 1:
+	case len(include) == 1 && len(include[0]) == len(rows[0]):
+		byCol = true
+		rows, include = transpose(rows), transpose(include)
+	default:
+		return value{}, fmt.Errorf("FILTER: include must be a row or column the size of array")
+	}
+	var kept [][]value
+	for i := range rows {
+		v := include[i][0]
+		if v.kind == valBlank {
+			continue
+		}
+		f, err := v.toNumber()
+		if err != nil {
+			return value{}, fmt.Errorf("FILTER: %v", err)
+		}
+		if f != 0 {
+			kept = append(kept, rows[i])
+		}
+	}
+	if len(kept) == 0 {
+		if len(vals) > 2 {
+			return vals[2], nil
+		}
+		return value{}, fmt.Errorf("FILTER: no values are included")
+	}
+	if byCol {
+		kept = transpose(kept)
+	}
+	return arrayValue(kept), nil
+}
+
+// UNIQUE(array, [by_column], [exactly_once]) returns the distinct rows of array, in the order they
+// first appear. Text is compared ignoring case. If by_column is TRUE, distinct columns are returned
+// instead. If exactly_once is TRUE, only the rows that appear once are returned.
+func fnUnique(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "UNIQUE", args, 1, 3)
+	if err != nil {
+		return value{}, err
+	}
+	byCol, err := boolArg(vals, 1)
+	if err != nil {
+		return value{}, err
+	}
+	once, err := boolArg(vals, 2)
+	if err != nil {
+		return value{}, err
+	}
+	rows := vals[0].rows()
+	if byCol {
+		rows = transpose(rows)
+	}
+	var distinct [][]value
+	var counts []int
+	index := make(map[string]int)
+	for _, row := range rows {
+		key := rowKey(row)
+		if i, ok := index[key]; ok {
+			counts[i]++
+			continue
+		}
+		index[key] = len(distinct)
+		distinct = append(distinct, row)
+		counts = append(counts, 1)
+	}
+	if once {
+		var single [][]value
+		for i := range distinct {
+			if counts[i] == 1 {
+				single = append(single, distinct[i])
+			}
+		}
+		distinct = single
+	}
+	if len(distinct) == 0 {
+		return value{}, fmt.Errorf("UNIQUE: no values appear exactly once")
+	}
+	if byCol {
+		distinct = transpose(distinct)
+	}
+	return arrayValue(distinct), nil
+}
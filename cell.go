@@ -2,12 +2,14 @@ package sheet
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
-var addrRE *regexp.Regexp
+var addrRE = regexp.MustCompile("^([A-Za-z]+)([0-9]+)$")
 
 // CellAddress is the address of a cell in a sheet.
 type CellAddress struct {
@@ -33,13 +35,6 @@ const lastCol = "ZZ"
 // row, as in a traditional spreadsheet. Currently, an most 2 alphabetic characters are specified
 // for a maximum of 26^2 (676) columns. The number of rows is bounded to math.MaxUint32.
 func CellAddr(addr string) (CellAddress, error) {
-	var err error
-	if addrRE == nil {
-		addrRE, err = regexp.Compile("([A-Za-z]+)([0-9]+)")
-		if err != nil {
-			return CellAddress{}, err
-		}
-	}
 	matches := addrRE.FindStringSubmatch(addr)
 	//fmt.Printf("MATCHES: %#v\n", matches)
 	if len(matches) != 3 {
@@ -122,6 +117,34 @@ func (ca CellAddress) NextCol() (CellAddress, error) {
 	return ret, nil
 }
 
+// colIndex returns the number of the column col, counting from 1 for column A.
+func colIndex(col string) int {
+	n := 0
+	for _, r := range col {
+		n = n*26 + int(r-'A') + 1
+	}
+	return n
+}
+
+// colName returns the name of column number n, counting from 1 for column A.
+func colName(n int) string {
+	var rs []rune
+	for n > 0 {
+		n--
+		rs = append([]rune{rune('A' + n%26)}, rs...)
+		n /= 26
+	}
+	return string(rs)
+}
+
+// less orders addresses by row, then by column.
+func (ca CellAddress) less(ca2 CellAddress) bool {
+	if ca.row != ca2.row {
+		return ca.row < ca2.row
+	}
+	return ca.LessCol(ca2)
+}
+
 // String returns a human-readable representation of ca. This value can also be parsed by CellAddr.
 func (ca CellAddress) String() string {
 	return fmt.Sprintf("%s%d", ca.col, ca.row)
@@ -134,11 +157,13 @@ func (ca CellAddress) String() string {
 // val is when there is a numeric value in the cell that can be used for calculations.
 // string is when there is a string value in the cell.
 // expr is when there is an expression in the cell that will yield a value.
+// spill is when the cell shows part of the array result of an expression in another cell.
 const (
 	cell_transient = iota
 	cell_val
 	cell_string
 	cell_expr
+	cell_spill
 )
 
 // Cell is the basic unit of storage and computation for a spreadsheet. A Cell has an address and
@@ -148,8 +173,18 @@ type Cell struct {
 	cell_type int
 	addr      CellAddress
 	sheet     *Sheet
-	content   string
-	val       float64
+	// content is the text of a string cell, or the text a numeric cell was entered as, which is
+	// kept so that editing or writing out the cell gives back exactly what was entered.
+	content string
+	val     float64
+	// rat is the exact value of the cell when its sheet is in decimal mode. See
+	// (*Sheet).SetDecimal.
+	rat *big.Rat
+	// format is how val is displayed. It is detected from the content of numeric cells, and
+	// inferred from the equation of expression cells. userFormat, if set, was chosen explicitly by
+	// the user with (*Sheet).SetFormat and overrides format. See displayFormat.
+	format     numberFormat
+	userFormat *numberFormat
 
 	// These variables hold the expression string, the parsed expression, and any error that
 	// occurs during the parsing or computation of an expression, for instance when there are cyclical
@@ -157,6 +192,9 @@ type Cell struct {
 	expstr string
 	exp    *Expression
 	expErr error
+	// resultKind is the kind of value the expression evaluated to. Text results are kept in
+	// content.
+	resultKind valueKind
 
 	// upstream is a list of cells that are used to calculate the result of this cell.
 	// downstream is a list of cells that use the value of this cell to calculate their results.
@@ -164,11 +202,28 @@ type Cell struct {
 	// used to perform recalculations necessary when some value in the sheet changes.
 	upstream   []*Cell
 	downstream []*Cell
+	// dynamic holds the cells referenced through functions like INDIRECT, whose references are
+	// only known once the expression is evaluated. They are also the last entries of upstream.
+	// dynamicRefs are the references found by the latest evaluation, and linked the references
+	// dynamic was made from. See relink. hasDynamic is false if the expression can't have any, so
+	// that evaluate needn't look for them. See compile.
+	dynamic     []*Cell
+	dynamicRefs []cellRef
+	linked      []cellRef
+	hasDynamic  bool
+
+	// array holds the result of an expression that evaluated to more than one value. The values
+	// spill into the cells below and to the right of this one, which are held in spilled and are
+	// also downstream of this cell. spillFrom is the cell whose result a cell_spill cell shows.
+	// See respill.
+	array     [][]value
+	spilled   []*Cell
+	spillFrom *Cell
 
 	// Recalculating is used during graph traversal to detect cycles.
 	recalculating bool
-	// ErrCycle is set when a cyclical computation error is detected.
-	errCycle bool
+	// dirty is set on cells in a lazy Sheet whose value is out of date. See (*Sheet).SetLazy.
+	dirty bool
 }
 
 // Create a new cell at CellAddress a in Sheet s
@@ -181,13 +236,15 @@ func (c *Cell) addDownstream(c2 *Cell) {
 	c.downstream = append(c.downstream, c2)
 }
 
-// deleteSelfIfNecessary prunes a Cell from its Sheet if it is blank and no
+// deleteSelfIfNecessary prunes a Cell from its Sheet if it is blank, has no format set, and no
 // other cells depend on it.
 func (c *Cell) deleteSelfIfNecessary() {
-	if c.cell_type != cell_transient {
+	if c.cell_type != cell_transient || c.userFormat != nil {
 		return
 	}
-	if len(c.downstream) == 0 {
+	// c may have been deleted already and another cell created at its address, as when an array
+	// spills into c while it's being cleared.
+	if len(c.downstream) == 0 && c.sheet.matrix[c.addr.col][c.addr.row] == c {
 		delete(c.sheet.matrix[c.addr.col], c.addr.row)
 	}
 }
@@ -210,10 +267,13 @@ func (c *Cell) removeDownstream(c2 *Cell) {
 // equation.
 func (c *Cell) EditValue() (string, error) {
 	switch c.cell_type {
-	case cell_transient:
+	case cell_transient, cell_spill:
 		return "", nil
 	case cell_val:
-		return fmt.Sprintf("%f", c.val), nil
+		if c.content != "" {
+			return c.content, nil
+		}
+		return formatEditNumber(c.val), nil
 	case cell_string:
 		return c.content, nil
 	case cell_expr:
@@ -226,6 +286,7 @@ func (c *Cell) EditValue() (string, error) {
 // Value returns the numeric value present in the Cell, including the value resulting from the
 // evaluation of an equation, or an error if there is no numeric value available.
 func (c *Cell) Value() (float64, error) {
+	c.refresh()
 	switch c.cell_type {
 	case cell_transient:
 		return 0, nil
@@ -233,9 +294,12 @@ func (c *Cell) Value() (float64, error) {
 		return 0, fmt.Errorf("Cannot get numeric value from %s", c.addr)
 	case cell_val:
 		return c.val, nil
-	case cell_expr:
+	case cell_expr, cell_spill:
 		if c.expErr != nil {
-			return 0, fmt.Errorf("%s: %v", c.addr, c.expErr)
+			return 0, fmt.Errorf("%s: %w", c.addr, c.expErr)
+		}
+		if c.resultKind == valString {
+			return 0, fmt.Errorf("Cannot get numeric value from %s", c.addr)
 		}
 		return c.val, nil
 	default:
@@ -243,23 +307,53 @@ func (c *Cell) Value() (float64, error) {
 	}
 }
 
+// value returns the value of the cell for use in an expression.
+func (c *Cell) value() (value, error) {
+	c.refresh()
+	switch c.cell_type {
+	case cell_transient:
+		return value{}, nil
+	case cell_string:
+		return stringValue(c.content), nil
+	case cell_val:
+		return value{kind: valNumber, num: c.val, rat: c.rat}, nil
+	case cell_expr, cell_spill:
+		if c.expErr != nil {
+			return value{}, fmt.Errorf("%s: %w", c.addr, c.expErr)
+		}
+		switch c.resultKind {
+		case valString:
+			return stringValue(c.content), nil
+		case valBool:
+			return boolValue(c.val != 0), nil
+		}
+		return value{kind: valNumber, num: c.val, rat: c.rat}, nil
+	default:
+		return value{}, fmt.Errorf("Invalid cell type.")
+	}
+}
+
 // Content returns a string representation of the value of the cell. This will be a string
 // representation of a number if the cell is numeric or has as equation that returns a result. It
 // will be an error message if an equation results in an error, or it will be a string if text was
 // entered into the cell.
 func (c *Cell) Content() (string, error) {
+	c.refresh()
 	switch c.cell_type {
 	case cell_transient:
 		return "", nil
 	case cell_string:
 		return c.content, nil
 	case cell_val:
-		return fmt.Sprintf("%f", c.val), nil
-	case cell_expr:
+		return c.formatValue(), nil
+	case cell_expr, cell_spill:
 		if c.expErr != nil {
 			return fmt.Sprintf("%s: %v", c.addr, c.expErr), nil
 		}
-		return fmt.Sprintf("%f", c.val), nil
+		if c.resultKind != valNumber {
+			return c.content, nil
+		}
+		return c.formatValue(), nil
 	default:
 		//panic(fmt.Sprintf("Invalid cell type %d", c.cell_type))
 		return "##ERROR", fmt.Errorf("Invalid cell type.")
@@ -270,66 +364,294 @@ func (c *Cell) Content() (string, error) {
 // by this cell's value. It will detect any dependency cycles present and set error messages on the
 // affected cells.
 func (c *Cell) Recalculate() {
+	old, _ := c.Content()
+	c.recalculate(old, CauseRecalc)
+}
+
+// recalculate does the work of Recalculate. old is the content of c before the change that caused
+// the recalculation, and cause is reported to subscribers along with the change to c.
+func (c *Cell) recalculate(old string, cause ChangeCause) {
+	if c.sheet.lazy {
+		c.markDirty()
+		c.sheet.cellUpdated(c, old, cause)
+		return
+	}
+	if c.sheet.RecalcWorkers > 1 {
+		c.sheet.recalculateParallel(c, old, cause)
+		return
+	}
 	if c.recalculating {
-		// We've hit a cycle.
-		if c.cell_type == cell_expr {
-			//fmt.Printf("CYCLICAL EQUATIONS\n")
-			c.expErr = fmt.Errorf("Cyclical equations detected.")
-		}
-		c.content = "##ERROR"
-		if !c.errCycle {
-			// If this is the first round through the cycle, continue and populate errors.
-			c.errCycle = true
-			defer func() { c.errCycle = false }()
-			for i := range c.upstream {
-				c.upstream[i].Recalculate()
-			}
-		}
+		// We've hit a cycle. Its cells were reported before the cells downstream of them were
+		// recalculated, so their errors are reported as other changes.
+		c.markCycles()
 		return
 	}
+	var changed []*Cell
+	defer func() {
+		for _, d := range changed {
+			if d.spillFrom != c {
+				// Cells newly spilled into are downstream of c, and recalculated with the rest.
+				d.Recalculate()
+			}
+		}
+	}()
 	c.recalculating = true
 	defer func() { c.recalculating = false }()
-	if c.sheet.OnCellUpdated != nil {
-		defer c.sheet.OnCellUpdated(c.addr.String(), c)
+	c.evaluate()
+	if c.relink() && c.inCycle() {
+		c.markCycle()
 	}
-	defer func() {
-		for i := range c.downstream {
-			c.downstream[i].Recalculate()
+	changed = c.respill()
+	// Subscribers are told about c before the changes it causes downstream.
+	c.sheet.cellUpdated(c, old, cause)
+	// Finding a cycle downstream may clear the cells c spilled into, removing them from
+	// c.downstream.
+	for _, d := range append([]*Cell(nil), c.downstream...) {
+		d.Recalculate()
+	}
+}
+
+// markCycles marks every cell of the dependency cycle c is part of, found while recalculating
+// serially, and clears what they spilled. The cells downstream of the cycle, which may have been
+// evaluated before it was found, are recalculated.
+func (c *Cell) markCycles() {
+	cycle := c.cycle()
+	member := make(map[*Cell]bool, len(cycle))
+	for _, cc := range cycle {
+		member[cc] = true
+	}
+	var changed []*Cell
+	for _, cc := range cycle {
+		prev, _ := cc.Content()
+		cc.markCycle()
+		changed = append(changed, cc.respill()...)
+		if now, _ := cc.Content(); now != prev {
+			c.sheet.cellUpdated(cc, prev, CauseRecalc)
 		}
-	}()
+	}
+	for _, cc := range cycle {
+		for _, d := range append([]*Cell(nil), cc.downstream...) {
+			if !member[d] {
+				d.Recalculate()
+			}
+		}
+	}
+	for _, d := range changed {
+		d.Recalculate()
+	}
+}
+
+// markCycle sets the error state on a Cell that is part of a dependency cycle. Only formulas can
+// be part of one.
+func (c *Cell) markCycle() {
+	c.dirty = false
+	if c.cell_type != cell_expr {
+		return
+	}
+	c.expErr = fmt.Errorf("Cyclical equations detected.")
+	c.content = "##ERROR"
+}
+
+// evaluate computes the value of this cell's expression, if it has one. It does not touch any other
+// cells, so the caller is responsible for evaluating upstream cells first.
+func (c *Cell) evaluate() {
+	c.dirty = false
+	if c.cell_type == cell_spill {
+		c.setResult(c.spillFrom.spillValue(c.addr), c.spillFrom.format)
+		return
+	}
 	if c.cell_type != cell_expr || c.exp == nil {
 		return
 	}
 
 	//fmt.Printf("RECALCULATING CELL @ %s -> ", c.addr)
-	f, err := c.exp.Eval(c.sheet)
+	c.array = nil
+	if c.hasDynamic {
+		c.dynamicRefs = c.exp.dynamicRefs(c.sheet)
+	}
+	v, err := c.exp.evalValue(c.sheet)
+	if err == nil && v.kind == valArray {
+		v, err = c.setArray(v.arr)
+	}
+	if err == nil && v.kind == valLambda {
+		err = errLambda
+	}
+	if err == nil && v.kind == valNumber && (math.IsNaN(v.num) || math.IsInf(v.num, 0)) {
+		// Functions should report this themselves, but NaN and Inf must never be shown.
+		err = fmt.Errorf("%w Result is not a finite number", ErrNum)
+	}
 	if err != nil {
 		//fmt.Println("ERROR")
+		c.array = nil
 		c.expErr = err
 		c.content = "##ERROR"
 		return
 	}
 	c.expErr = nil
-	c.val = f
-	c.content = fmt.Sprintf("%f", f)
+	c.setResult(v, c.exp.resultFormat(c.sheet))
 	//fmt.Printf("%s\n", c.content)
 }
 
+// setResult sets the value shown by c to v, displayed in format if it is a number.
+func (c *Cell) setResult(v value, format numberFormat) {
+	c.val = v.num
+	c.rat = v.rat
+	c.resultKind = v.kind
+	if v.kind == valBlank {
+		// A formula referring to an empty cell shows 0.
+		c.resultKind = valNumber
+	}
+	c.format = format
+	if c.resultKind == valNumber {
+		c.content = c.formatValue()
+	} else {
+		c.content = v.toString()
+	}
+}
+
+// relink updates the edges from the cells c references dynamically to c after an evaluation of c,
+// returning true if they changed. It modifies the dependency graph, so unlike evaluate, it must not
+// be called for several cells at once.
+func (c *Cell) relink() bool {
+	if sameRefs(c.linked, c.dynamicRefs) {
+		return false
+	}
+	c.upstream = c.upstream[:len(c.upstream)-len(c.dynamic)]
+	for _, u := range c.dynamic {
+		u.removeDownstream(c)
+	}
+	c.dynamic = c.linkUpstream(c.dynamicRefs)
+	c.linked = c.dynamicRefs
+	c.upstream = append(c.upstream, c.dynamic...)
+	return true
+}
+
+// linkUpstream returns the cells refs refer to, with c added downstream of each. References to
+// sheets that don't exist are left out.
+func (c *Cell) linkUpstream(refs []cellRef) []*Cell {
+	var cells []*Cell
+	for _, r := range refs {
+		if u := c.sheet.cellOrNewAtRef(r); u != nil {
+			u.addDownstream(c)
+			cells = append(cells, u)
+		}
+	}
+	return cells
+}
+
+// relinkAll rebuilds all of the edges from the cells c references to c, after a sheet it may refer
+// to has been added, renamed or removed.
+func (c *Cell) relinkAll() {
+	for _, u := range c.upstream {
+		u.removeDownstream(c)
+	}
+	c.upstream, c.dynamic, c.linked = nil, nil, nil
+	if c.exp == nil {
+		return
+	}
+	// The expression was checked by SetContent.
+	refs, _ := c.exp.upstreamRefs()
+	c.upstream = c.linkUpstream(refs)
+	c.compile()
+	c.dynamicRefs = c.exp.dynamicRefs(c.sheet)
+	c.relink()
+}
+
+// inCycle returns true if c is part of a dependency cycle.
+func (c *Cell) inCycle() bool {
+	return c.cycle() != nil
+}
+
+// cycle returns the cells of the dependency cycle c is part of, or nil if it isn't part of one.
+func (c *Cell) cycle() []*Cell {
+	for _, comp := range components([]*Cell{c}, downstreamOf) {
+		for _, cc := range comp {
+			if cc == c {
+				if isCycle(comp) {
+					return comp
+				}
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// formatValue returns c's numeric value formatted for display.
+func (c *Cell) formatValue() string {
+	if c.rat != nil {
+		return c.displayFormat().formatRat(c.rat)
+	}
+	return c.displayFormat().format(c.val)
+}
+
+// displayFormat returns the format c's value is displayed in.
+func (c *Cell) displayFormat() numberFormat {
+	if c.userFormat != nil {
+		return *c.userFormat
+	}
+	return c.format
+}
+
+// Format returns the specification of the format the value of c is displayed in. See
+// (*Sheet).SetFormat.
+func (c *Cell) Format() string {
+	return c.displayFormat().String()
+}
+
+// SetFormat sets the format the value of c is displayed in. An empty format removes any format
+// previously set, so the format is again detected from the content of the cell. See
+// (*Sheet).SetFormat for the formats available.
+func (c *Cell) SetFormat(format string) error {
+	var f *numberFormat
+	if format != "" {
+		nf, err := parseNumberFormat(format)
+		if err != nil {
+			return err
+		}
+		f = &nf
+	}
+	old, _ := c.Content()
+	defer c.deleteSelfIfNecessary()
+	defer c.recalculate(old, CauseEdit)
+	c.userFormat = f
+	return nil
+}
+
 // SetContent puts some value into the Cell, c. SetContent detects whether an equation, number, or
-// text was entered and recalculates the sheet accordingly.
+// text was entered and recalculates the sheet accordingly. An equation that can't be parsed is
+// still put into c, which shows the error, and SetContent returns the error, a *ParseError.
 func (c *Cell) SetContent(content string) error {
+	if c.cell_type == cell_spill {
+		if content == "" {
+			return nil
+		}
+		return fmt.Errorf("%s is part of the array spilled from %s and cannot be edited", c.addr, c.spillFrom.addr)
+	}
+	if content == "" {
+		// The array result of a formula may have been blocked from spilling into c.
+		defer c.sheet.recalculateBlocked(c.addr)
+	}
+	old, _ := c.Content()
 	defer c.deleteSelfIfNecessary()
-	defer c.Recalculate()
+	defer c.recalculate(old, CauseEdit)
 	if len(c.upstream) > 0 {
 		for i := range c.upstream {
 			c.upstream[i].removeDownstream(c)
 		}
 		c.upstream = nil
 	}
+	c.dynamic = nil
+	c.dynamicRefs = nil
+	c.linked = nil
+	c.array = nil
+	delete(c.sheet.volatile, c)
 	if content == "" {
-		*c = Cell{sheet: c.sheet, addr: c.addr, cell_type: cell_transient, downstream: c.downstream}
+		*c = Cell{sheet: c.sheet, addr: c.addr, cell_type: cell_transient, downstream: c.downstream, userFormat: c.userFormat, spilled: c.spilled}
 		return nil
 	}
+	c.format = numberFormat{}
+	c.rat = nil
 	if strings.HasPrefix(content, "=") {
 		c.cell_type = cell_expr
 		c.expstr = content
@@ -338,26 +660,33 @@ func (c *Cell) SetContent(content string) error {
 		if err != nil {
 			c.expErr = err
 			c.content = "##ERROR"
-			return nil
+			return err
 		}
-		upAddrs, err := expr.upstreamAddrs()
+		refs, err := expr.upstreamRefs()
 		if err != nil {
 			c.expErr = err
 			c.content = "##ERROR"
 			return nil
 		}
-
-		c.upstream = make([]*Cell, len(upAddrs))
-		for i := range upAddrs {
-			c.upstream[i] = c.sheet.cellOrNewAt(upAddrs[i])
-			c.upstream[i].addDownstream(c)
-		}
+		c.upstream = c.linkUpstream(refs)
 
 		c.exp = expr
-	} else if f, err := strconv.ParseFloat(content, 64); err == nil {
+		c.compile()
+		if expr.volatile() {
+			c.sheet.volatile[c] = true
+		}
+	} else if f, ok := parseNumber(content); ok {
 		c.cell_type = cell_val
 		c.val = f
-		c.content = fmt.Sprintf("%f", f)
+		c.content = content
+		if c.sheet.decimal {
+			c.rat = parseRat(content, f)
+		}
+	} else if f, format, ok := parseDateTime(content); ok {
+		c.cell_type = cell_val
+		c.val = f
+		c.format = format
+		c.content = content
 	} else {
 		c.cell_type = cell_string
 		c.content = content
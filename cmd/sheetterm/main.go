@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -32,9 +33,36 @@ func doCommand(st *sheet.Sheet, cfg *cfg, s *bufio.Scanner) (string, error) {
 			return "", fmt.Errorf("SET expects 2 arguments - SET [address] [value]")
 		}
 		err := st.SetContent(cmd[1], cmd[2])
+		var perr *sheet.ParseError
+		if errors.As(err, &perr) {
+			// Point at the problem in the formula.
+			return "", fmt.Errorf("%s\n%s^\n%s: %v", cmd[2], strings.Repeat(" ", perr.Pos), cmd[1], err)
+		}
+		if err != nil {
+			return "", err
+		}
+	case "FORMAT":
+		if len(cmd) < 2 {
+			return "", fmt.Errorf("FORMAT expects 1 or 2 arguments - FORMAT [address] [format]")
+		}
+		format := ""
+		if len(cmd) > 2 {
+			format = cmd[2]
+		}
+		err := st.SetFormat(cmd[1], format)
+		if err != nil {
+			return "", err
+		}
+	case "DEPS":
+		if len(cmd) < 2 {
+			return "", fmt.Errorf("DEPS expects 1 argument - DEPS [address]")
+		}
+		var b strings.Builder
+		err := st.WriteDependencyTree(&b, cmd[1])
 		if err != nil {
 			return "", err
 		}
+		return b.String(), nil
 	case "EDIT":
 		cfg.editMode = !cfg.editMode
 		return fmt.Sprintf("EDITMODE = %t", cfg.editMode), nil
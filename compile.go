@@ -0,0 +1,174 @@
+package sheet
+
+import (
+	"math/big"
+	"strconv"
+)
+
+// compile prepares the formula of c to be evaluated, and finds whether it has references that can
+// only be known by evaluating it. It must be called whenever the cells the formula refers to may
+// have changed: when it is set, and when a sheet it may refer to is added, renamed or removed.
+func (c *Cell) compile() {
+	c.exp.compile(c.sheet)
+	c.hasDynamic = c.exp.hasDynamicRefs()
+}
+
+// compile prepares e, a formula of a cell of s, to be evaluated without walking its tree, parsing
+// the addresses of its references or looking up the cells they refer to each time. The cells must
+// already exist, as they do once the formula's cell has been linked to them.
+//
+// References, numbers, strings and operations are compiled into closures over the closures of their
+// operands. Anything else, like calls, names and 3D references, is still evaluated by evalValue,
+// but the expressions inside of it are compiled. An expression that can't be compiled, like a
+// reference to a sheet that doesn't exist, is left to evalValue as well, which reports its error.
+func (e *Expression) compile(s *Sheet) {
+	e.compiled, e.cell = nil, nil
+	if e.left != nil {
+		e.left.compile(s)
+	}
+	if e.right != nil {
+		e.right.compile(s)
+	}
+	for _, a := range e.args {
+		a.compile(s)
+	}
+	if e.lastSheet != "" {
+		return
+	}
+	if e.sheet != "" {
+		t, err := s.refSheet(e.sheet)
+		if err != nil {
+			return
+		}
+		s = t
+	}
+
+	switch e.op {
+	case ID:
+		a, err := CellAddr(e.val)
+		if err != nil {
+			return
+		}
+		c := s.cellAt(a)
+		if c == nil {
+			return
+		}
+		e.cell = c
+		e.compiled = func(*Sheet) (value, error) {
+			return c.value()
+		}
+	case RANGE:
+		rng, err := CellRange(e.val)
+		if err != nil || rng.height()*rng.width() > maxRangeCells {
+			return
+		}
+		cells := make([][]*Cell, rng.height())
+		for i := range cells {
+			cells[i] = make([]*Cell, rng.width())
+			for j := range cells[i] {
+				cells[i][j] = s.cellAt(rng.addrAt(i, j))
+			}
+		}
+		e.compiled = func(*Sheet) (value, error) {
+			return cellsValue(cells)
+		}
+	case NUM:
+		f, err := strconv.ParseFloat(e.val, 64)
+		if err != nil {
+			return
+		}
+		r, ok := new(big.Rat).SetString(e.val)
+		if !ok {
+			return
+		}
+		num, exact := numberValue(f), ratValue(r)
+		e.compiled = func(s *Sheet) (value, error) {
+			if s.decimal {
+				return exact, nil
+			}
+			return num, nil
+		}
+	case STR:
+		v := stringValue(e.val)
+		e.compiled = func(*Sheet) (value, error) {
+			return v, nil
+		}
+	case BOOL:
+		v := boolValue(e.val == "TRUE")
+		e.compiled = func(*Sheet) (value, error) {
+			return v, nil
+		}
+	case NEG:
+		if e.left == nil {
+			return
+		}
+		operand := e.left.evalValue
+		e.compiled = func(s *Sheet) (value, error) {
+			v, err := operand(s)
+			if err != nil {
+				return value{}, err
+			}
+			return s.negate(v)
+		}
+	case CAT, ADD, SUB, MUL, DIV, EQ, NE, LT, LE, GT, GE:
+		if e.left == nil || e.right == nil {
+			return
+		}
+		o, left, right := e.op, e.left.evalValue, e.right.evalValue
+		e.compiled = func(s *Sheet) (value, error) {
+			l, err := left(s)
+			if err != nil {
+				return value{}, err
+			}
+			r, err := right(s)
+			if err != nil {
+				return value{}, err
+			}
+			switch o {
+			case CAT:
+				return concat(l, r)
+			case ADD, SUB, MUL, DIV:
+				return s.arithmetic(o, l, r)
+			}
+			return comparison(o, l, r)
+		}
+	}
+}
+
+// cellsValue returns the values of cells as an array, like rangeValue does for the cells of a
+// range. A nil cell is blank.
+func cellsValue(cells [][]*Cell) (value, error) {
+	rows := make([][]value, len(cells))
+	for i := range rows {
+		rows[i] = make([]value, len(cells[i]))
+		for j, c := range cells[i] {
+			if c == nil {
+				continue
+			}
+			v, err := c.value()
+			if err != nil {
+				return value{}, err
+			}
+			rows[i][j] = v
+		}
+	}
+	return arrayValue(rows), nil
+}
+
+// hasDynamicRefs returns true if e may refer to cells that are only known by evaluating it: through
+// functions like INDIRECT, 3D references, or names, whose definitions may change. The cells of a
+// formula without them are its upstreamRefs.
+func (e *Expression) hasDynamicRefs() bool {
+	found := false
+	e.walk(func(e *Expression) {
+		if e.lastSheet != "" || e.op == NAME {
+			found = true
+		}
+		if e.op == FUNC {
+			if f, ok := functions[e.val]; !ok || f.ref != nil {
+				found = true
+			}
+		}
+	})
+	return found
+}
@@ -0,0 +1,351 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02 15:04:05"
+	timeLayout     = "15:04:05"
+)
+
+// dateInputs are the layouts recognized by parseDateTime, along with the format of the resulting
+// cell.
+var dateInputs = []struct {
+	layout string
+	kind   formatKind
+}{
+	{"2006-01-02", formatDate},
+	{"2006-01-02T15:04:05Z07:00", formatDateTime},
+	{"2006-01-02T15:04:05", formatDateTime},
+	{"2006-01-02T15:04", formatDateTime},
+	{"2006-01-02 15:04:05", formatDateTime},
+	{"2006-01-02 15:04", formatDateTime},
+	{"15:04:05", formatTime},
+	{"15:04", formatTime},
+}
+
+// durationRE matches the ISO 8601 durations understood by parseDuration, such as P3D, PT1H30M or
+// P1W2DT12H. Years and months are not allowed, since they don't have a fixed length.
+var durationRE = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)W)?(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseDateTime recognizes an ISO 8601 date, date and time, time of day, or duration in s, and
+// returns its value as a serial date along with the format it should be displayed in.
+func parseDateTime(s string) (float64, numberFormat, bool) {
+	for _, in := range dateInputs {
+		t, err := time.Parse(in.layout, s)
+		if err != nil {
+			continue
+		}
+		v := timeToSerial(t)
+		if in.kind == formatTime {
+			v -= math.Floor(v)
+		}
+		return v, numberFormat{kind: in.kind}, true
+	}
+	if d, ok := parseDuration(s); ok {
+		return d, numberFormat{kind: formatDuration}, true
+	}
+	return 0, numberFormat{}, false
+}
+
+// parseDuration parses an ISO 8601 duration, returning its length in days.
+func parseDuration(s string) (float64, bool) {
+	m := durationRE.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, false
+	}
+	days := 0.0
+	for i, scale := range []float64{7, 1, 1.0 / 24, 1.0 / (24 * 60), 1.0 / (24 * 60 * 60)} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return 0, false
+		}
+		days += n * scale
+	}
+	return days, true
+}
+
+// formatDurationDays formats a duration of days as an ISO 8601 duration, such as P1DT2H30M.
+func formatDurationDays(days float64) string {
+	sign := ""
+	if days < 0 {
+		sign = "-"
+		days = -days
+	}
+	secs := int64(math.Round(days * 24 * 60 * 60))
+	d, secs := secs/86400, secs%86400
+	h, secs := secs/3600, secs%3600
+	m, secs := secs/60, secs%60
+
+	var b strings.Builder
+	b.WriteString(sign + "P")
+	if d > 0 {
+		fmt.Fprintf(&b, "%dD", d)
+	}
+	if h > 0 || m > 0 || secs > 0 {
+		b.WriteString("T")
+		if h > 0 {
+			fmt.Fprintf(&b, "%dH", h)
+		}
+		if m > 0 {
+			fmt.Fprintf(&b, "%dM", m)
+		}
+		if secs > 0 {
+			fmt.Fprintf(&b, "%dS", secs)
+		}
+	}
+	if d == 0 && h == 0 && m == 0 && secs == 0 {
+		b.WriteString("0D")
+	}
+	return b.String()
+}
+
+// serialToTime converts a serial date to a time in UTC, rounded to the nearest second.
+func serialToTime(v float64) time.Time {
+	days := math.Floor(v)
+	secs := math.Round((v - days) * 86400)
+	return serialEpoch.AddDate(0, 0, int(days)).Add(time.Duration(secs) * time.Second)
+}
+
+// dateArg converts v, a serial date argument of the date function name, to a date, discarding the
+// time of day. It returns an error wrapping ErrNum if v is not a date from year 1 to maxDateYear.
+func dateArg(name string, v float64) (time.Time, error) {
+	if !validSerial(v) {
+		return time.Time{}, fmt.Errorf("%w %s: date %v is out of range", ErrNum, name, v)
+	}
+	t := serialToTime(math.Floor(v))
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// maxDateYear is the largest year that may be given to DATE. Months and days given to the date
+// functions may be no more than this many years' worth.
+const maxDateYear = 9999
+
+// minSerial and maxSerial are the serial dates of the first day of year 1 and of the day after the
+// last day of year maxDateYear, which bound the serial dates that are shown as dates.
+var (
+	minSerial = timeToSerial(time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC))
+	maxSerial = timeToSerial(time.Date(maxDateYear+1, time.January, 1, 0, 0, 0, 0, time.UTC))
+)
+
+// validSerial returns true if v is a serial date from year 1 to maxDateYear.
+func validSerial(v float64) bool {
+	return v >= minSerial && v < maxSerial
+}
+
+// dateIntArg converts v, an argument of the date function name, to an integer. It returns an error
+// wrapping ErrNum if v is further than limit from 0.
+func dateIntArg(name string, v, limit float64) (int, error) {
+	if !(math.Abs(v) <= limit) {
+		return 0, fmt.Errorf("%w %s: %v is out of range", ErrNum, name, v)
+	}
+	return int(v), nil
+}
+
+// DATE(year, month, day) returns the serial date for the given day. Months and days outside of
+// their usual ranges roll over, so DATE(2026, 13, 1) is the first of January, 2027.
+func fnDate(s *Sheet, args []*Expression) (float64, error) {
+	vals, err := evalArgs(s, "DATE", args, 3, 3)
+	if err != nil {
+		return 0, err
+	}
+	var ymd [3]int
+	for i, limit := range []float64{maxDateYear, 12 * maxDateYear, 366 * maxDateYear} {
+		if ymd[i], err = dateIntArg("DATE", vals[i], limit); err != nil {
+			return 0, err
+		}
+	}
+	t := time.Date(ymd[0], time.Month(ymd[1]), ymd[2], 0, 0, 0, 0, time.UTC)
+	return timeToSerial(t), nil
+}
+
+// YEAR(date) returns the year of a serial date.
+func fnYear(s *Sheet, args []*Expression) (float64, error) {
+	vals, err := evalArgs(s, "YEAR", args, 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	t, err := dateArg("YEAR", vals[0])
+	if err != nil {
+		return 0, err
+	}
+	return float64(t.Year()), nil
+}
+
+// MONTH(date) returns the month of a serial date, from 1 to 12.
+func fnMonth(s *Sheet, args []*Expression) (float64, error) {
+	vals, err := evalArgs(s, "MONTH", args, 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	t, err := dateArg("MONTH", vals[0])
+	if err != nil {
+		return 0, err
+	}
+	return float64(t.Month()), nil
+}
+
+// DAY(date) returns the day of the month of a serial date.
+func fnDay(s *Sheet, args []*Expression) (float64, error) {
+	vals, err := evalArgs(s, "DAY", args, 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	t, err := dateArg("DAY", vals[0])
+	if err != nil {
+		return 0, err
+	}
+	return float64(t.Day()), nil
+}
+
+// addMonths adds months to t. If the day of t doesn't exist in the resulting month, the last day
+// of that month is used, so one month after January 31st is the last day of February.
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, time.UTC)
+}
+
+// EDATE(start, months) returns the date months after start. See addMonths.
+func fnEdate(s *Sheet, args []*Expression) (float64, error) {
+	vals, err := evalArgs(s, "EDATE", args, 2, 2)
+	if err != nil {
+		return 0, err
+	}
+	months, err := dateIntArg("EDATE", vals[1], 12*maxDateYear)
+	if err != nil {
+		return 0, err
+	}
+	start, err := dateArg("EDATE", vals[0])
+	if err != nil {
+		return 0, err
+	}
+	return timeToSerial(addMonths(start, months)), nil
+}
+
+// NETWORKDAYS(start, end, [holidays...]) returns the number of weekdays from start to end,
+// inclusive, not counting any of the holidays, which may be given as dates or ranges of them. If
+// end is before start, the result is negative.
+func fnNetworkDays(s *Sheet, args []*Expression) (float64, error) {
+	if err := checkArgCount("NETWORKDAYS", args, 2, -1); err != nil {
+		return 0, err
+	}
+	vals, err := evalArgs(s, "NETWORKDAYS", args[:2], 2, 2)
+	if err != nil {
+		return 0, err
+	}
+	var days [2]int
+	for i := range days {
+		if days[i], err = dateIntArg("NETWORKDAYS", math.Floor(vals[i]), maxExactInt); err != nil {
+			return 0, err
+		}
+	}
+	start, end := days[0], days[1]
+	sign := 1.0
+	if end < start {
+		start, end = end, start
+		sign = -1
+	}
+	hols, err := floatArgs(s, "NETWORKDAYS", args[2:], 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	holidays := make(map[int]bool)
+	for _, h := range hols {
+		d := int(math.Floor(h))
+		if d >= start && d <= end && isWeekday(d) {
+			holidays[d] = true
+		}
+	}
+	count := weekdaysBefore(end+1) - weekdaysBefore(start) - len(holidays)
+	return sign * float64(count), nil
+}
+
+// isWeekday returns true if the serial date day is neither a Saturday nor a Sunday.
+func isWeekday(day int) bool {
+	w := (int(serialEpoch.Weekday()) + day%7 + 7) % 7
+	return w != int(time.Saturday) && w != int(time.Sunday)
+}
+
+// weekdaysBefore returns the number of weekdays from serial date 0 up to, but not including, day,
+// or minus the number from day up to 0 if day is negative.
+func weekdaysBefore(day int) int {
+	weeks := day / 7
+	if day%7 < 0 {
+		weeks--
+	}
+	// Every whole week has 5 weekdays, and the days after them start on the weekday of day 0.
+	count := 5 * weeks
+	for d := 7 * weeks; d < day; d++ {
+		if isWeekday(d) {
+			count++
+		}
+	}
+	return count
+}
+
+// DATEDIF(start, end, unit) returns the difference between two dates in the given unit: "Y" for
+// whole years, "M" for whole months, "D" for days, "MD" for days ignoring months and years, "YM"
+// for months ignoring years, and "YD" for days ignoring years. start must not be after end.
+func fnDateDif(s *Sheet, args []*Expression) (float64, error) {
+	if len(args) != 3 {
+		return 0, fmt.Errorf("DATEDIF expects 3 arguments, but got %d", len(args))
+	}
+	vals, err := evalArgs(s, "DATEDIF", args[:2], 2, 2)
+	if err != nil {
+		return 0, err
+	}
+	u, err := args[2].evalValue(s)
+	if err != nil {
+		return 0, err
+	}
+	unit := u.toString()
+	start, err := dateArg("DATEDIF", vals[0])
+	if err != nil {
+		return 0, err
+	}
+	end, err := dateArg("DATEDIF", vals[1])
+	if err != nil {
+		return 0, err
+	}
+	if end.Before(start) {
+		return 0, fmt.Errorf("DATEDIF: start date is after end date")
+	}
+	months := (end.Year()-start.Year())*12 + int(end.Month()-start.Month())
+	if end.Day() < start.Day() {
+		months--
+	}
+	switch strings.ToUpper(unit) {
+	case "Y":
+		return float64(months / 12), nil
+	case "M":
+		return float64(months), nil
+	case "D":
+		return timeToSerial(end) - timeToSerial(start), nil
+	case "MD":
+		return timeToSerial(end) - timeToSerial(addMonths(start, months)), nil
+	case "YM":
+		return float64(months % 12), nil
+	case "YD":
+		from := time.Date(end.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		if from.After(end) {
+			from = time.Date(end.Year()-1, start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		return timeToSerial(end) - timeToSerial(from), nil
+	}
+	return 0, fmt.Errorf("DATEDIF: unknown unit %q", unit)
+}
@@ -0,0 +1,256 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// SetDecimal selects whether s does arithmetic with exact decimal numbers. In a decimal sheet,
+// numbers entered into cells and written in equations are kept as exact rationals (see
+// math/big.Rat), and addition, subtraction, multiplication, division and rounding are done
+// exactly, so =0.1+0.2 is exactly 0.3. Functions without an exact implementation are computed with
+// float64 as usual. ValueAt still returns the nearest float64; ExactValueAt returns the exact
+// value.
+//
+// Changing the mode recalculates the whole sheet.
+func (s *Sheet) SetDecimal(decimal bool) {
+	if s.decimal == decimal {
+		return
+	}
+	s.decimal = decimal
+	for _, rows := range s.matrix {
+		for _, c := range rows {
+			c.rat = nil
+			if decimal && c.cell_type == cell_val {
+				c.rat = parseRat(c.content, c.val)
+			}
+		}
+	}
+	s.RecalculateAll()
+}
+
+// Decimal returns true if s does exact decimal arithmetic. See SetDecimal.
+func (s *Sheet) Decimal() bool {
+	return s.decimal
+}
+
+// ExactValueAt returns the exact numeric value at address addr in s. In a sheet that is not in
+// decimal mode, this is the float64 value returned by ValueAt. See SetDecimal.
+func (s *Sheet) ExactValueAt(addr string) (*big.Rat, error) {
+	a, err := CellAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	cell := s.cellAt(a)
+	if cell == nil {
+		return new(big.Rat), nil
+	}
+	r, err := cell.exactValue()
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).Set(r), nil
+}
+
+// parseRat returns the exact value of the number literal text, or of f if text isn't a decimal
+// number, as for dates.
+func parseRat(text string, f float64) *big.Rat {
+	if r, ok := new(big.Rat).SetString(text); ok && !strings.Contains(text, "/") {
+		return r
+	}
+	return floatRat(f)
+}
+
+// floatRat returns the exact value of f, or nil if f is infinite or NaN.
+func floatRat(f float64) *big.Rat {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil
+	}
+	return new(big.Rat).SetFloat64(f)
+}
+
+// decimalRat returns f, an argument of the function name, as the exact value of its shortest
+// decimal representation.
+func decimalRat(name string, f float64) (*big.Rat, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("%s: %v is not a finite number", name, f)
+	}
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return r, nil
+}
+
+// exactValue is like Value, but returns the exact value of a cell in a decimal sheet.
+func (c *Cell) exactValue() (*big.Rat, error) {
+	f, err := c.Value()
+	if err != nil {
+		return nil, err
+	}
+	if c.rat != nil && (c.cell_type == cell_val || c.cell_type == cell_expr) {
+		return c.rat, nil
+	}
+	r := floatRat(f)
+	if r == nil {
+		return nil, fmt.Errorf("%s: %v is not a finite number", c.addr, f)
+	}
+	return r, nil
+}
+
+// formatRat returns the display string for the exact value r. Formats that show a fixed number of
+// decimals round r exactly, with halves rounded away from zero. Numbers shown in the general
+// format are shown exactly when they have a finite decimal expansion.
+func (f numberFormat) formatRat(r *big.Rat) string {
+	v, _ := r.Float64()
+	abs := new(big.Rat).Abs(r)
+	switch f.kind {
+	case formatNumber:
+		return f.decorate(v, groupDigits(abs.FloatString(f.decimals), f.group))
+	case formatPercent:
+		abs.Mul(abs, big.NewRat(100, 1))
+		return f.decorate(v, groupDigits(abs.FloatString(f.decimals), f.group)+"%")
+	case formatGeneral:
+		if digits, ok := decimalDigits(r); ok && digits <= 30 {
+			return trimZeros(r.FloatString(digits))
+		}
+	}
+	return f.format(v)
+}
+
+// decimalDigits returns the number of digits after the decimal point needed to write r exactly, or
+// false if r has no finite decimal expansion.
+func decimalDigits(r *big.Rat) (int, bool) {
+	d := new(big.Int).Set(r.Denom())
+	twos := int(d.TrailingZeroBits())
+	d.Rsh(d, uint(twos))
+	// The factors of 5 are divided out by 5, 5^2, 5^4, ... up to the largest no more than d, the
+	// largest first, so that a denominator with many of them takes few divisions.
+	pows := []*big.Int{big.NewInt(5)}
+	for {
+		last := pows[len(pows)-1]
+		sq := new(big.Int).Mul(last, last)
+		if sq.Cmp(d) > 0 {
+			break
+		}
+		pows = append(pows, sq)
+	}
+	fives := 0
+	q, m := new(big.Int), new(big.Int)
+	for i := len(pows) - 1; i >= 0; i-- {
+		if q.QuoRem(d, pows[i], m); m.Sign() == 0 {
+			d, q = q, d
+			fives += 1 << i
+		}
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
+
+// trimZeros removes trailing zeros after the decimal point of num.
+func trimZeros(num string) string {
+	if !strings.Contains(num, ".") {
+		return num
+	}
+	return strings.TrimSuffix(strings.TrimRight(num, "0"), ".")
+}
+
+// roundMode selects how roundRat rounds.
+type roundMode int
+
+const (
+	// roundHalfAway rounds to the nearest number, with halves rounded away from zero.
+	roundHalfAway roundMode = iota
+	// roundUp rounds away from zero.
+	roundUp
+	// roundDown rounds toward zero.
+	roundDown
+)
+
+// roundRat rounds r to digits decimal places. digits may be negative to round to tens, hundreds
+// and so on.
+func roundRat(r *big.Rat, digits int, mode roundMode) *big.Rat {
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(absInt(digits))), nil))
+	x := new(big.Rat).Abs(r)
+	if digits >= 0 {
+		x.Mul(x, scale)
+	} else {
+		x.Quo(x, scale)
+	}
+	q, rem := new(big.Int).QuoRem(x.Num(), x.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		switch mode {
+		case roundUp:
+			q.Add(q, big.NewInt(1))
+		case roundHalfAway:
+			// Round up when rem / denom >= 1/2.
+			if new(big.Int).Mul(rem, big.NewInt(2)).Cmp(x.Denom()) >= 0 {
+				q.Add(q, big.NewInt(1))
+			}
+		}
+	}
+	res := new(big.Rat).SetInt(q)
+	if digits >= 0 {
+		res.Quo(res, scale)
+	} else {
+		res.Mul(res, scale)
+	}
+	if r.Sign() < 0 {
+		res.Neg(res)
+	}
+	return res
+}
+
+func absInt(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// maxRoundDigits is the most digits to either side of the decimal point that numbers are rounded
+// to. No float64 written out in decimal has digits further from the point than this, and rounding
+// exact numbers any further would be slow.
+const maxRoundDigits = 400
+
+// roundFunction returns the function for ROUND, ROUNDUP or ROUNDDOWN, which take a number and a
+// number of digits to round it to. Outside of decimal mode, the number is rounded as it would be
+// written out in decimal, so ROUND(2.675, 2) is 2.68 even though the nearest float64 to 2.675 is
+// slightly less than it.
+func roundFunction(name string, mode roundMode) function {
+	return function{
+		eval: func(s *Sheet, args []*Expression) (value, error) {
+			vals, err := evalValueArgs(s, name, args, 2, 2)
+			if err != nil {
+				return value{}, err
+			}
+			digits, err := vals[1].toNumber()
+			if err != nil {
+				return value{}, err
+			}
+			digits = math.Max(-maxRoundDigits, math.Min(maxRoundDigits, digits))
+			if s.decimal {
+				r, err := vals[0].toRat()
+				if err != nil {
+					return value{}, err
+				}
+				return ratValue(roundRat(r, int(digits), mode)), nil
+			}
+			f, err := vals[0].toNumber()
+			if err != nil {
+				return value{}, err
+			}
+			r, err := decimalRat(name, f)
+			if err != nil {
+				return value{}, err
+			}
+			f, _ = roundRat(r, int(digits), mode).Float64()
+			return numberValue(f), nil
+		},
+	}
+}
@@ -0,0 +1,151 @@
+package sheet
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecimalArithmetic(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetDecimal(true)
+	assert.True(sheet.Decimal())
+	sheet.SetContent("A1", "0.1")
+	sheet.SetContent("A2", "0.2")
+	sheet.SetContent("A3", "=A1+A2")
+	sheet.SetContent("A4", "=A3-0.3")
+	sheet.SetContent("A5", "=1/3*3")
+	sheet.SetContent("A6", "=1/3")
+	sheet.SetContent("A7", "=-A1*1e-2")
+	sheet.SetContent("A8", "12345678901234567890.123")
+	sheet.SetContent("A9", "=A8+0.001")
+
+	for addr, want := range map[string]string{
+		"A3": "0.3",
+		"A4": "0",
+		"A5": "1",
+		"A6": "0.333333333333333",
+		"A7": "-0.001",
+		"A9": "12345678901234567890.124",
+	} {
+		c, err := sheet.ContentAt(addr)
+		assert.NoError(err)
+		assert.Equal(want, c, addr)
+	}
+
+	r, err := sheet.ExactValueAt("A3")
+	assert.NoError(err)
+	assert.Equal(0, r.Cmp(big.NewRat(3, 10)))
+	v, err := sheet.ValueAt("A3")
+	assert.NoError(err)
+	assert.Equal(0.3, v)
+
+	sheet.SetDecimal(false)
+	c, err := sheet.ContentAt("A4")
+	assert.NoError(err)
+	assert.Equal("5.55111512312578e-17", c)
+
+	sheet.SetDecimal(true)
+	c, err = sheet.ContentAt("A4")
+	assert.NoError(err)
+	assert.Equal("0", c)
+}
+
+func TestDecimalFormats(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetDecimal(true)
+	sheet.SetContent("A1", "1.005")
+	sheet.SetFormat("A1", "currency")
+	sheet.SetContent("A2", "=A1*1000000")
+	sheet.SetContent("A3", "0.125")
+	sheet.SetFormat("A3", "percent:1")
+	sheet.SetContent("A4", "2026-10-16")
+	sheet.SetContent("A5", "=A4+1")
+
+	for addr, want := range map[string]string{
+		"A1": "$1.01",
+		"A2": "$1,005,000.00",
+		"A3": "12.5%",
+		"A5": "2026-10-17",
+	} {
+		c, err := sheet.ContentAt(addr)
+		assert.NoError(err)
+		assert.Equal(want, c, addr)
+	}
+}
+
+func TestDecimalDigits(t *testing.T) {
+	pow := func(base, exp int64) *big.Int {
+		return new(big.Int).Exp(big.NewInt(base), big.NewInt(exp), nil)
+	}
+	for name, tt := range map[string]struct {
+		denom  *big.Int
+		digits int
+		ok     bool
+	}{
+		"one":       {denom: big.NewInt(1), digits: 0, ok: true},
+		"twos":      {denom: big.NewInt(8), digits: 3, ok: true},
+		"mixed":     {denom: big.NewInt(40), digits: 3, ok: true},
+		"thirds":    {denom: big.NewInt(3), ok: false},
+		"huge":      {denom: pow(10, 100000), digits: 100000, ok: true},
+		"huge/five": {denom: new(big.Int).Mul(pow(5, 70001), big.NewInt(32)), digits: 70001, ok: true},
+		"huge/odd":  {denom: new(big.Int).Mul(pow(5, 70001), big.NewInt(3)), ok: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			digits, ok := decimalDigits(new(big.Rat).SetFrac(big.NewInt(1), tt.denom))
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.digits, digits)
+			}
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	cells := map[string]string{
+		"A1": "2.675",
+		"A2": "-2.5",
+		"A3": "1234.5678",
+		"B1": "=ROUND(A1,2)",
+		"B2": "=ROUND(A2,0)",
+		"B3": "=ROUND(A3,-2)",
+		"B4": "=ROUNDUP(A3,1)",
+		"B5": "=ROUNDDOWN(A3,1)",
+		"B6": "=ROUNDUP(A2,0)",
+		"B7": "=ROUNDDOWN(A2,0)",
+		"B8": "=ROUND(0.1+0.2,15)",
+		"B9": "=ROUND(A1)",
+		"C1": "=ROUND(1.5,1e300)",
+		"C2": "=ROUND(1.5,-1e300)",
+		"C3": "=ROUNDDOWN(A3,-1e300)",
+	}
+	expect := map[string]string{
+		"B1": "2.68",
+		"B2": "-3",
+		"B3": "1200",
+		"B4": "1234.6",
+		"B5": "1234.5",
+		"B6": "-3",
+		"B7": "-2",
+		"B8": "0.3",
+		"B9": "B9: ROUND expects 2 arguments, but got 1",
+		"C1": "1.5",
+		"C2": "0",
+		"C3": "0",
+	}
+	for _, decimal := range []bool{false, true} {
+		sheet := NewSheet()
+		sheet.SetDecimal(decimal)
+		for addr, content := range cells {
+			assert.NoError(t, sheet.SetContent(addr, content))
+		}
+		for addr, want := range expect {
+			got, err := sheet.ContentAt(addr)
+			assert.NoError(t, err)
+			assert.Equal(t, want, got, "%s decimal=%t", addr, decimal)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package sheet
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Precedents returns the addresses of the cells used to compute the cell at addr. If transitive is
+// true, the cells those cells depend on are included as well, all the way up the dependency graph.
+// The addresses are sorted by row, then column. A cell with no formula has no precedents.
+func (s *Sheet) Precedents(addr string, transitive bool) ([]CellAddress, error) {
+	return s.related(addr, transitive, func(c *Cell) []*Cell { return c.upstream })
+}
+
+// Dependents returns the addresses of the cells whose formulas use the cell at addr. If transitive
+// is true, the cells depending on those cells are included as well, all the way down the
+// dependency graph. The addresses are sorted by row, then column.
+func (s *Sheet) Dependents(addr string, transitive bool) ([]CellAddress, error) {
+	return s.related(addr, transitive, func(c *Cell) []*Cell { return c.downstream })
+}
+
+// related walks the dependency graph from the cell at addr, following the edges returned by next.
+// Cells of other sheets of a Workbook are walked through, but left out of the addresses returned.
+func (s *Sheet) related(addr string, transitive bool, next func(*Cell) []*Cell) ([]CellAddress, error) {
+	a, err := CellAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	start := s.cellAt(a)
+	if start == nil {
+		return nil, nil
+	}
+	seen := map[*Cell]bool{start: true}
+	var found []*Cell
+	queue := []*Cell{start}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, n := range next(c) {
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			found = append(found, n)
+			if transitive {
+				queue = append(queue, n)
+			}
+		}
+	}
+	addrs := make([]CellAddress, 0, len(found))
+	for _, c := range found {
+		if c.sheet == s {
+			addrs = append(addrs, c.addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].less(addrs[j]) })
+	return addrs, nil
+}
+
+// WriteDependencyTree writes a human-readable tree of the cells the cell at addr depends on, and
+// of the cells that depend on it, to w. Each line shows a cell's address and the value that would
+// be shown when editing it. A cell that has already been expanded is marked rather than repeated,
+// so cycles and shared precedents do not make the tree grow without bound.
+func (s *Sheet) WriteDependencyTree(w io.Writer, addr string) error {
+	a, err := CellAddr(addr)
+	if err != nil {
+		return err
+	}
+	c := s.cellAt(a)
+	if c == nil {
+		_, err = fmt.Fprintf(w, "%s\n", a)
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", treeLabel(c)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "precedents:\n"); err != nil {
+		return err
+	}
+	if err := writeTree(w, c, 1, map[*Cell]bool{c: true}, func(c *Cell) []*Cell { return c.upstream }); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "dependents:\n"); err != nil {
+		return err
+	}
+	return writeTree(w, c, 1, map[*Cell]bool{c: true}, func(c *Cell) []*Cell { return c.downstream })
+}
+
+// writeTree writes the cells returned by next(c), and their children, indented by depth.
+func writeTree(w io.Writer, c *Cell, depth int, seen map[*Cell]bool, next func(*Cell) []*Cell) error {
+	children := append([]*Cell(nil), next(c)...)
+	sort.Slice(children, func(i, j int) bool { return children[i].addr.less(children[j].addr) })
+	indent := strings.Repeat("  ", depth)
+	for i, n := range children {
+		if i > 0 && children[i-1] == n {
+			// The same cell may be referenced more than once by a formula.
+			continue
+		}
+		if seen[n] {
+			if _, err := fmt.Fprintf(w, "%s%s ...\n", indent, n.addr); err != nil {
+				return err
+			}
+			continue
+		}
+		seen[n] = true
+		if _, err := fmt.Fprintf(w, "%s%s\n", indent, treeLabel(n)); err != nil {
+			return err
+		}
+		if err := writeTree(w, n, depth+1, seen, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeLabel returns the address of c followed by its edit value, if it has one.
+func treeLabel(c *Cell) string {
+	v, _ := c.EditValue()
+	if v == "" {
+		return c.addr.String()
+	}
+	return fmt.Sprintf("%s %s", c.addr, v)
+}
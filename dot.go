@@ -0,0 +1,163 @@
+package sheet
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteDOT writes the dependency graph of the cells in r to w in the Graphviz DOT language. Each
+// cell is a node labeled with its address and edit value (the formula, for cells with one), and
+// each reference from a formula to another cell is an edge from the referenced cell. Cells outside
+// of r that are referenced by cells in r are drawn dashed. Cells that are members of a dependency
+// cycle are highlighted in red.
+//
+// A range referenced by a formula, as in =SUM(A1:A100), is drawn as a single node with an edge to
+// the cell whose formula references it, rather than as an edge from each cell in the range. Only
+// the cells of the range that are inside of r are drawn, with edges to the range node. References
+// to other sheets of a Workbook are left out.
+func (s *Sheet) WriteDOT(w io.Writer, r Range) error {
+	var cells []*Cell
+	for col, rows := range s.matrix {
+		for row, c := range rows {
+			if r.Contains(CellAddress{col: col, row: row}) {
+				cells = append(cells, c)
+			}
+		}
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i].addr.less(cells[j].addr) })
+
+	cyclic := make(map[*Cell]bool)
+	for _, comp := range components(cells, downstreamOf) {
+		if isCycle(comp) {
+			for _, c := range comp {
+				cyclic[c] = true
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph sheet {\n")
+	b.WriteString("\tnode [shape=box];\n")
+	written := make(map[*Cell]bool)
+	writeNode := func(c *Cell) {
+		if written[c] {
+			return
+		}
+		written[c] = true
+		attrs := []string{fmt.Sprintf("label=%s", dotQuote(treeLabelLines(c)))}
+		if !r.Contains(c.addr) {
+			attrs = append(attrs, "style=dashed")
+		}
+		if cyclic[c] {
+			attrs = append(attrs, "color=red", "fontcolor=red")
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(c.addr.String()), strings.Join(attrs, ", "))
+	}
+	for _, c := range cells {
+		writeNode(c)
+	}
+	writtenRanges := make(map[Range]bool)
+	writeRange := func(rng Range) (cycle bool) {
+		addrs := rng.addrs()
+		for _, a := range addrs {
+			if c := s.cellAt(a); c != nil && cyclic[c] {
+				cycle = true
+			}
+		}
+		if writtenRanges[rng] {
+			return cycle
+		}
+		writtenRanges[rng] = true
+		attrs := []string{fmt.Sprintf("label=%s", dotQuote(rng.String())), "shape=folder"}
+		if cycle {
+			attrs = append(attrs, "color=red", "fontcolor=red")
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(rng.String()), strings.Join(attrs, ", "))
+		for _, a := range addrs {
+			if c := s.cellAt(a); c != nil && r.Contains(a) {
+				attrs := ""
+				if cyclic[c] {
+					attrs = " [color=red]"
+				}
+				fmt.Fprintf(&b, "\t%s -> %s%s;\n", dotQuote(a.String()), dotQuote(rng.String()), attrs)
+			}
+		}
+		return cycle
+	}
+	for _, c := range cells {
+		direct := make(map[CellAddress]bool)
+		var rngs []Range
+		if c.exp != nil {
+			c.exp.walk(func(e *Expression) {
+				if e.sheet != "" {
+					return
+				}
+				switch e.op {
+				case ID:
+					if a, err := CellAddr(e.val); err == nil {
+						direct[a] = true
+					}
+				case RANGE:
+					if rng, err := CellRange(e.val); err == nil {
+						rngs = append(rngs, rng)
+					}
+				}
+			})
+		}
+		for _, d := range c.dynamic {
+			if d.sheet == s {
+				direct[d.addr] = true
+			}
+		}
+		if c.spillFrom != nil {
+			direct[c.spillFrom.addr] = true
+		}
+		ups := append([]*Cell(nil), c.upstream...)
+		sort.Slice(ups, func(i, j int) bool { return ups[i].addr.less(ups[j].addr) })
+		for i, u := range ups {
+			if i > 0 && ups[i-1] == u || u.sheet != s || !direct[u.addr] {
+				continue
+			}
+			writeNode(u)
+			attrs := ""
+			if cyclic[u] && cyclic[c] {
+				attrs = " [color=red]"
+			}
+			fmt.Fprintf(&b, "\t%s -> %s%s;\n", dotQuote(u.addr.String()), dotQuote(c.addr.String()), attrs)
+		}
+		seen := make(map[Range]bool)
+		for _, rng := range rngs {
+			if seen[rng] {
+				continue
+			}
+			seen[rng] = true
+			attrs := ""
+			if writeRange(rng) && cyclic[c] {
+				attrs = " [color=red]"
+			}
+			fmt.Fprintf(&b, "\t%s -> %s%s;\n", dotQuote(rng.String()), dotQuote(c.addr.String()), attrs)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// treeLabelLines is like treeLabel, but puts the edit value on its own line.
+func treeLabelLines(c *Cell) string {
+	v, _ := c.EditValue()
+	if v == "" {
+		return c.addr.String()
+	}
+	return fmt.Sprintf("%s\n%s", c.addr, v)
+}
+
+// dotQuote returns s as a quoted DOT string.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
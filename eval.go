@@ -2,41 +2,386 @@ package sheet
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
 )
 
+// Eval evaluates e in s, returning its numeric value.
 func (e *Expression) Eval(s *Sheet) (float64, error) {
+	v, err := e.evalValue(s)
+	if err != nil {
+		return 0, err
+	}
+	return v.toNumber()
+}
+
+// evalValue evaluates e in s. In a sheet in decimal mode, numbers and arithmetic are exact.
+func (e *Expression) evalValue(s *Sheet) (value, error) {
+	if e.compiled != nil {
+		return e.compiled(s)
+	}
+	if e.lastSheet != "" {
+		return s.spanValue(e)
+	}
+	if e.sheet != "" {
+		// A reference to another sheet, which is evaluated there.
+		t, err := s.refSheet(e.sheet)
+		if err != nil {
+			return value{}, err
+		}
+		s = t
+	}
 	if e.op == ID {
-		return s.ValueAt(e.val)
+		a, err := CellAddr(e.val)
+		if err != nil {
+			return value{}, err
+		}
+		c := s.cellAt(a)
+		if c == nil {
+			return value{}, nil
+		}
+		return c.value()
 	}
 
 	switch e.op {
-	case ADD:
+	case RANGE:
+		rng, err := CellRange(e.val)
+		if err != nil {
+			return value{}, err
+		}
+		return s.rangeValue(rng)
+	case NUM:
+		if s.decimal {
+			r, ok := new(big.Rat).SetString(e.val)
+			if !ok {
+				return value{}, fmt.Errorf("Invalid number %s", e.val)
+			}
+			return ratValue(r), nil
+		}
+		f, err := strconv.ParseFloat(e.val, 64)
+		if err != nil {
+			return value{}, err
+		}
+		return numberValue(f), nil
+	case STR:
+		return stringValue(e.val), nil
+	case BOOL:
+		return boolValue(e.val == "TRUE"), nil
+	case NAME:
+		if d := s.definition(e); d != nil {
+			return d.evalValue(s)
+		}
+		return value{}, fmt.Errorf("Unknown name %s", e.val)
+	case CONST:
+		return e.bound, nil
+	case CALL:
+		callee, err := e.left.evalValue(s)
+		if err != nil {
+			return value{}, err
+		}
+		if callee.kind != valLambda {
+			return value{}, fmt.Errorf("Only a LAMBDA can be called")
+		}
+		return callee.fn.call(s, e.args, e.depth)
+	case FUNC:
+		f, ok := functions[e.val]
+		if !ok {
+			if d := s.definition(e); d != nil {
+				fn, err := d.evalValue(s)
+				if err != nil {
+					return value{}, err
+				}
+				if fn.kind != valLambda {
+					return value{}, fmt.Errorf("%s is not a LAMBDA", e.val)
+				}
+				return fn.fn.call(s, e.args, e.depth)
+			}
+			return value{}, fmt.Errorf("Unknown function %s", e.val)
+		}
+		if f.ref != nil {
+			t, rng, err := f.ref(s, e.args)
+			if err != nil {
+				return value{}, err
+			}
+			return t.rangeValue(rng)
+		}
+		return f.eval(s, e.args)
+	case NEG:
 		if e.left == nil {
-			return 0, fmt.Errorf("Bad Expression: %#v", e)
+			return value{}, fmt.Errorf("Bad expression: %#v", e)
 		}
-		l, err := e.left.Eval(s)
+		v, err := e.left.evalValue(s)
 		if err != nil {
-			return 0, err
+			return value{}, err
+		}
+		return s.negate(v)
+	case CAT:
+		if e.left == nil || e.right == nil {
+			return value{}, fmt.Errorf("Bad expression: %#v", e)
 		}
+		l, err := e.left.evalValue(s)
+		if err != nil {
+			return value{}, err
+		}
+		r, err := e.right.evalValue(s)
+		if err != nil {
+			return value{}, err
+		}
+		return concat(l, r)
+	case ADD, SUB, MUL, DIV:
+		if e.left == nil || e.right == nil {
+			return value{}, fmt.Errorf("Bad expression: %#v", e)
+		}
+		l, err := e.left.evalValue(s)
+		if err != nil {
+			return value{}, err
+		}
+		r, err := e.right.evalValue(s)
+		if err != nil {
+			return value{}, err
+		}
+		return s.arithmetic(e.op, l, r)
+	case EQ, NE, LT, LE, GT, GE:
+		if e.left == nil || e.right == nil {
+			return value{}, fmt.Errorf("Bad expression: %#v", e)
+		}
+		l, err := e.left.evalValue(s)
+		if err != nil {
+			return value{}, err
+		}
+		r, err := e.right.evalValue(s)
+		if err != nil {
+			return value{}, err
+		}
+		return comparison(e.op, l, r)
+	default:
+		panic("BAD OP VAL")
+	}
+}
 
-		if e.right == nil {
-			return 0, fmt.Errorf("Bad expression: %#v", e)
+// negate returns the negation of v, or of each of its elements if it is an array.
+func (s *Sheet) negate(v value) (value, error) {
+	return elementwise(v, value{}, func(v, _ value) (value, error) {
+		if s.decimal {
+			r, err := v.toRat()
+			if err != nil {
+				return value{}, err
+			}
+			return ratValue(new(big.Rat).Neg(r)), nil
 		}
-		r, err := e.right.Eval(s)
+		f, err := v.toNumber()
 		if err != nil {
-			return 0, err
+			return value{}, err
+		}
+		return numberValue(-f), nil
+	})
+}
+
+// concat returns the text of l followed by the text of r, elementwise if either is an array.
+func concat(l, r value) (value, error) {
+	return elementwise(l, r, func(l, r value) (value, error) {
+		return stringValue(l.toString() + r.toString()), nil
+	})
+}
+
+// arithmetic returns the result of the arithmetic operation o on l and r, elementwise if either is
+// an array.
+func (s *Sheet) arithmetic(o op, l, r value) (value, error) {
+	return elementwise(l, r, func(l, r value) (value, error) {
+		if s.decimal {
+			return arithRat(o, l, r)
 		}
+		return arith(o, l, r)
+	})
+}
+
+// comparison returns the result of the comparison o of l and r, TRUE or FALSE, elementwise if
+// either is an array. Text is compared ignoring case. Values of different kinds are ordered as by
+// orderValues, so numbers are less than text, which is less than logical values. A blank is equal
+// to 0, "" or FALSE, whichever is of the same kind as the other value.
+func comparison(o op, l, r value) (value, error) {
+	return elementwise(l, r, func(l, r value) (value, error) {
+		if l.kind == valLambda || r.kind == valLambda {
+			return value{}, errLambda
+		}
+		l, r = blankAs(l, r), blankAs(r, l)
+		cmp := orderValues(l, r)
+		switch o {
+		case EQ:
+			return boolValue(cmp == 0), nil
+		case NE:
+			return boolValue(cmp != 0), nil
+		case LT:
+			return boolValue(cmp < 0), nil
+		case LE:
+			return boolValue(cmp <= 0), nil
+		case GT:
+			return boolValue(cmp > 0), nil
+		}
+		return boolValue(cmp >= 0), nil
+	})
+}
+
+// blankAs returns v, or if v is blank, the value of the kind of other that a blank stands for in a
+// comparison with it.
+func blankAs(v, other value) value {
+	if v.kind != valBlank {
+		return v
+	}
+	switch other.kind {
+	case valString:
+		return stringValue("")
+	case valBool:
+		return boolValue(false)
+	}
+	return numberValue(0)
+}
+
+// elementwise applies f to l and r, or to each pair of elements in the same position if either of
+// them is an array, giving an array. A single value, or an array with a single row or column, is
+// repeated to match the size of the other array.
+func elementwise(l, r value, f func(l, r value) (value, error)) (value, error) {
+	if l.kind != valArray && r.kind != valArray {
+		return f(l, r)
+	}
+	lrows, rrows := l.rows(), r.rows()
+	if len(lrows) == 0 || len(rrows) == 0 {
+		return value{}, fmt.Errorf("Empty array")
+	}
+	height, hok := broadcastSize(len(lrows), len(rrows))
+	width, wok := broadcastSize(len(lrows[0]), len(rrows[0]))
+	if !hok || !wok {
+		return value{}, fmt.Errorf("Arrays of %dx%d and %dx%d values have different sizes",
+			len(lrows), len(lrows[0]), len(rrows), len(rrows[0]))
+	}
+	rows := make([][]value, height)
+	for i := range rows {
+		rows[i] = make([]value, width)
+		lrow, rrow := lrows[i%len(lrows)], rrows[i%len(rrows)]
+		for j := range rows[i] {
+			v, err := f(lrow[j%len(lrow)], rrow[j%len(rrow)])
+			if err != nil {
+				return value{}, err
+			}
+			rows[i][j] = v
+		}
+	}
+	return arrayValue(rows), nil
+}
+
+// broadcastSize returns the size of the result of an elementwise operation along a dimension in
+// which its operands have sizes a and b. ok is false if they can't be combined.
+func broadcastSize(a, b int) (n int, ok bool) {
+	switch {
+	case a == b || b == 1:
+		return a, true
+	case a == 1:
+		return b, true
+	}
+	return 0, false
+}
 
-		return l + r, nil
+// arith applies the arithmetic operator o to l and r. A result too large for a float64 is an error
+// wrapping ErrNum.
+func arith(o op, l, r value) (value, error) {
+	lf, err := l.toNumber()
+	if err != nil {
+		return value{}, err
+	}
+	rf, err := r.toNumber()
+	if err != nil {
+		return value{}, err
+	}
+	var res float64
+	switch o {
+	case ADD:
+		res = lf + rf
 	case SUB:
-		panic("SUB NOT IMPLEMENTED")
+		res = lf - rf
 	case MUL:
-		panic("MUL NOT IMPLEMENTED")
-	case DIV:
-		panic("DIV NOT IMPLEMENTED")
+		res = lf * rf
 	default:
-		panic("BAD OP VAL")
+		if rf == 0 {
+			return value{}, fmt.Errorf("Division by zero")
+		}
+		res = lf / rf
+	}
+	if math.IsInf(res, 0) || math.IsNaN(res) {
+		return value{}, fmt.Errorf("%w Arithmetic overflow", ErrNum)
+	}
+	return numberValue(res), nil
+}
+
+// arithRat applies the arithmetic operator o to l and r exactly.
+func arithRat(o op, l, r value) (value, error) {
+	lr, err := l.toRat()
+	if err != nil {
+		return value{}, err
+	}
+	rr, err := r.toRat()
+	if err != nil {
+		return value{}, err
+	}
+	switch o {
+	case ADD:
+		return ratValue(new(big.Rat).Add(lr, rr)), nil
+	case SUB:
+		return ratValue(new(big.Rat).Sub(lr, rr)), nil
+	case MUL:
+		return ratValue(new(big.Rat).Mul(lr, rr)), nil
+	}
+	if rr.Sign() == 0 {
+		return value{}, fmt.Errorf("Division by zero")
+	}
+	return ratValue(new(big.Rat).Quo(lr, rr)), nil
+}
+
+// spanValue returns the values of the cells referred to by the 3D reference e on each of the
+// sheets it spans, as a single array in which the rows of each sheet follow those of the sheet
+// before it.
+func (s *Sheet) spanValue(e *Expression) (value, error) {
+	rng, err := e.refRange()
+	if err != nil {
+		return value{}, err
+	}
+	sheets, err := s.sheetSpan(e.sheet, e.lastSheet)
+	if err != nil {
+		return value{}, err
+	}
+	if len(sheets)*rng.height()*rng.width() > maxRangeCells {
+		return value{}, fmt.Errorf("Reference %s spans too many cells", e.val)
+	}
+	var rows [][]value
+	for _, t := range sheets {
+		v, err := t.rangeValue(rng)
+		if err != nil {
+			return value{}, err
+		}
+		rows = append(rows, v.arr...)
+	}
+	return arrayValue(rows), nil
+}
+
+// rangeValue returns the values of the cells in rng as an array.
+func (s *Sheet) rangeValue(rng Range) (value, error) {
+	if rng.height()*rng.width() > maxRangeCells {
+		return value{}, fmt.Errorf("Range %s is too large", rng)
+	}
+	rows := make([][]value, rng.height())
+	for i := range rows {
+		rows[i] = make([]value, rng.width())
+		for j := range rows[i] {
+			c := s.cellAt(rng.addrAt(i, j))
+			if c == nil {
+				continue
+			}
+			v, err := c.value()
+			if err != nil {
+				return value{}, err
+			}
+			rows[i][j] = v
+		}
 	}
-	panic("NOT FINISHED IMPLEMENTING")
-	return 0, nil
+	return arrayValue(rows), nil
 }
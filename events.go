@@ -0,0 +1,96 @@
+package sheet
+
+// ChangeCause describes why the content of a cell changed.
+type ChangeCause int
+
+const (
+	// CauseEdit means the content of the cell was set directly.
+	CauseEdit ChangeCause = iota
+	// CauseRecalc means the cell was recalculated because a cell it depends on changed.
+	CauseRecalc
+)
+
+// String returns a human-readable name for the cause.
+func (cc ChangeCause) String() string {
+	switch cc {
+	case CauseEdit:
+		return "edit"
+	case CauseRecalc:
+		return "recalc"
+	default:
+		return "unknown"
+	}
+}
+
+// CellEvent describes a single change to a cell in a Sheet. OldContent and NewContent are the
+// display content of the cell (see (*Cell).Content) before and after the change.
+type CellEvent struct {
+	Addr       CellAddress
+	Cell       *Cell
+	OldContent string
+	NewContent string
+	Cause      ChangeCause
+	// Version is incremented for every event delivered by a Sheet, so it can be used to order
+	// events or to detect missed ones.
+	Version uint64
+}
+
+type subscription struct {
+	id uint64
+	f  func(CellEvent)
+}
+
+// Subscribe registers f to be called for every change to a cell in s, including cells set directly
+// and cells recalculated as a result. Subscribers are called in the order they subscribed, from the
+// goroutine making the change. The returned function removes the subscription; it is safe to call
+// more than once, and from any goroutine.
+func (s *Sheet) Subscribe(f func(CellEvent)) (unsubscribe func()) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	s.nextSub++
+	id := s.nextSub
+	s.subs = append(s.subs, subscription{id: id, f: f})
+	return func() {
+		s.subMu.Lock()
+		defer s.subMu.Unlock()
+		for i := range s.subs {
+			if s.subs[i].id == id {
+				s.subs = append(s.subs[:i:i], s.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Version returns the version of the last event delivered by s, or 0 if there have been none.
+func (s *Sheet) Version() uint64 {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	return s.version
+}
+
+// cellUpdated notifies OnCellUpdated and all subscribers that c has changed.
+func (s *Sheet) cellUpdated(c *Cell, old string, cause ChangeCause) {
+	if s.OnCellUpdated != nil {
+		s.OnCellUpdated(c.addr.String(), c)
+	}
+	s.subMu.Lock()
+	s.version++
+	ev := CellEvent{
+		Addr:       c.addr,
+		Cell:       c,
+		OldContent: old,
+		Cause:      cause,
+		Version:    s.version,
+	}
+	subs := s.subs
+	s.subMu.Unlock()
+	if len(subs) == 0 {
+		// Don't compute the content of a cell in a lazy sheet when no one will see it.
+		return
+	}
+	ev.NewContent, _ = c.Content()
+	for _, sub := range subs {
+		sub.f(ev)
+	}
+}
@@ -0,0 +1,227 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+)
+
+// DefaultSolverIterations is the number of iterations allowed to numeric solvers when
+// (*Sheet).SolverIterations is 0.
+const DefaultSolverIterations = 100
+
+// solverTolerance is how close successive estimates of a numeric solver must be to be considered a
+// solution.
+const solverTolerance = 1e-10
+
+// The financial functions follow the sign conventions of other spreadsheets: money paid out is
+// negative and money received is positive. A loan of 1000 (pv, received) is repaid by negative
+// payments, so PMT(0.01, 12, 1000) is about -88.85. Payments are made at the end of each period,
+// or at the beginning if the optional type argument is 1.
+
+// solve finds a root of f by Newton's method, starting from guess. f returns its value and
+// derivative at r. The search fails with ErrNum if it takes more than s.SolverIterations
+// iterations, or leaves the domain r > -1 of interest rates.
+func (s *Sheet) solve(name string, guess float64, f func(r float64) (y, dy float64)) (float64, error) {
+	iterations := s.SolverIterations
+	if iterations <= 0 {
+		iterations = DefaultSolverIterations
+	}
+	r := guess
+	for i := 0; i < iterations; i++ {
+		y, dy := f(r)
+		if math.IsNaN(y) || math.IsInf(y, 0) || dy == 0 || math.IsNaN(dy) || math.IsInf(dy, 0) {
+			break
+		}
+		next := r - y/dy
+		if next <= -1 {
+			break
+		}
+		if math.Abs(next-r) < solverTolerance {
+			return next, nil
+		}
+		r = next
+	}
+	return 0, fmt.Errorf("%w %s did not converge from guess %v in %d iterations", ErrNum, name, guess, iterations)
+}
+
+// finArgs evaluates the arguments of the financial function name, of which there are required
+// ones and then optional ones, which default to 0.
+func finArgs(s *Sheet, name string, args []*Expression, required, optional int) ([]float64, error) {
+	vals, err := evalArgs(s, name, args, required, required+optional)
+	if err != nil {
+		return nil, err
+	}
+	for len(vals) < required+optional {
+		vals = append(vals, 0)
+	}
+	return vals, nil
+}
+
+// paymentType returns the type argument t of a financial function as a factor: 1 for payments at
+// the beginning of each period, or 0 for payments at the end.
+func paymentType(t float64) float64 {
+	if t != 0 {
+		return 1
+	}
+	return 0
+}
+
+// annuity returns the future value factor ((1+r)^n - 1) / r of n payments at rate r per period,
+// which is n if r is 0.
+func annuity(r, n float64) float64 {
+	if r == 0 {
+		return n
+	}
+	return (math.Pow(1+r, n) - 1) / r
+}
+
+// PMT(rate, nper, pv, [fv], [type]) returns the payment per period of a loan or investment of
+// present value pv and future value fv over nper periods at rate per period.
+func fnPmt(s *Sheet, args []*Expression) (value, error) {
+	vals, err := finArgs(s, "PMT", args, 3, 2)
+	if err != nil {
+		return value{}, err
+	}
+	r, n, pv, fv, t := vals[0], vals[1], vals[2], vals[3], paymentType(vals[4])
+	if n == 0 {
+		return value{}, fmt.Errorf("%w PMT: number of periods is 0", ErrNum)
+	}
+	g := math.Pow(1+r, n)
+	return finiteNumber("PMT", -(pv*g+fv)/((1+r*t)*annuity(r, n)))
+}
+
+// PV(rate, nper, pmt, [fv], [type]) returns the present value of nper payments of pmt at rate per
+// period, followed by fv.
+func fnPv(s *Sheet, args []*Expression) (value, error) {
+	vals, err := finArgs(s, "PV", args, 3, 2)
+	if err != nil {
+		return value{}, err
+	}
+	r, n, pmt, fv, t := vals[0], vals[1], vals[2], vals[3], paymentType(vals[4])
+	return finiteNumber("PV", -(fv+pmt*(1+r*t)*annuity(r, n))/math.Pow(1+r, n))
+}
+
+// FV(rate, nper, pmt, [pv], [type]) returns the future value of an initial pv and nper payments
+// of pmt at rate per period.
+func fnFv(s *Sheet, args []*Expression) (value, error) {
+	vals, err := finArgs(s, "FV", args, 3, 2)
+	if err != nil {
+		return value{}, err
+	}
+	r, n, pmt, pv, t := vals[0], vals[1], vals[2], vals[3], paymentType(vals[4])
+	return finiteNumber("FV", -(pv*math.Pow(1+r, n) + pmt*(1+r*t)*annuity(r, n)))
+}
+
+// NPER(rate, pmt, pv, [fv], [type]) returns the number of periods of payments of pmt at rate per
+// period needed to go from pv to fv.
+func fnNper(s *Sheet, args []*Expression) (value, error) {
+	vals, err := finArgs(s, "NPER", args, 3, 2)
+	if err != nil {
+		return value{}, err
+	}
+	r, pmt, pv, fv, t := vals[0], vals[1], vals[2], vals[3], paymentType(vals[4])
+	if r == 0 {
+		if pmt == 0 {
+			return value{}, fmt.Errorf("%w NPER: payment is 0", ErrNum)
+		}
+		return finiteNumber("NPER", -(pv+fv)/pmt)
+	}
+	p := pmt * (1 + r*t)
+	x := (p - fv*r) / (p + pv*r)
+	if x <= 0 || r <= -1 {
+		return value{}, fmt.Errorf("%w NPER: the balance is never reached", ErrNum)
+	}
+	return finiteNumber("NPER", math.Log(x)/math.Log(1+r))
+}
+
+// NPV(rate, values...) returns the net present value of values paid or received at the end of
+// successive periods, discounted at rate per period.
+func fnNpv(s *Sheet, args []*Expression) (value, error) {
+	if err := checkArgCount("NPV", args, 2, -1); err != nil {
+		return value{}, err
+	}
+	r, err := args[0].Eval(s)
+	if err != nil {
+		return value{}, err
+	}
+	flows, err := floatArgs(s, "NPV", args[1:], 1, -1)
+	if err != nil {
+		return value{}, err
+	}
+	npv := 0.0
+	for i, v := range flows {
+		npv += v / math.Pow(1+r, float64(i+1))
+	}
+	return finiteNumber("NPV", npv)
+}
+
+// IRR(values, [guess]) returns the internal rate of return of the cash flows in values, which
+// occur at the start of successive periods: the rate at which their net present value is 0. It is
+// found numerically, starting from guess, which defaults to 0.1.
+func fnIrr(s *Sheet, args []*Expression) (value, error) {
+	if err := checkArgCount("IRR", args, 1, 2); err != nil {
+		return value{}, err
+	}
+	flows, err := floatArgs(s, "IRR", args[:1], 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	guess := 0.1
+	if len(args) > 1 {
+		if guess, err = args[1].Eval(s); err != nil {
+			return value{}, err
+		}
+	}
+	pos, neg := false, false
+	for _, v := range flows {
+		pos = pos || v > 0
+		neg = neg || v < 0
+	}
+	if !pos || !neg {
+		return value{}, fmt.Errorf("%w IRR needs both positive and negative cash flows", ErrNum)
+	}
+	r, err := s.solve("IRR", guess, func(r float64) (float64, float64) {
+		y, dy := 0.0, 0.0
+		for i, v := range flows {
+			y += v / math.Pow(1+r, float64(i))
+			dy -= float64(i) * v / math.Pow(1+r, float64(i+1))
+		}
+		return y, dy
+	})
+	if err != nil {
+		return value{}, err
+	}
+	return numberValue(r), nil
+}
+
+// RATE(nper, pmt, pv, [fv], [type], [guess]) returns the interest rate per period at which nper
+// payments of pmt take pv to fv. It is found numerically, starting from guess, which defaults to
+// 0.1.
+func fnRate(s *Sheet, args []*Expression) (value, error) {
+	vals, err := finArgs(s, "RATE", args, 3, 3)
+	if err != nil {
+		return value{}, err
+	}
+	n, pmt, pv, fv, t := vals[0], vals[1], vals[2], vals[3], paymentType(vals[4])
+	guess := 0.1
+	if len(args) > 5 {
+		guess = vals[5]
+	}
+	r, err := s.solve("RATE", guess, func(r float64) (float64, float64) {
+		if r == 0 {
+			// Move off of the removable singularity of annuity at 0.
+			r = solverTolerance
+		}
+		g := math.Pow(1+r, n)
+		dg := n * math.Pow(1+r, n-1)
+		a := (g - 1) / r
+		da := (dg*r - (g - 1)) / (r * r)
+		y := pv*g + pmt*(1+r*t)*a + fv
+		dy := pv*dg + pmt*(t*a+(1+r*t)*da)
+		return y, dy
+	})
+	if err != nil {
+		return value{}, err
+	}
+	return numberValue(r), nil
+}
@@ -0,0 +1,85 @@
+package sheet
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinancialFunctions(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		expect  float64
+	}{
+		"pmt":          {content: "=PMT(0.01,12,1000)", expect: -88.84878867834166},
+		"pmt/zero":     {content: "=PMT(0,10,1000)", expect: -100},
+		"pmt/fv":       {content: "=PMT(0.05/12,120,0,50000)", expect: -321.9942429},
+		"pmt/due":      {content: "=PMT(0.01,12,1000,0,1)", expect: -87.96909770},
+		"pv":           {content: "=PV(0.05/12,60,0-500)", expect: 26495.35212},
+		"pv/zero":      {content: "=PV(0,10,0-100,0-50)", expect: 1050},
+		"fv":           {content: "=FV(0.06/12,10,0-200,0-500,1)", expect: 2581.403374},
+		"fv/zero":      {content: "=FV(0,12,0-100)", expect: 1200},
+		"nper":         {content: "=NPER(0.01,0-100,1000)", expect: 10.58864446},
+		"nper/zero":    {content: "=NPER(0,0-100,1000)", expect: 10},
+		"npv":          {content: "=NPV(0.1,0-10000,3000,4200,6800)", expect: 1188.443412},
+		"npv/range":    {content: "=NPV(0.1,A1:A6)", expect: 1188.443412},
+		"irr":          {content: "=IRR(B1:B6)", expect: 0.08663094803},
+		"irr/guess":    {content: "=IRR(B1:B4,0-0.1)", expect: -0.1821374155},
+		"rate":         {content: "=RATE(48,0-200,8000)", expect: 0.007701472488},
+		"rate/fv":      {content: "=RATE(10,0,0-1000,2000)", expect: 0.07177346254},
+		"rate/pmt":     {content: "=PMT(RATE(48,0-200,8000),48,8000)", expect: -200},
+		"roundtrip/pv": {content: "=PV(0.01,12,PMT(0.01,12,1000))", expect: 1000},
+		"roundtrip/fv": {content: "=FV(0.01,NPER(0.01,0-100,1000),0-100,1000)", expect: 0},
+		"irr/npv":      {content: "=NPV(IRR(B1:B6),B2:B6)+B1", expect: 0},
+		"npv/skip":     {content: "=NPV(0.1,A1:A7)", expect: 1188.443412},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			for i, v := range []string{"-10000", "3000", "4200", "6800", "", "", "note"} {
+				sheet.SetContent(CellAddress{"A", uint32(i + 1)}.String(), v)
+			}
+			for i, v := range []string{"-70000", "12000", "15000", "18000", "21000", "26000"} {
+				sheet.SetContent(CellAddress{"B", uint32(i + 1)}.String(), v)
+			}
+			assert.NoError(sheet.SetContent("Z1", tt.content))
+			v, err := sheet.ValueAt("Z1")
+			assert.NoError(err)
+			assert.InDelta(tt.expect, v, 1e-6*math.Max(1, math.Abs(tt.expect)))
+		})
+	}
+}
+
+func TestFinancialErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content    string
+		iterations int
+		num        bool
+	}{
+		"irr/signs":      {content: "=IRR(A1:A3)", num: true},
+		"irr/iterations": {content: "=IRR(B1:B6)", iterations: 2, num: true},
+		"rate/diverge":   {content: "=RATE(10,100,100,100)", num: true},
+		"nper/never":     {content: "=NPER(0.1,0-10,1000)", num: true},
+		"pmt/periods":    {content: "=PMT(0.1,0,1000)", num: true},
+		"pmt/args":       {content: "=PMT(0.1,10)"},
+		"npv/text":       {content: `=NPV(0.1,"x")`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			sheet.SolverIterations = tt.iterations
+			sheet.SetContent("A1", "1")
+			sheet.SetContent("A2", "2")
+			sheet.SetContent("A3", "3")
+			for i, v := range []string{"-70000", "12000", "15000", "18000", "21000", "26000"} {
+				sheet.SetContent(CellAddress{"B", uint32(i + 1)}.String(), v)
+			}
+			assert.NoError(sheet.SetContent("Z1", tt.content))
+			_, err := sheet.ValueAt("Z1")
+			assert.Error(err)
+			assert.Equal(tt.num, errors.Is(err, ErrNum), err)
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// formatKind is the kind of a numberFormat.
+type formatKind int
+
+const (
+	formatGeneral formatKind = iota
+	formatDate
+	formatDateTime
+	formatTime
+	formatDuration
+	// formatNumber displays a fixed number of decimals, optionally with grouped thousands and
+	// literal text before and after the number, as in currencies.
+	formatNumber
+	formatPercent
+	formatScientific
+)
+
+// numberFormat describes how the numeric value of a cell is displayed. Dates, times and durations
+// are stored as serial dates (see timeToSerial) and displayed according to their format.
+type numberFormat struct {
+	kind formatKind
+	// decimals is the number of digits shown after the decimal point.
+	decimals int
+	// group separates thousands with commas.
+	group bool
+	// prefix and suffix are literal text shown around the number.
+	prefix string
+	suffix string
+	// spec is the text the format was parsed from, if any. See parseNumberFormat.
+	spec string
+}
+
+// namedFormats are the format names understood by parseNumberFormat, along with the number of
+// decimals they show when none are given.
+var namedFormats = map[string]struct {
+	format   numberFormat
+	decimals int
+}{
+	"general":    {numberFormat{kind: formatGeneral}, 0},
+	"fixed":      {numberFormat{kind: formatNumber}, 2},
+	"thousands":  {numberFormat{kind: formatNumber, group: true}, 0},
+	"currency":   {numberFormat{kind: formatNumber, group: true, prefix: "$"}, 2},
+	"percent":    {numberFormat{kind: formatPercent}, 0},
+	"scientific": {numberFormat{kind: formatScientific}, 2},
+	"date":       {numberFormat{kind: formatDate}, 0},
+	"datetime":   {numberFormat{kind: formatDateTime}, 0},
+	"time":       {numberFormat{kind: formatTime}, 0},
+	"duration":   {numberFormat{kind: formatDuration}, 0},
+}
+
+// parseNumberFormat parses a format specification, as described by (*Sheet).SetFormat.
+func parseNumberFormat(spec string) (numberFormat, error) {
+	parts := strings.Split(spec, ":")
+	if named, ok := namedFormats[strings.ToLower(parts[0])]; ok {
+		f := named.format
+		f.decimals = named.decimals
+		f.spec = strings.ToLower(spec)
+		if len(parts) > 1 {
+			d, err := strconv.Atoi(parts[1])
+			if err != nil || d < 0 || d > 20 {
+				return numberFormat{}, fmt.Errorf("Invalid number of decimals in format '%s'", spec)
+			}
+			f.decimals = d
+		}
+		if len(parts) > 2 && f.prefix != "" {
+			f.prefix = strings.Join(parts[2:], ":")
+			f.spec = fmt.Sprintf("%s:%s", strings.ToLower(parts[0]+":"+parts[1]), f.prefix)
+		} else if len(parts) > 2 {
+			return numberFormat{}, fmt.Errorf("Invalid format '%s'", spec)
+		}
+		return f, nil
+	}
+	return parsePattern(spec)
+}
+
+// parsePattern parses a custom format pattern, as described by (*Sheet).SetFormat.
+func parsePattern(spec string) (numberFormat, error) {
+	var (
+		f       = numberFormat{kind: formatNumber, spec: spec}
+		prefix  strings.Builder
+		suffix  strings.Builder
+		inNum   bool
+		doneNum bool
+		inDec   bool
+		quoted  bool
+	)
+	rs := []rune(spec)
+	for i := 0; i < len(rs); i++ {
+		rn := rs[i]
+		text := &prefix
+		if inNum || doneNum {
+			text = &suffix
+		}
+		switch {
+		case rn == '"':
+			quoted = !quoted
+			continue
+		case quoted:
+			if inNum {
+				inNum, doneNum = false, true
+				text = &suffix
+			}
+			text.WriteRune(rn)
+			continue
+		case !doneNum && (rn == '0' || rn == '#'):
+			inNum = true
+			if inDec {
+				f.decimals++
+			}
+			continue
+		case inNum && rn == ',' && !inDec:
+			f.group = true
+			continue
+		case !doneNum && rn == '.' && !inDec && (inNum || i+1 < len(rs) && (rs[i+1] == '0' || rs[i+1] == '#')):
+			inNum, inDec = true, true
+			continue
+		case inNum && (rn == 'E' || rn == 'e') && i+2 < len(rs) && (rs[i+1] == '+' || rs[i+1] == '-') && rs[i+2] == '0':
+			f.kind = formatScientific
+			i += 2
+			for i+1 < len(rs) && rs[i+1] == '0' {
+				i++
+			}
+			inNum, doneNum = false, true
+			continue
+		case rn == '%':
+			if f.kind == formatNumber {
+				f.kind = formatPercent
+			}
+		}
+		if inNum {
+			inNum, doneNum = false, true
+			text = &suffix
+		}
+		if rn == '%' {
+			// The percent kind adds its own sign.
+			if text == &prefix {
+				return numberFormat{}, fmt.Errorf("Invalid format '%s': %% must follow the number", spec)
+			}
+			continue
+		}
+		text.WriteRune(rn)
+	}
+	if quoted {
+		return numberFormat{}, fmt.Errorf("Invalid format '%s': unterminated quote", spec)
+	}
+	if !inNum && !doneNum {
+		return numberFormat{}, fmt.Errorf("Invalid format '%s': no digits", spec)
+	}
+	f.prefix = prefix.String()
+	f.suffix = suffix.String()
+	return f, nil
+}
+
+// String returns the specification of the format, as understood by parseNumberFormat.
+func (f numberFormat) String() string {
+	if f.spec != "" {
+		return f.spec
+	}
+	for name, named := range namedFormats {
+		nf := named.format
+		nf.decimals = f.decimals
+		if nf == f {
+			if f.decimals == named.decimals {
+				return name
+			}
+			return fmt.Sprintf("%s:%d", name, f.decimals)
+		}
+	}
+	return "general"
+}
+
+// isDate returns true if f displays a calendar date.
+func (f numberFormat) isDate() bool {
+	return f.kind == formatDate || f.kind == formatDateTime
+}
+
+// format returns the display string for v.
+func (f numberFormat) format(v float64) string {
+	if f.isDate() && !validSerial(v) {
+		// Serial dates outside of the years that can be shown would wrap around to other dates.
+		return ErrNum.Error()
+	}
+	switch f.kind {
+	case formatDate:
+		return serialToTime(v).Format(dateLayout)
+	case formatDateTime:
+		return serialToTime(v).Format(dateTimeLayout)
+	case formatTime:
+		return serialToTime(v).Format(timeLayout)
+	case formatDuration:
+		return formatDurationDays(v)
+	case formatNumber:
+		return f.decorate(v, groupDigits(strconv.FormatFloat(math.Abs(v), 'f', f.decimals, 64), f.group))
+	case formatPercent:
+		return f.decorate(v, groupDigits(strconv.FormatFloat(math.Abs(v)*100, 'f', f.decimals, 64), f.group)+"%")
+	case formatScientific:
+		return f.decorate(v, strings.ToUpper(strconv.FormatFloat(math.Abs(v), 'e', f.decimals, 64)))
+	default:
+		return formatGeneralNumber(v)
+	}
+}
+
+// decorate adds the sign of v and the prefix and suffix of f to num, the formatted absolute value
+// of v.
+func (f numberFormat) decorate(v float64, num string) string {
+	sign := ""
+	if v < 0 && strings.Trim(num, "0.,%E+") != "" {
+		sign = "-"
+	}
+	return sign + f.prefix + num + f.suffix
+}
+
+// groupDigits separates the thousands in the whole part of num with commas, if group is true.
+func groupDigits(num string, group bool) string {
+	if !group {
+		return num
+	}
+	whole, frac := num, ""
+	if i := strings.IndexByte(num, '.'); i >= 0 {
+		whole, frac = num[:i], num[i:]
+	}
+	var b strings.Builder
+	for i := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte(whole[i])
+	}
+	return b.String() + frac
+}
+
+// formatGeneralNumber formats v with up to 15 significant digits and no trailing zeros, switching
+// to scientific notation for very large or very small numbers.
+func formatGeneralNumber(v float64) string {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 15, 64)
+}
+
+// formatEditNumber formats v so that parsing the result gives back exactly v.
+func formatEditNumber(v float64) string {
+	if a := math.Abs(v); a != 0 && (a < 1e-6 || a >= 1e21) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// combineFormats returns the format of the result of applying the arithmetic operator o to values
+// with formats l and r. Adding a number to a date gives a date, subtracting two dates gives a
+// number of days, and so on.
+func combineFormats(o op, l, r numberFormat) numberFormat {
+	if o == ADD || o == SUB {
+		// A date plus a time of day has a time of day.
+		partial := func(f numberFormat) bool { return f.kind == formatTime || f.kind == formatDuration }
+		if (l.kind == formatDate && partial(r)) || (o == ADD && partial(l) && r.kind == formatDate) {
+			return numberFormat{kind: formatDateTime}
+		}
+	}
+	switch o {
+	case ADD:
+		if l.kind == formatGeneral {
+			return r
+		}
+		if r.kind == formatGeneral || r.kind == formatDuration || r.kind == formatTime {
+			return l
+		}
+		if l.kind == formatDuration || l.kind == formatTime {
+			return r
+		}
+	case SUB:
+		if l.isDate() && r.isDate() {
+			return numberFormat{kind: formatGeneral}
+		}
+		if r.kind == formatGeneral || r.kind == formatDuration || r.kind == formatTime {
+			return l
+		}
+	case MUL, DIV:
+		// Scaling an amount, such as a price or a duration, keeps its format.
+		amount := func(f numberFormat) bool {
+			return f.kind == formatDuration || f.kind == formatNumber || f.kind == formatPercent ||
+				f.kind == formatScientific
+		}
+		if amount(l) && r.kind == formatGeneral {
+			return l
+		}
+		if o == MUL && amount(r) && l.kind == formatGeneral {
+			return r
+		}
+	}
+	return numberFormat{kind: formatGeneral}
+}
+
+// resultFormat returns the format in which the result of e should be displayed, inferred from the
+// formats of the cells it references and the functions it calls.
+func (e *Expression) resultFormat(s *Sheet) numberFormat {
+	if e.sheet != "" {
+		t, err := s.refSheet(e.sheet)
+		if err != nil {
+			return numberFormat{}
+		}
+		s = t
+	}
+	switch e.op {
+	case ID:
+		if e.cell != nil {
+			return e.cell.displayFormat()
+		}
+		a, err := CellAddr(e.val)
+		if err != nil {
+			return numberFormat{}
+		}
+		if c := s.cellAt(a); c != nil {
+			return c.displayFormat()
+		}
+	case FUNC:
+		if f, ok := functions[e.val]; ok {
+			return f.format
+		}
+	case NAME:
+		if d := s.definition(e); d != nil {
+			return d.resultFormat(s)
+		}
+	case NEG:
+		if e.left != nil {
+			return e.left.resultFormat(s)
+		}
+	case ADD, SUB, MUL, DIV:
+		if e.left != nil && e.right != nil {
+			return combineFormats(e.op, e.left.resultFormat(s), e.right.resultFormat(s))
+		}
+	}
+	return numberFormat{}
+}
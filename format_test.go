@@ -0,0 +1,120 @@
+package sheet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumberFormats(t *testing.T) {
+	for name, tt := range map[string]struct {
+		format string
+		value  string
+		expect string
+		spec   string
+	}{
+		"general":           {format: "general", value: "1234.5", expect: "1234.5", spec: "general"},
+		"general/float":     {format: "", value: "0.1", expect: "0.1", spec: "general"},
+		"general/big":       {format: "", value: "1e20", expect: "1e+20", spec: "general"},
+		"fixed":             {format: "fixed", value: "1", expect: "1.00", spec: "fixed"},
+		"fixed/3":           {format: "fixed:3", value: "-1234.5", expect: "-1234.500", spec: "fixed:3"},
+		"fixed/0":           {format: "FIXED:0", value: "2.5", expect: "2", spec: "fixed:0"},
+		"fixed/negzero":     {format: "fixed:1", value: "-0.01", expect: "0.0", spec: "fixed:1"},
+		"thousands":         {format: "thousands", value: "1234567.8", expect: "1,234,568", spec: "thousands"},
+		"thousands/1":       {format: "thousands:1", value: "-1234.56", expect: "-1,234.6", spec: "thousands:1"},
+		"currency":          {format: "currency", value: "1234.5", expect: "$1,234.50", spec: "currency"},
+		"currency/negative": {format: "currency", value: "-5", expect: "-$5.00", spec: "currency"},
+		"currency/symbol":   {format: "currency:0:€", value: "1234.5", expect: "€1,234", spec: "currency:0:€"},
+		"percent":           {format: "percent", value: "0.256", expect: "26%", spec: "percent"},
+		"percent/1":         {format: "percent:1", value: "0.256", expect: "25.6%", spec: "percent:1"},
+		"scientific":        {format: "scientific", value: "12345", expect: "1.23E+04", spec: "scientific"},
+		"scientific/small":  {format: "scientific:1", value: "0.00012", expect: "1.2E-04", spec: "scientific:1"},
+		"date":              {format: "date", value: "46311", expect: "2026-10-16", spec: "date"},
+		"date/last":         {format: "date", value: "2958465", expect: "9999-12-31", spec: "date"},
+		"date/huge":         {format: "date", value: "1e300", expect: "#NUM!", spec: "date"},
+		"datetime/negative": {format: "datetime", value: "-1e300", expect: "#NUM!", spec: "datetime"},
+		"pattern/currency":  {format: "$#,##0.00", value: "1234.5", expect: "$1,234.50", spec: "$#,##0.00"},
+		"pattern/percent":   {format: "0.0%", value: "0.5", expect: "50.0%", spec: "0.0%"},
+		"pattern/sci":       {format: "0.000E+00", value: "1234", expect: "1.234E+03", spec: "0.000E+00"},
+		"pattern/suffix":    {format: `0.0 "kg"`, value: "2.25", expect: "2.2 kg", spec: `0.0 "kg"`},
+		"pattern/decimal":   {format: ".00", value: "3", expect: "3.00", spec: ".00"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			assert.NoError(sheet.SetContent("A1", tt.value))
+			assert.NoError(sheet.SetFormat("A1", tt.format))
+			c, err := sheet.ContentAt("A1")
+			assert.NoError(err)
+			assert.Equal(tt.expect, c)
+			f, err := sheet.FormatAt("A1")
+			assert.NoError(err)
+			assert.Equal(tt.spec, f)
+		})
+	}
+}
+
+func TestNumberFormatErrors(t *testing.T) {
+	sheet := NewSheet()
+	for _, format := range []string{"fixed:x", "fixed:-1", "percent:1:x", "hello", `0 "kg`, "%0"} {
+		assert.Error(t, sheet.SetFormat("A1", format), format)
+	}
+}
+
+func TestFormatPersists(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	assert.NoError(sheet.SetFormat("A1", "currency"))
+	assert.NotNil(sheet.cellAt(CellAddress{col: "A", row: 1}))
+
+	assert.NoError(sheet.SetContent("A1", "3"))
+	assert.NoError(sheet.SetContent("A2", "=A1*2"))
+	assert.NoError(sheet.SetContent("A3", "=A1/A1"))
+	for addr, want := range map[string]string{"A1": "$3.00", "A2": "$6.00", "A3": "1"} {
+		c, err := sheet.ContentAt(addr)
+		assert.NoError(err)
+		assert.Equal(want, c, addr)
+	}
+
+	e, err := sheet.EditAt("A1")
+	assert.NoError(err)
+	assert.Equal("3", e)
+
+	assert.NoError(sheet.SetContent("A1", ""))
+	f, err := sheet.FormatAt("A1")
+	assert.NoError(err)
+	assert.Equal("currency", f)
+
+	assert.NoError(sheet.SetContent("A1", "4"))
+	assert.NoError(sheet.SetFormat("A1", ""))
+	c, err := sheet.ContentAt("A2")
+	assert.NoError(err)
+	assert.Equal("8", c)
+
+	assert.NoError(sheet.SetContent("B1", "2026-10-16"))
+	f, err = sheet.FormatAt("B1")
+	assert.NoError(err)
+	assert.Equal("date", f)
+}
+
+func TestEditValueNumbers(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	for _, in := range []string{"1", "1.50", "0.1234567891", "1e21", "123456789012", "-0.000001", "+7"} {
+		assert.NoError(sheet.SetContent("A1", in))
+		e, err := sheet.EditAt("A1")
+		assert.NoError(err)
+		assert.Equal(in, e)
+	}
+
+	for v, want := range map[float64]string{
+		1:            "1",
+		0.1:          "0.1",
+		1e21:         "1e+21",
+		123456789012: "123456789012",
+		-0.000001:    "-0.000001",
+		1e-7:         "1e-07",
+	} {
+		assert.Equal(want, formatEditNumber(v))
+	}
+}
@@ -0,0 +1,291 @@
+package sheet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// function is a built-in function that can be called from an equation, as in =NOW().
+type function struct {
+	// volatile functions can return a different value each time they are evaluated, even when
+	// none of the cells they reference have changed. Cells calling them are recalculated by
+	// (*Sheet).RecalculateVolatile.
+	volatile bool
+	// format is the format the function's result is displayed in.
+	format numberFormat
+	eval   func(s *Sheet, args []*Expression) (value, error)
+	// ref is set instead of eval for functions that return a reference to cells, which are
+	// determined when the function is evaluated: a range of s or of another sheet of its workbook.
+	// The value of the function is the value of those cells. See (*Cell).relink.
+	ref func(s *Sheet, args []*Expression) (*Sheet, Range, error)
+}
+
+// numeric adapts a function computing a number to the signature of function.eval.
+func numeric(f func(s *Sheet, args []*Expression) (float64, error)) func(*Sheet, []*Expression) (value, error) {
+	return func(s *Sheet, args []*Expression) (value, error) {
+		v, err := f(s, args)
+		if err != nil {
+			return value{}, err
+		}
+		return numberValue(v), nil
+	}
+}
+
+// ErrNum is wrapped by the errors of functions whose result is not a number that can be computed,
+// such as an IRR that can't be found or the square root of a negative number. Spreadsheets show
+// these errors as #NUM!. It can be detected with errors.Is on the errors returned by
+// (*Sheet).ValueAt.
+var ErrNum = errors.New("#NUM!")
+
+// functions holds the built-in functions, by upper-case name.
+var functions map[string]function
+
+func init() {
+	functions = map[string]function{
+		"NOW":         {volatile: true, format: numberFormat{kind: formatDateTime}, eval: numeric(fnNow)},
+		"TODAY":       {volatile: true, format: numberFormat{kind: formatDate}, eval: numeric(fnToday)},
+		"RAND":        {volatile: true, eval: numeric(fnRand)},
+		"RANDBETWEEN": {volatile: true, eval: numeric(fnRandBetween)},
+
+		"DATE":        {format: numberFormat{kind: formatDate}, eval: numeric(fnDate)},
+		"YEAR":        {eval: numeric(fnYear)},
+		"MONTH":       {eval: numeric(fnMonth)},
+		"DAY":         {eval: numeric(fnDay)},
+		"EDATE":       {format: numberFormat{kind: formatDate}, eval: numeric(fnEdate)},
+		"NETWORKDAYS": {eval: numeric(fnNetworkDays)},
+		"DATEDIF":     {eval: numeric(fnDateDif)},
+
+		"ROUND":     roundFunction("ROUND", roundHalfAway),
+		"ROUNDUP":   roundFunction("ROUNDUP", roundUp),
+		"ROUNDDOWN": roundFunction("ROUNDDOWN", roundDown),
+
+		"ABS":     exactFunction("ABS", 1, 1, fnAbs),
+		"SIGN":    exactFunction("SIGN", 1, 1, fnSign),
+		"INT":     exactFunction("INT", 1, 1, fnInt),
+		"MOD":     exactFunction("MOD", 2, 2, fnMod),
+		"FLOOR":   multipleFunction("FLOOR", floorRat),
+		"CEILING": multipleFunction("CEILING", ceilRat),
+		"POWER":   {eval: fnPower},
+		"SQRT":    mathFunction("SQRT", math.Sqrt),
+		"EXP":     mathFunction("EXP", math.Exp),
+		"LN":      mathFunction("LN", math.Log),
+		"LOG":     {eval: fnLog},
+		"LOG10":   mathFunction("LOG10", math.Log10),
+		"SIN":     mathFunction("SIN", math.Sin),
+		"COS":     mathFunction("COS", math.Cos),
+		"TAN":     mathFunction("TAN", math.Tan),
+		"ASIN":    mathFunction("ASIN", math.Asin),
+		"ACOS":    mathFunction("ACOS", math.Acos),
+		"ATAN":    mathFunction("ATAN", math.Atan),
+		"ATAN2":   {eval: fnAtan2},
+		"PI":      {eval: fnPi},
+		"GCD":     divisorFunction("GCD", gcd),
+		"LCM":     divisorFunction("LCM", lcm),
+		"PRODUCT": {eval: fnProduct},
+
+		"LEN":          {eval: fnLen},
+		"LEFT":         {eval: fnLeft},
+		"RIGHT":        {eval: fnRight},
+		"MID":          {eval: fnMid},
+		"UPPER":        {eval: fnUpper},
+		"LOWER":        {eval: fnLower},
+		"TRIM":         {eval: fnTrim},
+		"SUBSTITUTE":   {eval: fnSubstitute},
+		"FIND":         {eval: fnFind},
+		"TEXT":         {eval: fnText},
+		"VALUE":        {eval: fnValue},
+		"REGEXMATCH":   {eval: fnRegexMatch},
+		"REGEXEXTRACT": {eval: fnRegexExtract},
+		"REGEXREPLACE": {eval: fnRegexReplace},
+
+		"VLOOKUP": {eval: tableLookup("VLOOKUP", true)},
+		"HLOOKUP": {eval: tableLookup("HLOOKUP", false)},
+		"INDEX":   {eval: fnIndex},
+		"MATCH":   {eval: fnMatch},
+		"XLOOKUP": {eval: fnXLookup},
+
+		"SEQUENCE": {eval: fnSequence},
+		"SORT":     {eval: fnSort},
+		"FILTER":   {eval: fnFilter},
+		"UNIQUE":   {eval: fnUnique},
+
+		"MMULT":     {eval: fnMmult},
+		"TRANSPOSE": {eval: fnTranspose},
+		"MINVERSE":  {eval: fnMinverse},
+		"MDETERM":   {eval: fnMdeterm},
+
+		"LET":    {eval: fnLet},
+		"LAMBDA": {eval: fnLambda},
+		"IF":     {eval: fnIf},
+
+		"INDIRECT": {ref: refIndirect},
+		"OFFSET":   {ref: refOffset},
+
+		"SUM":        {eval: fnSum},
+		"AVERAGE":    {eval: fnAverage},
+		"COUNT":      {eval: fnCount},
+		"MIN":        {eval: extremum("MIN", true)},
+		"MAX":        {eval: extremum("MAX", false)},
+		"MEDIAN":     {eval: fnMedian},
+		"MODE":       {eval: fnMode},
+		"VAR":        {eval: varianceFunction("VAR", true, false)},
+		"VARP":       {eval: varianceFunction("VARP", false, false)},
+		"STDEV":      {eval: varianceFunction("STDEV", true, true)},
+		"STDEVP":     {eval: varianceFunction("STDEVP", false, true)},
+		"PERCENTILE": {eval: fnPercentile},
+		"QUARTILE":   {eval: fnQuartile},
+		"CORREL":     {eval: fnCorrel},
+		"SUMIF":      {eval: conditionalFunction("SUMIF", false)},
+		"AVERAGEIF":  {eval: conditionalFunction("AVERAGEIF", true)},
+		"COUNTIF":    {eval: fnCountIf},
+		"SUMIFS":     {eval: multiConditionalFunction("SUMIFS", false)},
+		"AVERAGEIFS": {eval: multiConditionalFunction("AVERAGEIFS", true)},
+		"COUNTIFS":   {eval: fnCountIfs},
+
+		"PMT":  {eval: fnPmt},
+		"PV":   {eval: fnPv},
+		"FV":   {eval: fnFv},
+		"NPER": {eval: fnNper},
+		"NPV":  {eval: fnNpv},
+		"IRR":  {eval: fnIrr},
+		"RATE": {eval: fnRate},
+	}
+}
+
+// evalArgs evaluates the arguments of the function name, checking that there are at least min and
+// at most max of them. A max of -1 allows any number of arguments.
+func evalArgs(s *Sheet, name string, args []*Expression, min, max int) ([]float64, error) {
+	if err := checkArgCount(name, args, min, max); err != nil {
+		return nil, err
+	}
+	vals := make([]float64, len(args))
+	for i := range args {
+		v, err := args[i].Eval(s)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// evalValueArgs is like evalArgs, but returns the arguments' values without converting them to
+// numbers.
+func evalValueArgs(s *Sheet, name string, args []*Expression, min, max int) ([]value, error) {
+	if err := checkArgCount(name, args, min, max); err != nil {
+		return nil, err
+	}
+	vals := make([]value, len(args))
+	for i := range args {
+		v, err := args[i].evalValue(s)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// finiteNumber returns f as a value, or an error wrapping ErrNum if it isn't finite.
+func finiteNumber(name string, f float64) (value, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return value{}, fmt.Errorf("%w %s has no finite result", ErrNum, name)
+	}
+	return numberValue(f), nil
+}
+
+// checkArgCount checks that there are at least min and at most max arguments to the function
+// name. A max of -1 allows any number of arguments.
+func checkArgCount(name string, args []*Expression, min, max int) error {
+	if len(args) >= min && (max < 0 || len(args) <= max) {
+		return nil
+	}
+	switch {
+	case min == max:
+		return fmt.Errorf("%s expects %d arguments, but got %d", name, min, len(args))
+	case max < 0:
+		return fmt.Errorf("%s expects at least %d arguments, but got %d", name, min, len(args))
+	default:
+		return fmt.Errorf("%s expects %d to %d arguments, but got %d", name, min, max, len(args))
+	}
+}
+
+// serialEpoch is day 0 of spreadsheet serial dates. Serial dates count the days since the epoch,
+// with the time of day as the fractional part.
+var serialEpoch = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+
+// timeToSerial converts t to a serial date, in t's location.
+func timeToSerial(t time.Time) float64 {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	// Durations only span about 292 years, so the days are counted in Unix seconds instead.
+	days := float64((day.Unix() - serialEpoch.Unix()) / 86400)
+	secs := float64(t.Hour()*3600+t.Minute()*60+t.Second()) + float64(t.Nanosecond())/1e9
+	return days + secs/86400
+}
+
+// now returns the current time, from s.Clock if it is set.
+func (s *Sheet) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// random calls f with the sheet's random source. Calls are serialized, since a rand.Rand may not
+// be used from several goroutines at once.
+func (s *Sheet) random(f func(r *rand.Rand)) {
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+	if s.Rand == nil {
+		s.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	f(s.Rand)
+}
+
+// NOW() returns the current date and time as a serial date.
+func fnNow(s *Sheet, args []*Expression) (float64, error) {
+	if _, err := evalArgs(s, "NOW", args, 0, 0); err != nil {
+		return 0, err
+	}
+	return timeToSerial(s.now()), nil
+}
+
+// TODAY() returns the current date as a serial date.
+func fnToday(s *Sheet, args []*Expression) (float64, error) {
+	if _, err := evalArgs(s, "TODAY", args, 0, 0); err != nil {
+		return 0, err
+	}
+	return math.Floor(timeToSerial(s.now())), nil
+}
+
+// RAND() returns a random number in [0, 1).
+func fnRand(s *Sheet, args []*Expression) (float64, error) {
+	if _, err := evalArgs(s, "RAND", args, 0, 0); err != nil {
+		return 0, err
+	}
+	var f float64
+	s.random(func(r *rand.Rand) { f = r.Float64() })
+	return f, nil
+}
+
+// RANDBETWEEN(low, high) returns a random integer between low and high, inclusive. There may be
+// no more than 2^53 integers between them, as beyond that not every integer is a number.
+func fnRandBetween(s *Sheet, args []*Expression) (float64, error) {
+	vals, err := evalArgs(s, "RANDBETWEEN", args, 2, 2)
+	if err != nil {
+		return 0, err
+	}
+	low, high := math.Ceil(vals[0]), math.Floor(vals[1])
+	if low > high {
+		return 0, fmt.Errorf("RANDBETWEEN: %v is greater than %v", vals[0], vals[1])
+	}
+	if !(high-low <= maxExactInt) {
+		return 0, fmt.Errorf("%w RANDBETWEEN: %v to %v is too wide a range", ErrNum, vals[0], vals[1])
+	}
+	var n int64
+	s.random(func(r *rand.Rand) { n = r.Int63n(int64(high-low) + 1) })
+	return low + float64(n), nil
+}
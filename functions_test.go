@@ -0,0 +1,420 @@
+package sheet
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolatile(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2026, time.October, 16, 18, 0, 0, 0, time.UTC)
+	sheet := NewSheet()
+	sheet.Clock = func() time.Time { return now }
+	sheet.Rand = rand.New(rand.NewSource(1))
+
+	sheet.SetContent("A1", "=NOW()")
+	sheet.SetContent("A2", "=TODAY()")
+	sheet.SetContent("A3", "=RANDBETWEEN(1,6)")
+	sheet.SetContent("A4", "=RAND()")
+	sheet.SetContent("B1", "=A1+A2")
+	sheet.SetContent("C1", "=1+2")
+	assert.Len(sheet.volatile, 4)
+
+	v, err := sheet.ValueAt("A1")
+	assert.NoError(err)
+	assert.Equal(46311.75, v)
+	v, err = sheet.ValueAt("A2")
+	assert.NoError(err)
+	assert.Equal(float64(46311), v)
+	v, err = sheet.ValueAt("A3")
+	assert.NoError(err)
+	assert.True(v >= 1 && v <= 6 && v == float64(int(v)))
+	v, err = sheet.ValueAt("A4")
+	assert.NoError(err)
+	assert.True(v >= 0 && v < 1)
+
+	var updated []string
+	sheet.Subscribe(func(ev CellEvent) {
+		updated = append(updated, ev.Addr.String())
+	})
+	now = now.Add(36 * time.Hour)
+	sheet.RecalculateVolatile()
+	assert.Equal([]string{"A1", "A2", "A3", "A4", "B1"}, updated)
+	v, err = sheet.ValueAt("B1")
+	assert.NoError(err)
+	assert.Equal(46313.25+46313, v)
+
+	updated = nil
+	sheet.RecalculateAll()
+	assert.Len(updated, 6)
+
+	sheet.SetContent("A1", "5")
+	sheet.SetContent("A4", "")
+	assert.Len(sheet.volatile, 2)
+}
+
+func TestVolatileDeterministic(t *testing.T) {
+	assert := assert.New(t)
+	values := func() []float64 {
+		sheet := NewSheet()
+		sheet.Rand = rand.New(rand.NewSource(42))
+		sheet.SetContent("A1", "=RAND()")
+		var vs []float64
+		for i := 0; i < 5; i++ {
+			sheet.RecalculateVolatile()
+			v, err := sheet.ValueAt("A1")
+			assert.NoError(err)
+			vs = append(vs, v)
+		}
+		return vs
+	}
+	assert.Equal(values(), values())
+}
+
+func TestFunctionErrors(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	for addr, content := range map[string]string{
+		"A1": "=NOW(1)",
+		"A2": "=RANDBETWEEN(1)",
+		"A3": "=RANDBETWEEN(6,1)",
+		"A4": "=NOSUCHFUNCTION()",
+		"A5": "=RANDBETWEEN(0,1e19)",
+		"A6": "=RANDBETWEEN(-1e300,1e300)",
+	} {
+		sheet.SetContent(addr, content)
+		_, err := sheet.ValueAt(addr)
+		assert.Error(err, addr)
+	}
+	for _, addr := range []string{"A5", "A6"} {
+		_, err := sheet.ValueAt(addr)
+		assert.True(errors.Is(err, ErrNum), addr)
+	}
+}
+
+// evalCases sets up a sheet with cells, then checks the content of each cell in expect.
+func evalCases(t *testing.T, cells map[string]string, expect map[string]string) {
+	sheet := NewSheet()
+	for addr, content := range cells {
+		assert.NoError(t, sheet.SetContent(addr, content))
+	}
+	for addr, want := range expect {
+		got, err := sheet.ContentAt(addr)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, addr)
+	}
+}
+
+func TestDateInput(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		value   float64
+		expect  string
+	}{
+		"date":          {content: "2026-10-16", value: 46311, expect: "2026-10-16"},
+		"datetime":      {content: "2026-10-16T18:00", value: 46311.75, expect: "2026-10-16 18:00:00"},
+		"datetime/zone": {content: "2026-10-16T06:00:00Z", value: 46311.25, expect: "2026-10-16 06:00:00"},
+		"datetime/sp":   {content: "2026-10-16 12:00:00", value: 46311.5, expect: "2026-10-16 12:00:00"},
+		"time":          {content: "06:00", value: 0.25, expect: "06:00:00"},
+		"duration":      {content: "P1DT12H", value: 1.5, expect: "P1DT12H"},
+		"duration/week": {content: "P2W", value: 14, expect: "P14D"},
+		"epoch":         {content: "1900-03-01", value: 61, expect: "1900-03-01"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			assert.NoError(sheet.SetContent("A1", tt.content))
+			v, err := sheet.ValueAt("A1")
+			assert.NoError(err)
+			assert.InDelta(tt.value, v, 1e-9)
+			c, err := sheet.ContentAt("A1")
+			assert.NoError(err)
+			assert.Equal(tt.expect, c)
+			e, err := sheet.EditAt("A1")
+			assert.NoError(err)
+			assert.Equal(tt.content, e)
+		})
+	}
+
+	for _, notDate := range []string{"2026-13-01", "P", "PT", "P1Y", "10/16/2026", "25:00"} {
+		sheet := NewSheet()
+		assert.NoError(t, sheet.SetContent("A1", notDate))
+		assert.Equal(t, cell_string, sheet.cellAt(CellAddress{col: "A", row: 1}).cell_type, notDate)
+	}
+}
+
+func TestDateArithmetic(t *testing.T) {
+	evalCases(t, map[string]string{
+		"A1": "2026-10-16",
+		"A2": "2026-12-25",
+		"A3": "P1DT12H",
+		"A4": "09:30",
+		"B1": "=A1+14",
+		"B2": "=A2-A1",
+		"B3": "=A1+A3",
+		"B4": "=A3*2",
+		"B5": "=A1+A4",
+		"B6": "=A1-7",
+		"B7": "=10-4/2*3",
+		"B8": "=A1/0",
+	}, map[string]string{
+		"B1": "2026-10-30",
+		"B2": "70",
+		"B3": "2026-10-17 12:00:00",
+		"B4": "P3D",
+		"B5": "2026-10-16 09:30:00",
+		"B6": "2026-10-09",
+		"B7": "4",
+		"B8": "B8: Division by zero",
+	})
+}
+
+func TestDateFunctions(t *testing.T) {
+	evalCases(t, map[string]string{
+		"A1":  "2026-01-31",
+		"A2":  "2026-10-16",
+		"A3":  "2026-10-19",
+		"A4":  "Y",
+		"B1":  "=DATE(2026,10,16)",
+		"B2":  "=DATE(2026,13,1)",
+		"B3":  "=YEAR(A2)",
+		"B4":  "=MONTH(A2)",
+		"B5":  "=DAY(A2)",
+		"B6":  "=EDATE(A1,1)",
+		"B7":  "=EDATE(A1,-2)",
+		"B8":  "=NETWORKDAYS(A2,DATE(2026,10,30))",
+		"B9":  "=NETWORKDAYS(A2,DATE(2026,10,30),A3)",
+		"B10": "=NETWORKDAYS(DATE(2026,10,30),A2)",
+		"C1":  `=DATEDIF(A1,A2,"Y")`,
+		"C2":  `=DATEDIF(A1,A2,"m")`,
+		"C3":  `=DATEDIF(A1,A2,"D")`,
+		"C4":  `=DATEDIF(A1,A2,"MD")`,
+		"C5":  `=DATEDIF(A1,A2,"YM")`,
+		"C6":  `=DATEDIF(DATE(2024,12,1),A2,"YD")`,
+		"C7":  `=DATEDIF(DATE(2020,1,1),A2,A4)`,
+		"C8":  `=DATEDIF(A2,A1,"D")`,
+		"C9":  `=DATEDIF(A1,A2,"W")`,
+		"D1":  "=EDATE(A2,1)-A2",
+		"D2":  "=TODAY()-TODAY()",
+		"E1":  "=DATE(1e300,1,1)",
+		"E2":  "=DATE(2020,1e10,1)",
+		"E3":  "=DATE(2020,1,-1e300)",
+		"E4":  "=EDATE(1,1e300)",
+		"E5":  "=YEAR(1e300)",
+		"E6":  "=EDATE(1e300,1)",
+		"E7":  "=DAY(-1e300)",
+		"E8":  `=DATEDIF(0,1e300,"D")`,
+		"E9":  "=YEAR(DATE(9999,12,31))",
+		"E10": `=DATEDIF(DATE(1,1,1),DATE(9999,12,31),"D")`,
+		"G1":  "2026-10-20",
+		"G2":  "2026-10-24",
+		"F3":  "=NETWORKDAYS(A2,DATE(2026,10,30),G1:G3)",
+		"F4":  "=NETWORKDAYS(A2,DATE(2026,10,30),A3,G1:G2,A3)",
+		"F5":  "=NETWORKDAYS(0,1e8)",
+		"F6":  "=NETWORKDAYS(-10,10)",
+		"F7":  "=NETWORKDAYS(A2,A2)",
+		"F8":  "=NETWORKDAYS(DATE(2026,10,17),DATE(2026,10,18))",
+	}, map[string]string{
+		"B1":  "2026-10-16",
+		"B2":  "2027-01-01",
+		"B3":  "2026",
+		"B4":  "10",
+		"B5":  "16",
+		"B6":  "2026-02-28",
+		"B7":  "2025-11-30",
+		"B8":  "11",
+		"B9":  "10",
+		"B10": "-11",
+		"C1":  "0",
+		"C2":  "8",
+		"C3":  "258",
+		"C4":  "16",
+		"C5":  "8",
+		"C6":  "319",
+		"C7":  "6",
+		"C8":  "C8: DATEDIF: start date is after end date",
+		"C9":  `C9: DATEDIF: unknown unit "W"`,
+		"D1":  "31",
+		"D2":  "0",
+		"E1":  "E1: #NUM! DATE: 1e+300 is out of range",
+		"E2":  "E2: #NUM! DATE: 1e+10 is out of range",
+		"E3":  "E3: #NUM! DATE: -1e+300 is out of range",
+		"E4":  "E4: #NUM! EDATE: 1e+300 is out of range",
+		"E5":  "E5: #NUM! YEAR: date 1e+300 is out of range",
+		"E6":  "E6: #NUM! EDATE: date 1e+300 is out of range",
+		"E7":  "E7: #NUM! DAY: date -1e+300 is out of range",
+		"E8":  "E8: #NUM! DATEDIF: date 1e+300 is out of range",
+		"E9":  "9999",
+		"E10": "3652058",
+		"F3":  "10",
+		"F4":  "9",
+		"F5":  "71428571",
+		"F6":  "15",
+		"F7":  "1",
+		"F8":  "0",
+	})
+}
+
+func TestComparisons(t *testing.T) {
+	evalCases(t, map[string]string{
+		"A1":  "2",
+		"A2":  "abc",
+		"A3":  "=TRUE",
+		"B1":  "=A1=2",
+		"B2":  "=A1<>2",
+		"B3":  "=A1<3",
+		"B4":  "=A1<=1",
+		"B5":  "=A1>1",
+		"B6":  "=A1>=2.5",
+		"B7":  `=A2="ABC"`,
+		"B8":  `=A2<"abd"`,
+		"B9":  `=A1<A2`,
+		"B10": `=A2<A3`,
+		"B11": "=Z1=0",
+		"B12": `=Z1=""`,
+		"B13": "=Z1=FALSE",
+		"B14": "=Z1<Z2",
+		"B15": "=1+1=A1",
+		"B16": `=A1&"x"="2X"`,
+		"B17": "=IF(A1>1,10,20)",
+		"B18": "=SUM((A1:A3>1)*1)",
+	}, map[string]string{
+		"B1":  "TRUE",
+		"B2":  "FALSE",
+		"B3":  "TRUE",
+		"B4":  "FALSE",
+		"B5":  "TRUE",
+		"B6":  "FALSE",
+		"B7":  "TRUE",
+		"B8":  "TRUE",
+		"B9":  "TRUE",
+		"B10": "TRUE",
+		"B11": "TRUE",
+		"B12": "TRUE",
+		"B13": "TRUE",
+		"B14": "FALSE",
+		"B15": "TRUE",
+		"B16": "TRUE",
+		"B17": "10",
+		"B18": "3",
+	})
+}
+
+func TestTextFunctions(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		expect  string
+	}{
+		"len":                 {content: `=LEN("héllo")`, expect: "5"},
+		"len/empty":           {content: `=LEN(Z99)`, expect: "0"},
+		"len/number":          {content: `=LEN(1/4)`, expect: "4"},
+		"left":                {content: `=LEFT("日本語テキスト",3)`, expect: "日本語"},
+		"left/default":        {content: `=LEFT("héllo")`, expect: "h"},
+		"left/long":           {content: `=LEFT("abc",10)`, expect: "abc"},
+		"right":               {content: `=RIGHT("héllo",4)`, expect: "éllo"},
+		"right/default":       {content: `=RIGHT("abc")`, expect: "c"},
+		"mid":                 {content: `=MID("naïve café",3,6)`, expect: "ïve ca"},
+		"mid/past":            {content: `=MID("abc",5,2)`, expect: ""},
+		"mid/short":           {content: `=MID("abc",2,10)`, expect: "bc"},
+		"mid/huge":            {content: `=MID("abc",1,1e300)`, expect: "abc"},
+		"mid/huge-start":      {content: `=MID("abc",1e300,1)`, expect: ""},
+		"left/huge":           {content: `=LEFT("abc",1e300)`, expect: "abc"},
+		"right/huge":          {content: `=RIGHT("abc",1e300)`, expect: "abc"},
+		"left/empty":          {content: `=LEFT("")`, expect: ""},
+		"left/blank":          {content: `=LEFT(Z99)`, expect: ""},
+		"right/empty":         {content: `=RIGHT("")`, expect: ""},
+		"right/blank":         {content: `=RIGHT(Z99,2)`, expect: ""},
+		"substitute/huge":     {content: `=SUBSTITUTE("a-b-c","-","+",1e300)`, expect: "a-b-c"},
+		"upper":               {content: `=UPPER("héllo wörld")`, expect: "HÉLLO WÖRLD"},
+		"lower":               {content: `=LOWER("ÀB")`, expect: "àb"},
+		"trim":                {content: `=TRIM("  a   b  c ")`, expect: "a b c"},
+		"substitute":          {content: `=SUBSTITUTE("a-b-c","-","+")`, expect: "a+b+c"},
+		"substitute/instance": {content: `=SUBSTITUTE("a-b-c","-","+",2)`, expect: "a-b+c"},
+		"substitute/missing":  {content: `=SUBSTITUTE("a-b-c","-","+",3)`, expect: "a-b-c"},
+		"find":                {content: `=FIND("é","café crème")`, expect: "4"},
+		"find/start":          {content: `=FIND("c","café crème",2)`, expect: "6"},
+		"find/case":           {content: `=FIND("C","café Crème")`, expect: "6"},
+		"text/fixed":          {content: `=TEXT(1234.5,"fixed:2")`, expect: "1234.50"},
+		"text/date":           {content: `=TEXT(DATE(2026,10,16),"date")`, expect: "2026-10-16"},
+		"text/percent":        {content: `=TEXT(0.25,"percent")`, expect: "25%"},
+		"value":               {content: `=VALUE(" 12.5 ")*2`, expect: "25"},
+		"value/date":          {content: `=VALUE("2026-10-16")`, expect: "46311"},
+		"cat":                 {content: `="Total: "&1+2`, expect: "Total: 3"},
+		"cat/number":          {content: `=1&2`, expect: "12"},
+		"regexmatch":          {content: `=REGEXMATCH("order-123","[0-9]+")`, expect: "TRUE"},
+		"regexmatch/false":    {content: `=REGEXMATCH("order","[0-9]+")`, expect: "FALSE"},
+		"regexextract":        {content: `=REGEXEXTRACT("order-123","[0-9]+")`, expect: "123"},
+		"regexextract/group":  {content: `=REGEXEXTRACT("key=value","=(.*)")`, expect: "value"},
+		"regexreplace":        {content: `=REGEXREPLACE("2026-10-16","(\d+)-(\d+)-(\d+)","$3.$2.$1")`, expect: "16.10.2026"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			assert.NoError(sheet.SetContent("A1", tt.content))
+			got, err := sheet.ContentAt("A1")
+			assert.NoError(err)
+			assert.Equal(tt.expect, got)
+		})
+	}
+}
+
+func TestTextFunctionErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"find/missing":        `=FIND("x","abc")`,
+		"find/start":          `=FIND("a","abc",5)`,
+		"find/huge":           `=FIND("a","abc",1e300)`,
+		"find/huge-empty":     `=FIND("","abc",1e300)`,
+		"mid/start":           `=MID("abc",0,1)`,
+		"left/negative":       `=LEFT("abc",-1)`,
+		"value":               `=VALUE("abc")`,
+		"text/format":         `=TEXT(1,"bogus")`,
+		"regexextract":        `=REGEXEXTRACT("abc","[0-9]")`,
+		"regex/pattern":       `=REGEXMATCH("abc","(")`,
+		"arith/string":        `="abc"+1`,
+		"len/args":            `=LEN("a","b")`,
+		"substitute/instance": `=SUBSTITUTE("abc","b","c",0)`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			sheet := NewSheet()
+			assert.NoError(t, sheet.SetContent("A1", content))
+			_, err := sheet.ValueAt("A1")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestTextCells(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	assert.NoError(sheet.SetContent("A1", "Ada"))
+	assert.NoError(sheet.SetContent("A2", "Lovelace"))
+	assert.NoError(sheet.SetContent("A3", "12"))
+	assert.NoError(sheet.SetContent("B1", `=A1&" "&UPPER(A2)`))
+	assert.NoError(sheet.SetContent("B2", "=LEN(B1)"))
+	assert.NoError(sheet.SetContent("B3", `=LEFT(B1,1)&"."`))
+	assert.NoError(sheet.SetContent("B4", "=A3&A3"))
+	assert.NoError(sheet.SetContent("B5", "=B4+1"))
+
+	got, _ := sheet.ContentAt("B1")
+	assert.Equal("Ada LOVELACE", got)
+	got, _ = sheet.ContentAt("B3")
+	assert.Equal("A.", got)
+	v, err := sheet.ValueAt("B2")
+	assert.NoError(err)
+	assert.Equal(float64(12), v)
+	_, err = sheet.ValueAt("B1")
+	assert.Error(err)
+	v, err = sheet.ValueAt("B5")
+	assert.NoError(err)
+	assert.Equal(float64(1213), v)
+
+	assert.NoError(sheet.SetContent("A1", "Grace"))
+	got, _ = sheet.ContentAt("B1")
+	assert.Equal("Grace LOVELACE", got)
+	v, _ = sheet.ValueAt("B2")
+	assert.Equal(float64(14), v)
+}
@@ -5,6 +5,7 @@ go 1.17
 require (
 	9fans.net/go v0.0.4
 	github.com/knusbaum/go9p v1.18.0
+	github.com/olekukonko/tablewriter v0.0.5
 	github.com/stretchr/testify v1.7.0
 )
 
@@ -14,7 +15,6 @@ require (
 	github.com/emersion/go-sasl v0.0.0-20200509203442-7bfe0ed36a21 // indirect
 	github.com/fhs/mux9p v0.3.1 // indirect
 	github.com/mattn/go-runewidth v0.0.9 // indirect
-	github.com/olekukonko/tablewriter v0.0.5 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
@@ -0,0 +1,213 @@
+package sheet
+
+import (
+	"fmt"
+)
+
+// maxLambdaDepth is the most LAMBDA calls that may be nested in one another, as when a LAMBDA
+// defined by (*Sheet).DefineLambda calls itself.
+const maxLambdaDepth = 1000
+
+// lambda is a function made by LAMBDA. Names are bound lexically: any names bound where the LAMBDA
+// was made have already been replaced in body by their values, so only params remain to be bound
+// when it is called.
+type lambda struct {
+	params []string
+	body   *Expression
+}
+
+// newLambda makes the lambda of LAMBDA(params..., body), with the names of the parameters followed
+// by the body in args.
+func newLambda(args []*Expression) (*lambda, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("LAMBDA: expected a body")
+	}
+	params := make([]string, len(args)-1)
+	seen := make(map[string]bool)
+	for i, a := range args[:len(args)-1] {
+		if a.op != NAME {
+			return nil, fmt.Errorf("LAMBDA: parameter %d is not a name", i+1)
+		}
+		if seen[a.val] {
+			return nil, fmt.Errorf("LAMBDA: parameter %s is repeated", a.val)
+		}
+		seen[a.val] = true
+		params[i] = a.val
+	}
+	return &lambda{params: params, body: args[len(args)-1]}, nil
+}
+
+// call evaluates l with its parameters bound to args. depth is the number of LAMBDA calls the call
+// is nested in.
+func (l *lambda) call(s *Sheet, args []*Expression, depth int) (value, error) {
+	if depth >= maxLambdaDepth {
+		return value{}, fmt.Errorf("LAMBDA calls are nested more than %d deep", maxLambdaDepth)
+	}
+	if len(args) != len(l.params) {
+		return value{}, fmt.Errorf("LAMBDA: expected %d arguments, but got %d", len(l.params), len(args))
+	}
+	env := make(map[string]*Expression, len(args))
+	for i, a := range args {
+		b, err := bindingOf(s, a)
+		if err != nil {
+			return value{}, err
+		}
+		env[l.params[i]] = b
+	}
+	return l.body.bind(env, depth+1).evalValue(s)
+}
+
+// bindingOf returns what a name bound to e is replaced by. A reference stays a reference, so that
+// functions like SUM and OFFSET treat the name as they would the reference. Anything else is
+// evaluated once, and its value used in its place.
+func bindingOf(s *Sheet, e *Expression) (*Expression, error) {
+	if isReference(e) {
+		return e, nil
+	}
+	v, err := e.evalValue(s)
+	if err != nil {
+		return nil, err
+	}
+	return &Expression{op: CONST, bound: v}, nil
+}
+
+// bind returns a copy of e in which the names in env are replaced by their bindings, except where
+// they are bound again by a LET or LAMBDA inside of e. A name called as a function, as in f(1),
+// calls the LAMBDA it is bound to. Calls in the copy are made at least depth LAMBDA calls deep.
+func (e *Expression) bind(env map[string]*Expression, depth int) *Expression {
+	if e.op == NAME {
+		if b, ok := env[e.val]; ok {
+			return b
+		}
+	}
+	c := *e
+	// The compiled form of e evaluates the expressions of e, not those of the copy.
+	c.compiled, c.cell = nil, nil
+	if c.depth < depth {
+		c.depth = depth
+	}
+	if e.left != nil {
+		c.left = e.left.bind(env, depth)
+	}
+	if e.right != nil {
+		c.right = e.right.bind(env, depth)
+	}
+	if e.args != nil {
+		c.args = make([]*Expression, len(e.args))
+	}
+	// A name being bound by LET hides any binding of the same name from the arguments after its
+	// value, and a parameter of LAMBDA hides it from the body.
+	inner := env
+	for i, a := range e.args {
+		if e.bindsName(i) {
+			c.args[i] = a
+			if e.val == "LAMBDA" {
+				inner = without(inner, a.val)
+			}
+			continue
+		}
+		c.args[i] = a.bind(inner, depth)
+		if i > 0 && e.val == "LET" && e.bindsName(i-1) {
+			inner = without(inner, e.args[i-1].val)
+		}
+	}
+	if b, ok := env[e.val]; ok && e.op == FUNC {
+		return &Expression{op: CALL, left: b, args: c.args, depth: c.depth}
+	}
+	return &c
+}
+
+// bindsName returns true if argument i of e is a name being bound by LET or LAMBDA.
+func (e *Expression) bindsName(i int) bool {
+	if e.op != FUNC || i == len(e.args)-1 {
+		return false
+	}
+	return e.val == "LAMBDA" || e.val == "LET" && i%2 == 0
+}
+
+// without returns a copy of env without name, or env itself if name isn't in it.
+func without(env map[string]*Expression, name string) map[string]*Expression {
+	if _, ok := env[name]; !ok {
+		return env
+	}
+	c := make(map[string]*Expression, len(env))
+	for n, b := range env {
+		if n != name {
+			c[n] = b
+		}
+	}
+	return c
+}
+
+// LET(name, value, ..., body) returns the value of body, in which each name stands for the value
+// after it. Each value may use the names before it.
+func fnLet(s *Sheet, args []*Expression) (value, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return value{}, fmt.Errorf("LET: expected names and values followed by a body")
+	}
+	env := make(map[string]*Expression)
+	for i := 0; i < len(args)-1; i += 2 {
+		if args[i].op != NAME {
+			return value{}, fmt.Errorf("LET: argument %d is not a name", i+1)
+		}
+		b, err := bindingOf(s, args[i+1].bind(env, 0))
+		if err != nil {
+			return value{}, err
+		}
+		env[args[i].val] = b
+	}
+	return args[len(args)-1].bind(env, 0).evalValue(s)
+}
+
+// LAMBDA(params..., body) returns a function of params, which computes body when called, as in
+// LAMBDA(x, x*2)(A1). It may also be bound to a name by LET, or defined for the whole sheet by
+// (*Sheet).DefineLambda, and called by that name.
+func fnLambda(s *Sheet, args []*Expression) (value, error) {
+	l, err := newLambda(args)
+	if err != nil {
+		return value{}, err
+	}
+	return value{kind: valLambda, fn: l}, nil
+}
+
+// IF(condition, then, [else]) returns then if condition is TRUE or a number other than 0, and else,
+// which defaults to FALSE, otherwise. Only the value returned is evaluated, so a LAMBDA may call
+// itself in one of them.
+func fnIf(s *Sheet, args []*Expression) (value, error) {
+	if err := checkArgCount("IF", args, 2, 3); err != nil {
+		return value{}, err
+	}
+	cond, err := args[0].Eval(s)
+	if err != nil {
+		return value{}, err
+	}
+	if cond != 0 {
+		return args[1].evalValue(s)
+	}
+	if len(args) > 2 {
+		return args[2].evalValue(s)
+	}
+	return boolValue(false), nil
+}
+
+// DefineLambda is like DefineName, but formula must be a LAMBDA, as in
+//
+//	s.DefineLambda("HYPOT", "=LAMBDA(a,b,SQRT(a*a+b*b))")
+//
+// It can then be called like a built-in function, as in =HYPOT(3,4). It may call itself, or other
+// LAMBDAs defined in s, including ones defined later. Defining it as "" removes it.
+func (s *Sheet) DefineLambda(name, formula string) error {
+	if formula != "" {
+		e, err := parseDefinition(formula)
+		if err != nil {
+			return err
+		}
+		if e.op != FUNC || e.val != "LAMBDA" {
+			return fmt.Errorf("%s is not a LAMBDA", formula)
+		}
+		if _, err := newLambda(e.args); err != nil {
+			return err
+		}
+	}
+	return s.DefineName(name, formula)
+}
@@ -0,0 +1,131 @@
+package sheet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLet(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		expect  string
+	}{
+		"let":              {content: "=LET(x,2,y,x*3,x+y)", expect: "8"},
+		"let/case":         {content: "=let(Total,2,TOTAL*3)", expect: "6"},
+		"let/shadow":       {content: "=LET(x,1,LET(x,x+1,x*10))", expect: "20"},
+		"let/rebind":       {content: "=LET(x,1,x,x+1,x)", expect: "2"},
+		"let/range":        {content: "=LET(r,A1:A4,SUM(r))", expect: "6"},
+		"let/reference":    {content: "=LET(r,A1:A2,SUM(OFFSET(r,1,0)))", expect: "5"},
+		"let/text":         {content: `=LET(greeting,"hi ",greeting&A4)`, expect: "hi text"},
+		"lambda":           {content: "=LAMBDA(x,y,x*y)(3,4)", expect: "12"},
+		"lambda/let":       {content: "=LET(f,LAMBDA(x,x*2),f(f(3)))", expect: "12"},
+		"lambda/lexical":   {content: "=LET(k,10,f,LAMBDA(x,x+k),k,1,f(1))", expect: "11"},
+		"lambda/argument":  {content: "=LET(twice,LAMBDA(g,x,g(g(x))),twice(LAMBDA(y,y*3),2))", expect: "18"},
+		"lambda/closure":   {content: "=LET(adder,LAMBDA(n,LAMBDA(x,x+n)),adder(5)(2))", expect: "7"},
+		"lambda/noargs":    {content: "=LAMBDA(A2*7)()", expect: "14"},
+		"lambda/param":     {content: "=LET(x,100,LAMBDA(x,x+1)(1))", expect: "2"},
+		"lambda/recursive": {content: "=LET(fact,LAMBDA(f,n,IF(n,n*f(f,n-1),1)),fact(fact,5))", expect: "120"},
+		"if":               {content: `=IF(A1,"yes","no")`, expect: "yes"},
+		"if/false":         {content: `=IF(A1-1,"yes")`, expect: "FALSE"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			sheet.SetContent("A1", "1")
+			sheet.SetContent("A2", "2")
+			sheet.SetContent("A3", "3")
+			sheet.SetContent("A4", "text")
+			assert.NoError(sheet.SetContent("Z9", tt.content))
+			got, err := sheet.ContentAt("Z9")
+			assert.NoError(err)
+			assert.Equal(tt.expect, got)
+		})
+	}
+}
+
+func TestLetErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"name/unbound":     "=x+1",
+		"name/scope":       "=LET(x,1,x)+x",
+		"let/args":         "=LET(x,1)",
+		"let/name":         "=LET(A1,1,A1)",
+		"lambda/uncalled":  "=LAMBDA(x,x)",
+		"lambda/count":     "=LAMBDA(x,y,x+y)(1)",
+		"lambda/param":     "=LAMBDA(A1,A1)(1)",
+		"lambda/repeated":  "=LAMBDA(x,x,x)(1,2)",
+		"lambda/arith":     "=LET(f,LAMBDA(x,x),f+1)",
+		"call/value":       "=LET(f,1,f(1))",
+		"lambda/recursion": "=LET(f,LAMBDA(g,g(g)),f(f))",
+		"if/text":          `=IF("x",1,2)`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			sheet := NewSheet()
+			assert.NoError(t, sheet.SetContent("Z9", content))
+			_, err := sheet.ValueAt("Z9")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDefineLambda(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			setup(sheet)
+			assert.NoError(sheet.DefineLambda("Fact", "=LAMBDA(n,IF(n,n*FACT(n-1),1))"))
+			sheet.SetContent("A1", "=fact(5)")
+			got, _ := sheet.ContentAt("A1")
+			assert.Equal("120", got)
+
+			// Cells calling a LAMBDA depend on the cells it references, even through other LAMBDAs.
+			sheet.SetContent("B1", "2")
+			assert.NoError(sheet.DefineLambda("SCALE", "LAMBDA(x,x*B1)"))
+			assert.NoError(sheet.DefineLambda("SCALETWICE", "=LAMBDA(x,SCALE(SCALE(x)))"))
+			sheet.SetContent("C1", "=SCALE(3)+SCALETWICE(1)")
+			got, _ = sheet.ContentAt("C1")
+			assert.Equal("10", got)
+			sheet.SetContent("B1", "3")
+			got, _ = sheet.ContentAt("C1")
+			assert.Equal("18", got)
+			deps, err := sheet.Dependents("B1", false)
+			assert.NoError(err)
+			assert.Equal([]CellAddress{{"C", 1}}, deps)
+
+			// Redefining a LAMBDA recalculates the cells calling it.
+			assert.NoError(sheet.DefineLambda("scale", "=LAMBDA(x,x+B1)"))
+			got, _ = sheet.ContentAt("C1")
+			assert.Equal("13", got)
+			assert.NoError(sheet.DefineLambda("SCALE", ""))
+			_, err = sheet.ValueAt("C1")
+			assert.Error(err)
+
+			// Infinite recursion stops at the depth limit.
+			assert.NoError(sheet.DefineLambda("LOOP", "=LAMBDA(n,LOOP(n+1))"))
+			sheet.SetContent("D1", "=LOOP(1)")
+			_, err = sheet.ValueAt("D1")
+			assert.Error(err)
+		})
+	}
+}
+
+func TestDefineLambdaErrors(t *testing.T) {
+	sheet := NewSheet()
+	for name, tt := range map[string]struct{ name, formula string }{
+		"name/address": {"A1", "=LAMBDA(x,x)"},
+		"name/builtin": {"SUM", "=LAMBDA(x,x)"},
+		"name/invalid": {"2X", "=LAMBDA(x,x)"},
+		"formula":      {"F", "=1+2"},
+		"param":        {"F", "=LAMBDA(A1,A1)"},
+		"parse":        {"F", "=LAMBDA(x,"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, sheet.DefineLambda(tt.name, tt.formula))
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+)
+
+// matchMode is how a lookup function compares the value it looks for with the values it searches.
+// The values are those of the match_mode argument of XLOOKUP.
+type matchMode int
+
+const (
+	// matchExact finds a value equal to the one looked for.
+	matchExact matchMode = 0
+	// matchSmaller finds an equal value, or else the largest value smaller than the one looked
+	// for. This is the approximate match of VLOOKUP and MATCH.
+	matchSmaller matchMode = -1
+	// matchLarger finds an equal value, or else the smallest value larger than the one looked
+	// for.
+	matchLarger matchMode = 1
+	// matchWildcard is like matchExact, but the text looked for may contain wildcards. See
+	// wildcardMatch.
+	matchWildcard matchMode = 2
+)
+
+// findMatch returns the index in vec of the value matching lookup according to mode, searching
+// from the end of vec if reverse is set. Of several equally good matches, the first one found is
+// returned. ok is false if nothing matches.
+func findMatch(lookup value, vec []value, mode matchMode, reverse bool) (idx int, ok bool) {
+	wild := mode == matchWildcard && lookup.kind == valString && hasWildcards(lookup.str)
+	best := -1
+	for n := range vec {
+		i := n
+		if reverse {
+			i = len(vec) - 1 - n
+		}
+		if wild {
+			if vec[i].kind == valString && wildcardMatch(lookup.str, vec[i].str) {
+				return i, true
+			}
+			continue
+		}
+		cmp, ok := compareValues(vec[i], lookup)
+		if !ok {
+			continue
+		}
+		switch {
+		case cmp == 0:
+			return i, true
+		case mode == matchSmaller && cmp < 0, mode == matchLarger && cmp > 0:
+			if best < 0 {
+				best = i
+			} else if c, _ := compareValues(vec[i], vec[best]); c == -int(mode) {
+				// vec[i] is closer to lookup than the best match so far.
+				best = i
+			}
+		}
+	}
+	return best, best >= 0
+}
+
+// errNotFound is the error for a lookup that finds nothing.
+func errNotFound(name string, lookup value) error {
+	if lookup.kind == valString {
+		return fmt.Errorf("%s: \"%s\" not found", name, lookup.str)
+	}
+	return fmt.Errorf("%s: %s not found", name, lookup.toString())
+}
+
+// indexArg converts v to a 1-based index for the function name, which must be at most n. If zero
+// is true, 0 is also allowed.
+func indexArg(name string, v value, n int, zero bool) (int, error) {
+	f, err := v.toNumber()
+	if err != nil {
+		return 0, err
+	}
+	i := int(f)
+	if i > n || i < 1 && !(zero && i == 0) {
+		return 0, fmt.Errorf("%s: index %v is out of range", name, f)
+	}
+	return i, nil
+}
+
+// column returns column j of rows.
+func column(rows [][]value, j int) []value {
+	col := make([]value, len(rows))
+	for i := range rows {
+		col[i] = rows[i][j]
+	}
+	return col
+}
+
+// tableLookup implements VLOOKUP and HLOOKUP, which are the same function on a table and its
+// transpose: lookup(value, table, index, [approximate]) finds value in the first column of table
+// and returns the value in the column index of the same row. Unless approximate is FALSE, the
+// first column must be sorted in ascending order, and the row of the largest value that is less
+// than or equal to value is used if there is no exact match.
+func tableLookup(name string, vertical bool) func(s *Sheet, args []*Expression) (value, error) {
+	return func(s *Sheet, args []*Expression) (value, error) {
+		vals, err := evalValueArgs(s, name, args, 3, 4)
+		if err != nil {
+			return value{}, err
+		}
+		lookup, err := vals[0].scalar()
+		if err != nil {
+			return value{}, err
+		}
+		rows := vals[1].rows()
+		if !vertical {
+			rows = transpose(rows)
+		}
+		idx, err := indexArg(name, vals[2], len(rows[0]), false)
+		if err != nil {
+			return value{}, err
+		}
+		mode := matchSmaller
+		if len(vals) > 3 {
+			approx, err := vals[3].toNumber()
+			if err != nil {
+				return value{}, err
+			}
+			if approx == 0 {
+				mode = matchWildcard
+			}
+		}
+		i, ok := findMatch(lookup, column(rows, 0), mode, false)
+		if !ok {
+			return value{}, errNotFound(name, lookup)
+		}
+		return rows[i][idx-1], nil
+	}
+}
+
+// transpose returns rows with rows and columns swapped.
+func transpose(rows [][]value) [][]value {
+	if len(rows) == 0 {
+		return nil
+	}
+	t := make([][]value, len(rows[0]))
+	for j := range t {
+		t[j] = column(rows, j)
+	}
+	return t
+}
+
+// INDEX(array, row, [column]) returns the value at row and column of array, counting from 1. A
+// row or column of 0 returns the whole column or row. If array is a single row, the second
+// argument is the column.
+func fnIndex(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "INDEX", args, 2, 3)
+	if err != nil {
+		return value{}, err
+	}
+	rows := vals[0].rows()
+	if len(vals) == 2 && len(rows) == 1 {
+		j, err := indexArg("INDEX", vals[1], len(rows[0]), false)
+		if err != nil {
+			return value{}, err
+		}
+		return rows[0][j-1], nil
+	}
+	i, err := indexArg("INDEX", vals[1], len(rows), true)
+	if err != nil {
+		return value{}, err
+	}
+	j := 1
+	if len(vals) > 2 {
+		if j, err = indexArg("INDEX", vals[2], len(rows[0]), true); err != nil {
+			return value{}, err
+		}
+	} else if len(rows[0]) > 1 {
+		j = 0
+	}
+	switch {
+	case i == 0 && j == 0:
+		return vals[0], nil
+	case i == 0:
+		col := make([][]value, len(rows))
+		for n := range rows {
+			col[n] = []value{rows[n][j-1]}
+		}
+		return arrayValue(col), nil
+	case j == 0:
+		return arrayValue([][]value{rows[i-1]}), nil
+	}
+	return rows[i-1][j-1], nil
+}
+
+// MATCH(value, vector, [type]) returns the position of value in vector, which is a single row or
+// column, counting from 1. With type 1, the default, vector must be sorted in ascending order and
+// the position of the largest value less than or equal to value is returned. With type -1, vector
+// must be sorted in descending order and the position of the smallest value greater than or equal
+// to value is returned. Type 0 finds only values equal to value.
+func fnMatch(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "MATCH", args, 2, 3)
+	if err != nil {
+		return value{}, err
+	}
+	lookup, err := vals[0].scalar()
+	if err != nil {
+		return value{}, err
+	}
+	vec, err := vals[1].vector()
+	if err != nil {
+		return value{}, fmt.Errorf("MATCH: %v", err)
+	}
+	mode := matchSmaller
+	if len(vals) > 2 {
+		t, err := vals[2].toNumber()
+		if err != nil {
+			return value{}, err
+		}
+		switch {
+		case t == 0:
+			mode = matchWildcard
+		case t < 0:
+			mode = matchLarger
+		}
+	}
+	i, ok := findMatch(lookup, vec, mode, false)
+	if !ok {
+		return value{}, errNotFound("MATCH", lookup)
+	}
+	return numberValue(float64(i + 1)), nil
+}
+
+// XLOOKUP(value, lookup, result, [not_found], [match_mode], [search_mode]) finds value in lookup,
+// a single row or column, and returns the value at the same position in result. If result has
+// several columns (or rows, if lookup is a row), the whole matching row (or column) is returned.
+// not_found is returned if nothing matches; without it, that is an error. match_mode is 0 for an
+// exact match (the default), -1 to fall back to the next smaller value, 1 to fall back to the next
+// larger value, or 2 for a wildcard match. search_mode is 1 to search from the first value (the
+// default) and -1 to search from the last. The binary search modes 2 and -2 are accepted, and
+// search like 1 and -1.
+func fnXLookup(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "XLOOKUP", args, 3, 6)
+	if err != nil {
+		return value{}, err
+	}
+	lookup, err := vals[0].scalar()
+	if err != nil {
+		return value{}, err
+	}
+	vec, err := vals[1].vector()
+	if err != nil {
+		return value{}, fmt.Errorf("XLOOKUP: %v", err)
+	}
+	result := vals[2].rows()
+	byRow := len(vals[1].rows()) > 1
+	if !byRow {
+		result = transpose(result)
+	}
+	if len(result) != len(vec) {
+		return value{}, fmt.Errorf("XLOOKUP: lookup and result ranges are different sizes")
+	}
+	mode := matchExact
+	if len(vals) > 4 {
+		m, err := vals[4].toNumber()
+		if err != nil {
+			return value{}, err
+		}
+		mode = matchMode(m)
+		if mode != matchExact && mode != matchSmaller && mode != matchLarger && mode != matchWildcard {
+			return value{}, fmt.Errorf("XLOOKUP: invalid match mode %v", m)
+		}
+	}
+	reverse := false
+	if len(vals) > 5 {
+		m, err := vals[5].toNumber()
+		if err != nil {
+			return value{}, err
+		}
+		switch m {
+		case 1, 2:
+		case -1, -2:
+			reverse = true
+		default:
+			return value{}, fmt.Errorf("XLOOKUP: invalid search mode %v", m)
+		}
+	}
+	i, ok := findMatch(lookup, vec, mode, reverse)
+	if !ok {
+		if len(vals) > 3 {
+			return vals[3], nil
+		}
+		return value{}, errNotFound("XLOOKUP", lookup)
+	}
+	if len(result[i]) == 1 {
+		return result[i][0], nil
+	}
+	if byRow {
+		return arrayValue([][]value{result[i]}), nil
+	}
+	return arrayValue(transpose([][]value{result[i]})), nil
+}
+
+// refArg returns the range referred to by the function argument e, and the sheet it is on. e must
+// be a cell address or a range, which may be on another sheet of the workbook of s, a call to a
+// function that returns a reference, such as OFFSET, or a name defined as one.
+func refArg(s *Sheet, name string, e *Expression) (*Sheet, Range, error) {
+	switch e.op {
+	case ID, RANGE:
+		if e.lastSheet != "" {
+			break
+		}
+		return sheetRange(s, e)
+	case FUNC:
+		if f, ok := functions[e.val]; ok && f.ref != nil {
+			return f.ref(s, e.args)
+		}
+	case NAME:
+		if d := s.definition(e); d != nil {
+			return refArg(s, name, d)
+		}
+	}
+	return nil, Range{}, fmt.Errorf("%s: expected a cell or range reference", name)
+}
+
+// sheetRange returns the range referred to by e, a cell address or a range, and the sheet of the
+// workbook of s it is on.
+func sheetRange(s *Sheet, e *Expression) (*Sheet, Range, error) {
+	t, err := s.refSheet(e.sheet)
+	if err != nil {
+		return nil, Range{}, err
+	}
+	rng, err := e.refRange()
+	if err != nil {
+		return nil, Range{}, err
+	}
+	return t, rng, nil
+}
+
+// INDIRECT(text) returns the contents of the cell or range whose address is text, as in
+// INDIRECT("B" & A1). The address may be on another sheet of the workbook, as in
+// INDIRECT("'Q3 Data'!B" & A1).
+func refIndirect(s *Sheet, args []*Expression) (*Sheet, Range, error) {
+	strs, err := textArgs(s, "INDIRECT", args, 1, 1)
+	if err != nil {
+		return nil, Range{}, err
+	}
+	// The text is parsed as a formula, so that it is read like the references in formulas are.
+	e, err := ParseExpression("=" + strs[0])
+	if err != nil || (e.op != ID && e.op != RANGE) || e.lastSheet != "" {
+		return nil, Range{}, fmt.Errorf("INDIRECT: Invalid reference '%s'", strs[0])
+	}
+	t, rng, err := sheetRange(s, e)
+	if err != nil {
+		return nil, Range{}, fmt.Errorf("INDIRECT: %w", err)
+	}
+	return t, rng, nil
+}
+
+// OFFSET(reference, rows, columns, [height], [width]) returns the contents of the range of height
+// rows and width columns whose upper left cell is rows below and columns to the right of the
+// upper left cell of reference, on the same sheet. height and width default to the size of
+// reference.
+func refOffset(s *Sheet, args []*Expression) (*Sheet, Range, error) {
+	if err := checkArgCount("OFFSET", args, 3, 5); err != nil {
+		return nil, Range{}, err
+	}
+	t, base, err := refArg(s, "OFFSET", args[0])
+	if err != nil {
+		return nil, Range{}, err
+	}
+	vals, err := evalArgs(s, "OFFSET", args[1:], 2, 4)
+	if err != nil {
+		return nil, Range{}, err
+	}
+	height, width := float64(base.height()), float64(base.width())
+	if len(vals) > 2 {
+		height = vals[2]
+	}
+	if len(vals) > 3 {
+		width = vals[3]
+	}
+	row := float64(base.start.row) + math.Trunc(vals[0])
+	col := float64(colIndex(base.start.col)) + math.Trunc(vals[1])
+	height, width = math.Trunc(height), math.Trunc(width)
+	lastColumn := float64(colIndex(lastCol))
+	if height < 1 || width < 1 {
+		return nil, Range{}, fmt.Errorf("OFFSET: height and width must be at least 1")
+	}
+	if row < 1 || col < 1 || row+height-1 > math.MaxUint32 || col+width-1 > lastColumn {
+		return nil, Range{}, fmt.Errorf("OFFSET: reference is outside of the sheet")
+	}
+	start := CellAddress{col: colName(int(col)), row: uint32(row)}
+	end := CellAddress{col: colName(int(col + width - 1)), row: uint32(row + height - 1)}
+	return t, NewRange(start, end), nil
+}
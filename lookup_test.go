@@ -0,0 +1,275 @@
+package sheet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// priceTable is a table of products, sorted by name, with their price and stock, and a table of
+// quantity discounts, sorted by quantity.
+var priceTable = map[string]string{
+	"A1": "Apple", "B1": "0.5", "C1": "100",
+	"A2": "Banana", "B2": "0.25", "C2": "150",
+	"A3": "Cherry", "B3": "3", "C3": "20",
+	"A4": "Date", "B4": "2.5", "C4": "0",
+	"E1": "1", "F1": "0",
+	"E2": "10", "F2": "0.05",
+	"E3": "100", "F3": "0.1",
+	"H1": "Qty", "I1": "1", "J1": "10", "K1": "100",
+	"H2": "Off", "I2": "0", "J2": "0.05", "K2": "0.1",
+}
+
+func TestLookupFunctions(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		expect  string
+	}{
+		"vlookup/exact":      {content: `=VLOOKUP("cherry",A1:C4,2,FALSE)`, expect: "3"},
+		"vlookup/column":     {content: `=VLOOKUP("Banana",A1:C4,3,FALSE)`, expect: "150"},
+		"vlookup/wildcard":   {content: `=VLOOKUP("B*",A1:C4,2,FALSE)`, expect: "0.25"},
+		"vlookup/approx":     {content: `=VLOOKUP(42,E1:F3,2)`, expect: "0.05"},
+		"vlookup/approx/top": {content: `=VLOOKUP(500,E1:F3,2,TRUE)`, expect: "0.1"},
+		"vlookup/text":       {content: `=VLOOKUP("Blueberry",A1:C4,1)`, expect: "Banana"},
+		"hlookup/exact":      {content: `=HLOOKUP(10,I1:K2,2,FALSE)`, expect: "0.05"},
+		"hlookup/approx":     {content: `=HLOOKUP(99,I1:K2,2)`, expect: "0.05"},
+		"index":              {content: `=INDEX(A1:C4,3,2)`, expect: "3"},
+		"index/vector":       {content: `=INDEX(B1:B4,4)`, expect: "2.5"},
+		"index/row":          {content: `=INDEX(I1:K1,2)`, expect: "10"},
+		"index/match":        {content: `=INDEX(C1:C4,MATCH("Date",A1:A4,0))`, expect: "0"},
+		"match/approx":       {content: `=MATCH(50,E1:E3)`, expect: "2"},
+		"match/exact":        {content: `=MATCH("apple",A1:A4,0)`, expect: "1"},
+		"match/row":          {content: `=MATCH(100,I1:K1,0)`, expect: "3"},
+		"match/larger":       {content: `=MATCH(0.07,F1:F3,-1)`, expect: "3"},
+		"xlookup":            {content: `=XLOOKUP("Cherry",A1:A4,C1:C4)`, expect: "20"},
+		"xlookup/notfound":   {content: `=XLOOKUP("Fig",A1:A4,C1:C4,"none")`, expect: "none"},
+		"xlookup/smaller":    {content: `=XLOOKUP(42,E1:E3,F1:F3,0,-1)`, expect: "0.05"},
+		"xlookup/larger":     {content: `=XLOOKUP(42,E1:E3,F1:F3,0,1)`, expect: "0.1"},
+		"xlookup/wildcard":   {content: `=XLOOKUP("?ate",A1:A4,B1:B4,0,2)`, expect: "2.5"},
+		"xlookup/horizontal": {content: `=XLOOKUP(100,I1:K1,I2:K2)`, expect: "0.1"},
+		"xlookup/reverse":    {content: `=XLOOKUP("*a*",A1:A4,A1:A4,"",2,-1)`, expect: "Date"},
+		"xlookup/row":        {content: `=INDEX(XLOOKUP("Banana",A1:A4,A1:C4),1,3)`, expect: "150"},
+		"xlookup/unsorted":   {content: `=XLOOKUP(1,C1:C4,A1:A4,"",1)`, expect: "Cherry"},
+		"lookup/cat":         {content: `=VLOOKUP("Apple",A1:C4,2,FALSE)*C1&" total"`, expect: "50 total"},
+		"range/single":       {content: `=A1:A1&"!"`, expect: "Apple!"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			for addr, content := range priceTable {
+				assert.NoError(sheet.SetContent(addr, content))
+			}
+			assert.NoError(sheet.SetContent("Z1", tt.content))
+			got, err := sheet.ContentAt("Z1")
+			assert.NoError(err)
+			assert.Equal(tt.expect, got)
+		})
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"vlookup/missing":  `=VLOOKUP("Fig",A1:C4,2,FALSE)`,
+		"vlookup/column":   `=VLOOKUP("Apple",A1:C4,4,FALSE)`,
+		"vlookup/smallest": `=VLOOKUP(0,E1:F3,2)`,
+		"vlookup/blank":    `=VLOOKUP(1,X1:Y2,2)`,
+		"hlookup/row":      `=HLOOKUP(1,I1:K2,3)`,
+		"index/row":        `=INDEX(A1:C4,5,1)`,
+		"index/column":     `=INDEX(A1:C4,1,0-1)`,
+		"match/missing":    `=MATCH("Fig",A1:A4,0)`,
+		"match/table":      `=MATCH("Apple",A1:C4,0)`,
+		"xlookup/missing":  `=XLOOKUP("Fig",A1:A4,B1:B4)`,
+		"xlookup/size":     `=XLOOKUP("Apple",A1:A4,B1:B3)`,
+		"xlookup/mode":     `=XLOOKUP("Apple",A1:A4,B1:B4,0,3)`,
+		"range/arith":      `=A1:A4+1`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			sheet := NewSheet()
+			for addr, content := range priceTable {
+				assert.NoError(t, sheet.SetContent(addr, content))
+			}
+			assert.NoError(t, sheet.SetContent("Z1", content))
+			_, err := sheet.ValueAt("Z1")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLookupDependencies(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	for addr, content := range priceTable {
+		assert.NoError(sheet.SetContent(addr, content))
+	}
+	assert.NoError(sheet.SetContent("Z1", "Cherry"))
+	assert.NoError(sheet.SetContent("Z2", "=VLOOKUP(Z1,A1:C5,2,FALSE)"))
+
+	precedents, err := sheet.Precedents("Z2", false)
+	assert.NoError(err)
+	assert.Len(precedents, 16)
+
+	var updated []string
+	sheet.Subscribe(func(ev CellEvent) {
+		updated = append(updated, ev.Addr.String())
+	})
+	// Cells anywhere in the table recalculate the lookup, even the blank row at the end.
+	assert.NoError(sheet.SetContent("B3", "3.5"))
+	assert.ElementsMatch([]string{"B3", "Z2"}, updated)
+	v, err := sheet.ValueAt("Z2")
+	assert.NoError(err)
+	assert.Equal(3.5, v)
+
+	assert.NoError(sheet.SetContent("A5", "Fig"))
+	assert.NoError(sheet.SetContent("B5", "4"))
+	assert.NoError(sheet.SetContent("Z1", "Fig"))
+	v, err = sheet.ValueAt("Z2")
+	assert.NoError(err)
+	assert.Equal(float64(4), v)
+
+	assert.NoError(sheet.SetContent("A5", "Grape"))
+	_, err = sheet.ValueAt("Z2")
+	assert.Error(err)
+
+	// A lookup inside of its own table is a cycle.
+	assert.NoError(sheet.SetContent("C1", "=VLOOKUP(Z1,A1:C5,2,FALSE)"))
+	content, err := sheet.ContentAt("C1")
+	assert.NoError(err)
+	assert.True(strings.HasSuffix(content, "Cyclical equations detected."), content)
+}
+
+func TestWriteDOTRanges(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("A1", "1")
+	sheet.SetContent("A2", "2")
+	sheet.SetContent("B1", "=INDEX(A1:A50,2)+A1")
+	sheet.SetContent("B2", "=MATCH(2,A1:A50)")
+
+	r, err := CellRange("A1:B2")
+	if !assert.NoError(err) {
+		return
+	}
+	b := &strings.Builder{}
+	assert.NoError(sheet.WriteDOT(b, r))
+	expected := `digraph sheet {
+	node [shape=box];
+	"A1" [label="A1\n1"];
+	"B1" [label="B1\n=INDEX(A1:A50,2)+A1"];
+	"A2" [label="A2\n2"];
+	"B2" [label="B2\n=MATCH(2,A1:A50)"];
+	"A1" -> "B1";
+	"A1:A50" [label="A1:A50", shape=folder];
+	"A1" -> "A1:A50";
+	"A2" -> "A1:A50";
+	"A1:A50" -> "B1";
+	"A1:A50" -> "B2";
+}
+`
+	assert.Equal(expected, b.String())
+}
+
+func TestDynamicReferences(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			setup(sheet)
+			sheet.SetContent("A1", "2")
+			sheet.SetContent("B1", "10")
+			sheet.SetContent("B2", "20")
+			sheet.SetContent("B3", "=B2+1")
+			sheet.SetContent("C1", "=INDIRECT(\"B\"&A1)")
+			sheet.SetContent("C2", "=OFFSET(B1,A1,0)*2")
+			sheet.SetContent("C3", "=INDEX(OFFSET(B1,0,0,A1+1,1),A1+1)")
+
+			assertValue := func(addr string, want float64) {
+				t.Helper()
+				v, err := sheet.ValueAt(addr)
+				assert.NoError(err, addr)
+				assert.Equal(want, v, addr)
+			}
+			assertValue("C1", 20)
+			assertValue("C2", 42)
+			assertValue("C3", 21)
+
+			// The targets are dependencies, so changing them updates the references.
+			sheet.SetContent("B2", "30")
+			assertValue("C1", 30)
+			assertValue("C2", 62)
+			assertValue("C3", 31)
+
+			// Changing what is referenced moves the dependencies.
+			sheet.SetContent("A1", "1")
+			assertValue("C1", 10)
+			assertValue("C2", 60)
+			assertValue("C3", 30)
+			precedents, err := sheet.Precedents("C1", false)
+			assert.NoError(err)
+			assert.Equal([]CellAddress{{"A", 1}, {"B", 1}}, precedents)
+
+			sheet.SetContent("B1", "11")
+			sheet.SetContent("B3", "5")
+			assertValue("C1", 11)
+			assertValue("C2", 60)
+			assertValue("C3", 30)
+
+			// Referencing a formula's own cell is a cycle.
+			sheet.SetContent("A1", "3")
+			sheet.SetContent("C4", "=INDIRECT(\"C\"&(A1+1))")
+			_, err = sheet.ValueAt("C4")
+			assert.Error(err)
+			sheet.SetContent("A1", "2")
+			assertValue("C4", 5)
+		})
+	}
+}
+
+func TestDynamicReferenceEvents(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("A1", "1")
+	sheet.SetContent("B1", "10")
+	sheet.SetContent("B2", "20")
+	sheet.SetContent("C1", "=INDIRECT(\"B\"&A1)")
+
+	var updated []string
+	sheet.Subscribe(func(ev CellEvent) {
+		updated = append(updated, ev.Addr.String())
+	})
+	sheet.SetContent("A1", "2")
+	assert.ElementsMatch([]string{"A1", "C1"}, updated)
+
+	// B1 is no longer referenced, so changing it doesn't recalculate C1.
+	updated = nil
+	sheet.SetContent("B1", "11")
+	assert.Equal([]string{"B1"}, updated)
+
+	updated = nil
+	sheet.SetContent("B2", "21")
+	assert.ElementsMatch([]string{"B2", "C1"}, updated)
+}
+
+func TestDynamicReferenceErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"indirect/address": `=INDIRECT("nowhere")`,
+		"indirect/args":    `=INDIRECT("A1","A2")`,
+		"offset/sheet":     `=OFFSET(A1,0-1,0)`,
+		"offset/columns":   `=OFFSET(A1,0,1000)`,
+		"offset/size":      `=OFFSET(A1,0,0,0,1)`,
+		"offset/ref":       `=OFFSET(1,0,0)`,
+		"offset/range":     `=SQRT(OFFSET(A1:B2,0,0))`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			sheet := NewSheet()
+			sheet.SetContent("A1", "1")
+			assert.NoError(t, sheet.SetContent("Z1", content))
+			_, err := sheet.ValueAt("Z1")
+			assert.Error(t, err)
+		})
+	}
+}
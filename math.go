@@ -0,0 +1,261 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// mathResult returns res, the result of the function name for args, or an error wrapping ErrNum if
+// it isn't a finite number, as for the square root of a negative number or the logarithm of 0.
+func mathResult(name string, res float64, args ...float64) (value, error) {
+	if !math.IsNaN(res) && !math.IsInf(res, 0) {
+		return numberValue(res), nil
+	}
+	strs := make([]string, len(args))
+	for i := range args {
+		strs[i] = fmt.Sprint(args[i])
+	}
+	return value{}, fmt.Errorf("%w %s(%s) has no finite result", ErrNum, name, strings.Join(strs, ", "))
+}
+
+// mathFunction returns the function name of one number, computed by f.
+func mathFunction(name string, f func(x float64) float64) function {
+	return function{
+		eval: func(s *Sheet, args []*Expression) (value, error) {
+			vals, err := evalArgs(s, name, args, 1, 1)
+			if err != nil {
+				return value{}, err
+			}
+			return mathResult(name, f(vals[0]), vals[0])
+		},
+	}
+}
+
+// exactArgs evaluates the arguments of the function name as exact numbers. Outside of decimal mode,
+// numbers are taken as they would be written out in decimal, as by ROUND, so that FLOOR(0.3, 0.1)
+// is 0.3 even though 0.3 / 0.1 is slightly less than 3 in float64.
+func exactArgs(s *Sheet, name string, args []*Expression, min, max int) ([]*big.Rat, error) {
+	vals, err := evalValueArgs(s, name, args, min, max)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]*big.Rat, len(vals))
+	for i, v := range vals {
+		if s.decimal {
+			if rs[i], err = v.toRat(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		f, err := v.toNumber()
+		if err != nil {
+			return nil, err
+		}
+		if rs[i], err = decimalRat(name, f); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+// exactResult returns r as a value, keeping it exact in a sheet in decimal mode.
+func (s *Sheet) exactResult(r *big.Rat) value {
+	if s.decimal {
+		return ratValue(r)
+	}
+	f, _ := r.Float64()
+	return numberValue(f)
+}
+
+// exactFunction returns the function name of at least min and at most max numbers, computed
+// exactly by f. See exactArgs.
+func exactFunction(name string, min, max int, f func(args []*big.Rat) (*big.Rat, error)) function {
+	return function{
+		eval: func(s *Sheet, args []*Expression) (value, error) {
+			rs, err := exactArgs(s, name, args, min, max)
+			if err != nil {
+				return value{}, err
+			}
+			r, err := f(rs)
+			if err != nil {
+				return value{}, err
+			}
+			return s.exactResult(r), nil
+		},
+	}
+}
+
+// floorRat returns the largest integer less than or equal to r.
+func floorRat(r *big.Rat) *big.Rat {
+	// The denominator is always positive, so Euclidean division rounds down.
+	return new(big.Rat).SetInt(new(big.Int).Div(r.Num(), r.Denom()))
+}
+
+// ceilRat returns the smallest integer greater than or equal to r.
+func ceilRat(r *big.Rat) *big.Rat {
+	c := floorRat(new(big.Rat).Neg(r))
+	return c.Neg(c)
+}
+
+// ABS(number) returns the absolute value of number.
+func fnAbs(args []*big.Rat) (*big.Rat, error) {
+	return new(big.Rat).Abs(args[0]), nil
+}
+
+// SIGN(number) returns 1 if number is positive, -1 if it is negative and 0 if it is 0.
+func fnSign(args []*big.Rat) (*big.Rat, error) {
+	return big.NewRat(int64(args[0].Sign()), 1), nil
+}
+
+// INT(number) rounds number down to an integer.
+func fnInt(args []*big.Rat) (*big.Rat, error) {
+	return floorRat(args[0]), nil
+}
+
+// multipleFunction returns FLOOR or CEILING: name(number, [significance]) rounds number down or up
+// to a multiple of significance, which defaults to 1. A negative number with a negative
+// significance is rounded toward zero by FLOOR and away from zero by CEILING.
+func multipleFunction(name string, round func(r *big.Rat) *big.Rat) function {
+	return exactFunction(name, 1, 2, func(args []*big.Rat) (*big.Rat, error) {
+		x, sig := args[0], big.NewRat(1, 1)
+		if len(args) > 1 {
+			sig = args[1]
+		}
+		switch {
+		case sig.Sign() == 0:
+			return new(big.Rat), nil
+		case x.Sign() > 0 && sig.Sign() < 0:
+			return nil, fmt.Errorf("%w %s: significance is negative for a positive number", ErrNum, name)
+		}
+		q := round(new(big.Rat).Quo(x, sig))
+		return q.Mul(q, sig), nil
+	})
+}
+
+// MOD(number, divisor) returns the remainder of number divided by divisor, which has the sign of
+// divisor.
+func fnMod(args []*big.Rat) (*big.Rat, error) {
+	n, d := args[0], args[1]
+	if d.Sign() == 0 {
+		return nil, fmt.Errorf("Division by zero")
+	}
+	q := floorRat(new(big.Rat).Quo(n, d))
+	return q.Sub(n, q.Mul(q, d)), nil
+}
+
+// POWER(number, power) returns number raised to power.
+func fnPower(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalArgs(s, "POWER", args, 2, 2)
+	if err != nil {
+		return value{}, err
+	}
+	return mathResult("POWER", math.Pow(vals[0], vals[1]), vals...)
+}
+
+// LOG(number, [base]) returns the logarithm of number to base, which defaults to 10.
+func fnLog(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalArgs(s, "LOG", args, 1, 2)
+	if err != nil {
+		return value{}, err
+	}
+	if len(vals) == 1 || vals[1] == 10 {
+		return mathResult("LOG", math.Log10(vals[0]), vals...)
+	}
+	return mathResult("LOG", math.Log(vals[0])/math.Log(vals[1]), vals...)
+}
+
+// ATAN2(x, y) returns the angle in radians between the x axis and the line from the origin to
+// (x, y), between -π and π.
+func fnAtan2(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalArgs(s, "ATAN2", args, 2, 2)
+	if err != nil {
+		return value{}, err
+	}
+	if vals[0] == 0 && vals[1] == 0 {
+		return value{}, fmt.Errorf("ATAN2: x and y are both 0")
+	}
+	return mathResult("ATAN2", math.Atan2(vals[1], vals[0]), vals...)
+}
+
+// PI() returns π.
+func fnPi(s *Sheet, args []*Expression) (value, error) {
+	if err := checkArgCount("PI", args, 0, 0); err != nil {
+		return value{}, err
+	}
+	return numberValue(math.Pi), nil
+}
+
+// maxExactInt is the largest integer up to which every integer is a float64.
+const maxExactInt = 1 << 53
+
+// divisorFunction returns GCD or LCM, which take any number of non-negative integers and combine
+// them with f. Fractions are truncated.
+func divisorFunction(name string, f func(a, b *big.Int) *big.Int) function {
+	return function{
+		eval: func(s *Sheet, args []*Expression) (value, error) {
+			nums, err := floatArgs(s, name, args, 1, -1)
+			if err != nil {
+				return value{}, err
+			}
+			var res *big.Int
+			for _, n := range nums {
+				if n < 0 || n > maxExactInt {
+					return value{}, fmt.Errorf("%w %s: %v is not a non-negative integer", ErrNum, name, n)
+				}
+				i := big.NewInt(int64(n))
+				if res == nil {
+					res = i
+				} else {
+					res = f(res, i)
+				}
+			}
+			if res == nil {
+				return numberValue(0), nil
+			}
+			r, _ := new(big.Float).SetInt(res).Float64()
+			return mathResult(name, r, nums...)
+		},
+	}
+}
+
+func gcd(a, b *big.Int) *big.Int {
+	return new(big.Int).GCD(nil, nil, a, b)
+}
+
+func lcm(a, b *big.Int) *big.Int {
+	if a.Sign() == 0 || b.Sign() == 0 {
+		return new(big.Int)
+	}
+	l := new(big.Int).Mul(a, b)
+	return l.Quo(l, gcd(a, b))
+}
+
+// PRODUCT(values...) returns the product of its arguments, or 0 if there are no numbers among
+// them.
+func fnProduct(s *Sheet, args []*Expression) (value, error) {
+	nums, err := numberArgs(s, "PRODUCT", args, 1, -1)
+	if err != nil {
+		return value{}, err
+	}
+	if len(nums) == 0 {
+		return numberValue(0), nil
+	}
+	if !s.decimal {
+		p := 1.0
+		for _, v := range nums {
+			p *= v.num
+		}
+		return finiteNumber("PRODUCT", p)
+	}
+	p := big.NewRat(1, 1)
+	for _, v := range nums {
+		r, err := v.toRat()
+		if err != nil {
+			return value{}, err
+		}
+		p.Mul(p, r)
+	}
+	return ratValue(p), nil
+}
@@ -0,0 +1,149 @@
+package sheet
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMathFunctions(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		expect  string
+	}{
+		"abs":             {content: "=ABS(0-2.5)", expect: "2.5"},
+		"sign":            {content: "=SIGN(A1)", expect: "-1"},
+		"sign/zero":       {content: "=SIGN(Z1)", expect: "0"},
+		"int":             {content: "=INT(0-2.5)", expect: "-3"},
+		"floor":           {content: "=FLOOR(2.5)", expect: "2"},
+		"floor/decimal":   {content: "=FLOOR(0.3,0.1)", expect: "0.3"},
+		"floor/negative":  {content: "=FLOOR(0-2.5,0-2)", expect: "-2"},
+		"floor/mixed":     {content: "=FLOOR(0-2.5,2)", expect: "-4"},
+		"floor/zero":      {content: "=FLOOR(2.5,0)", expect: "0"},
+		"ceiling":         {content: "=CEILING(2.1,0.5)", expect: "2.5"},
+		"ceiling/neg":     {content: "=CEILING(0-2.5,0-2)", expect: "-4"},
+		"mod":             {content: "=MOD(10,3)", expect: "1"},
+		"mod/negative":    {content: "=MOD(0-10,3)", expect: "2"},
+		"mod/divisor":     {content: "=MOD(10,0-3)", expect: "-2"},
+		"mod/decimal":     {content: "=MOD(0.3,0.1)", expect: "0"},
+		"power":           {content: "=POWER(2,10)", expect: "1024"},
+		"power/root":      {content: "=POWER(27,1/3)", expect: "3"},
+		"sqrt":            {content: "=SQRT(16)", expect: "4"},
+		"exp":             {content: "=EXP(0)", expect: "1"},
+		"ln":              {content: "=LN(EXP(2))", expect: "2"},
+		"log":             {content: "=LOG(1000)", expect: "3"},
+		"log/base":        {content: "=LOG(8,2)", expect: "3"},
+		"log10":           {content: "=LOG10(0.01)", expect: "-2"},
+		"sin":             {content: "=SIN(PI()/2)", expect: "1"},
+		"cos":             {content: "=COS(0)", expect: "1"},
+		"tan":             {content: "=ROUND(TAN(PI()/4),10)", expect: "1"},
+		"asin":            {content: "=ASIN(1)*2", expect: "3.14159265358979"},
+		"acos":            {content: "=ACOS(1)", expect: "0"},
+		"atan":            {content: "=ATAN(1)*4", expect: "3.14159265358979"},
+		"atan2":           {content: "=ATAN2(0-1,0)", expect: "3.14159265358979"},
+		"pi":              {content: "=PI()", expect: "3.14159265358979"},
+		"gcd":             {content: "=GCD(24,36,60)", expect: "12"},
+		"gcd/truncate":    {content: "=GCD(24.9,36)", expect: "12"},
+		"lcm":             {content: "=LCM(4,6,10)", expect: "60"},
+		"lcm/zero":        {content: "=LCM(4,0)", expect: "0"},
+		"product":         {content: "=PRODUCT(A1:A3,2)", expect: "-24"},
+		"product/empty":   {content: "=PRODUCT(Z1:Z3)", expect: "0"},
+		"product/skipped": {content: `=PRODUCT(A1:A4)`, expect: "-12"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			sheet.SetContent("A1", "-2")
+			sheet.SetContent("A2", "3")
+			sheet.SetContent("A3", "2")
+			sheet.SetContent("A4", "text")
+			assert.NoError(sheet.SetContent("Z9", tt.content))
+			got, err := sheet.ContentAt("Z9")
+			assert.NoError(err)
+			assert.Equal(tt.expect, got)
+		})
+	}
+}
+
+func TestMathErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		num     bool
+	}{
+		"sqrt/negative":  {content: "=SQRT(0-1)", num: true},
+		"ln/zero":        {content: "=LN(0)", num: true},
+		"log/zero":       {content: "=LOG(0)", num: true},
+		"log/base":       {content: "=LOG(10,1)", num: true},
+		"log10/negative": {content: "=LOG10(0-5)", num: true},
+		"exp/overflow":   {content: "=EXP(1000)", num: true},
+		"power/zero":     {content: "=POWER(0,0-1)", num: true},
+		"power/root":     {content: "=POWER(0-8,0.5)", num: true},
+		"asin/range":     {content: "=ASIN(2)", num: true},
+		"acos/range":     {content: "=ACOS(0-2)", num: true},
+		"floor/sign":     {content: "=FLOOR(2.5,0-1)", num: true},
+		"gcd/negative":   {content: "=GCD(0-4,6)", num: true},
+		"product/large":  {content: "=PRODUCT(1E200,1E200)", num: true},
+		"arith/overflow": {content: "=EXP(700)*EXP(700)", num: true},
+		"mod/zero":       {content: "=MOD(1,0)"},
+		"atan2/origin":   {content: "=ATAN2(0,0)"},
+		"pi/args":        {content: "=PI(1)"},
+		"abs/text":       {content: `=ABS("x")`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			sheet.SetContent("A1", "1E200")
+			assert.NoError(sheet.SetContent("Z9", tt.content))
+			_, err := sheet.ValueAt("Z9")
+			assert.Error(err)
+			assert.Equal(tt.num, errors.Is(err, ErrNum), "%v", err)
+			got, _ := sheet.ContentAt("Z9")
+			assert.NotContains(got, "NaN")
+			assert.NotContains(got, "Inf")
+		})
+	}
+}
+
+func TestNonFiniteNumbers(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("A1", "NaN")
+	sheet.SetContent("A2", "Inf")
+	sheet.SetContent("B1", "=A1+1")
+	sheet.SetContent("B2", "=SQRT(A2)")
+	sheet.SetContent("B3", `=VALUE("Inf")`)
+	for _, addr := range []string{"B1", "B2", "B3"} {
+		_, err := sheet.ValueAt(addr)
+		assert.Error(err, addr)
+	}
+	// NaN and Inf are text when typed into a cell, so they can't be used as numbers.
+	for _, addr := range []string{"A1", "A2"} {
+		_, err := sheet.ValueAt(addr)
+		assert.Error(err, addr)
+	}
+	assert.Equal([][]string{{"NaN"}, {"Inf"}}, contentBlock(sheet, "A1:A2"))
+	_, err := sheet.ValueAt("B1")
+	assert.False(errors.Is(err, ErrNum), "%v", err)
+}
+
+func TestMathDecimal(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetDecimal(true)
+	sheet.SetContent("A1", "0.1")
+	sheet.SetContent("A2", "0.2")
+	sheet.SetContent("B1", "=PRODUCT(A1:A2)")
+	sheet.SetContent("B2", "=MOD(1,A1)+ABS(0-A2)")
+	sheet.SetContent("B3", "=CEILING(0.25,A1)")
+	for addr, want := range map[string]*big.Rat{
+		"B1": big.NewRat(2, 100),
+		"B2": big.NewRat(2, 10),
+		"B3": big.NewRat(3, 10),
+	} {
+		r, err := sheet.ExactValueAt(addr)
+		assert.NoError(err)
+		assert.Equal(0, want.Cmp(r), "%s: %v", addr, r)
+	}
+}
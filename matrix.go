@@ -0,0 +1,207 @@
+package sheet
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// The matrix functions compute exactly, taking numbers as exactArgs does, so that the inverse of a
+// matrix of integers is shown as integers when it has them, and a singular matrix is always found
+// to be singular.
+
+// matrixArg converts v, argument of the function name, to a matrix of exact numbers. Every value in
+// it must be a number.
+func matrixArg(s *Sheet, name string, v value) ([][]*big.Rat, error) {
+	rows := v.rows()
+	m := make([][]*big.Rat, len(rows))
+	for i := range rows {
+		m[i] = make([]*big.Rat, len(rows[i]))
+		for j, x := range rows[i] {
+			if x.kind != valNumber {
+				return nil, fmt.Errorf("%s: value at row %d, column %d is not a number", name, i+1, j+1)
+			}
+			var r *big.Rat
+			var err error
+			if s.decimal {
+				r, err = x.toRat()
+			} else {
+				r, err = decimalRat(name, x.num)
+			}
+			if err != nil {
+				return nil, err
+			}
+			// The matrix is modified in place, so it must not share the values of cells.
+			m[i][j] = new(big.Rat).Set(r)
+		}
+	}
+	return m, nil
+}
+
+// squareArg is like matrixArg, but the matrix must also be square.
+func squareArg(s *Sheet, name string, v value) ([][]*big.Rat, error) {
+	m, err := matrixArg(s, name, v)
+	if err != nil {
+		return nil, err
+	}
+	if len(m) != len(m[0]) {
+		return nil, fmt.Errorf("%s: %dx%d matrix is not square", name, len(m), len(m[0]))
+	}
+	return m, nil
+}
+
+// ratResult is like exactResult, but returns an error wrapping ErrNum if r is too large to be a
+// number outside of decimal mode.
+func (s *Sheet) ratResult(name string, r *big.Rat) (value, error) {
+	if s.decimal {
+		return ratValue(r), nil
+	}
+	f, _ := r.Float64()
+	return finiteNumber(name, f)
+}
+
+// matrixResult returns m as an array value.
+func (s *Sheet) matrixResult(name string, m [][]*big.Rat) (value, error) {
+	rows := make([][]value, len(m))
+	for i := range m {
+		rows[i] = make([]value, len(m[i]))
+		for j, r := range m[i] {
+			v, err := s.ratResult(name, r)
+			if err != nil {
+				return value{}, err
+			}
+			rows[i][j] = v
+		}
+	}
+	return arrayValue(rows), nil
+}
+
+// MMULT(a, b) returns the matrix product of a and b. a must have as many columns as b has rows.
+func fnMmult(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "MMULT", args, 2, 2)
+	if err != nil {
+		return value{}, err
+	}
+	a, err := matrixArg(s, "MMULT", vals[0])
+	if err != nil {
+		return value{}, err
+	}
+	b, err := matrixArg(s, "MMULT", vals[1])
+	if err != nil {
+		return value{}, err
+	}
+	if len(a[0]) != len(b) {
+		return value{}, fmt.Errorf("MMULT: %dx%d matrix can't be multiplied by %dx%d matrix", len(a), len(a[0]), len(b), len(b[0]))
+	}
+	if len(a)*len(b[0]) > maxRangeCells {
+		return value{}, fmt.Errorf("MMULT: %d by %d values is too many", len(a), len(b[0]))
+	}
+	p := make([][]*big.Rat, len(a))
+	var t big.Rat
+	for i := range p {
+		p[i] = make([]*big.Rat, len(b[0]))
+		for j := range p[i] {
+			p[i][j] = new(big.Rat)
+			for k := range b {
+				p[i][j].Add(p[i][j], t.Mul(a[i][k], b[k][j]))
+			}
+		}
+	}
+	return s.matrixResult("MMULT", p)
+}
+
+// TRANSPOSE(array) returns array with its rows as columns.
+func fnTranspose(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "TRANSPOSE", args, 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	return arrayValue(transpose(vals[0].rows())), nil
+}
+
+// eliminate reduces the square matrix m to upper triangular form by Gaussian elimination, applying
+// the same row operations to aug, which may be nil. It returns the determinant of m.
+func eliminate(m, aug [][]*big.Rat) *big.Rat {
+	det := big.NewRat(1, 1)
+	var f, t big.Rat
+	for k := range m {
+		p := k
+		for p < len(m) && m[p][k].Sign() == 0 {
+			p++
+		}
+		if p == len(m) {
+			return new(big.Rat)
+		}
+		if p != k {
+			m[p], m[k] = m[k], m[p]
+			if aug != nil {
+				aug[p], aug[k] = aug[k], aug[p]
+			}
+			det.Neg(det)
+		}
+		det.Mul(det, m[k][k])
+		for i := k + 1; i < len(m); i++ {
+			if m[i][k].Sign() == 0 {
+				continue
+			}
+			f.Quo(m[i][k], m[k][k])
+			for j := k; j < len(m); j++ {
+				m[i][j].Sub(m[i][j], t.Mul(&f, m[k][j]))
+			}
+			for j := range aug {
+				aug[i][j].Sub(aug[i][j], t.Mul(&f, aug[k][j]))
+			}
+		}
+	}
+	return det
+}
+
+// MDETERM(matrix) returns the determinant of matrix, which must be square.
+func fnMdeterm(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "MDETERM", args, 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	m, err := squareArg(s, "MDETERM", vals[0])
+	if err != nil {
+		return value{}, err
+	}
+	return s.ratResult("MDETERM", eliminate(m, nil))
+}
+
+// MINVERSE(matrix) returns the inverse of matrix, which must be square. A singular matrix has no
+// inverse, which is an error wrapping ErrNum.
+func fnMinverse(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "MINVERSE", args, 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	m, err := squareArg(s, "MINVERSE", vals[0])
+	if err != nil {
+		return value{}, err
+	}
+	inv := make([][]*big.Rat, len(m))
+	for i := range inv {
+		inv[i] = make([]*big.Rat, len(m))
+		for j := range inv[i] {
+			inv[i][j] = new(big.Rat)
+		}
+		inv[i][i].SetInt64(1)
+	}
+	if eliminate(m, inv).Sign() == 0 {
+		return value{}, fmt.Errorf("%w MINVERSE: matrix is singular", ErrNum)
+	}
+	// Back substitution, from the last row up. The values of m to the right of the diagonal in row
+	// k have been eliminated by the rows below it by the time row k is reached.
+	var t big.Rat
+	for k := len(m) - 1; k >= 0; k-- {
+		for j := range inv[k] {
+			inv[k][j].Quo(inv[k][j], m[k][k])
+		}
+		for i := 0; i < k; i++ {
+			for j := range inv[i] {
+				inv[i][j].Sub(inv[i][j], t.Mul(m[i][k], inv[k][j]))
+			}
+		}
+	}
+	return s.matrixResult("MINVERSE", inv)
+}
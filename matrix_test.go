@@ -0,0 +1,99 @@
+package sheet
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatrixFunctions(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		expect  [][]string
+	}{
+		"mmult":            {content: "=MMULT(A1:B2,D1:E2)", expect: [][]string{{"2", "1"}, {"4", "3"}}},
+		"mmult/vector":     {content: "=MMULT(A1:B2,D1:D2)", expect: [][]string{{"2"}, {"4"}}},
+		"mmult/row":        {content: "=MMULT(A1:C1,C4:C6)", expect: [][]string{{"11"}}},
+		"transpose":        {content: "=TRANSPOSE(A1:C2)", expect: [][]string{{"1", "3"}, {"2", "4"}, {"3", "5"}}},
+		"transpose/text":   {content: "=TRANSPOSE(G1:G2)", expect: [][]string{{"x", "y"}}},
+		"minverse":         {content: "=MINVERSE(A1:B2)", expect: [][]string{{"-2", "1"}, {"1.5", "-0.5"}}},
+		"minverse/3x3":     {content: "=MINVERSE(A4:C6)", expect: [][]string{{"-24", "18", "5"}, {"20", "-15", "-4"}, {"-5", "4", "1"}}},
+		"minverse/check":   {content: "=MMULT(A4:C6,MINVERSE(A4:C6))", expect: [][]string{{"1", "0", "0"}, {"0", "1", "0"}, {"0", "0", "1"}}},
+		"mdeterm":          {content: "=MDETERM(A1:B2)", expect: [][]string{{"-2"}}},
+		"mdeterm/3x3":      {content: "=MDETERM(A4:C6)", expect: [][]string{{"1"}}},
+		"mdeterm/single":   {content: "=MDETERM(C2)", expect: [][]string{{"5"}}},
+		"mdeterm/singular": {content: "=MDETERM(D3:E4)", expect: [][]string{{"0"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			for i, row := range [][]string{
+				{"1", "2", "3", "0", "1", "", "x"},
+				{"3", "4", "5", "1", "0", "", "y"},
+				{"", "", "", "1", "2"},
+				{"1", "2", "3", "2", "4"},
+				{"0", "1", "4"},
+				{"5", "6", "0"},
+			} {
+				for j, v := range row {
+					sheet.SetContent(CellAddress{colName(j + 1), uint32(i + 1)}.String(), v)
+				}
+			}
+			assert.NoError(sheet.SetContent("J1", tt.content))
+			end := CellAddress{colName(10 + len(tt.expect[0]) - 1), uint32(len(tt.expect))}
+			assert.Equal(tt.expect, contentBlock(sheet, "J1:"+end.String()))
+		})
+	}
+}
+
+func TestMatrixErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		num     bool
+	}{
+		"mmult/size":        {content: "=MMULT(A1:B2,A1:B1)"},
+		"mmult/text":        {content: "=MMULT(A1:C1,C1:C3)"},
+		"mmult/blank":       {content: "=MMULT(A1:B2,A2:B3)"},
+		"minverse/square":   {content: "=MINVERSE(A1:B3)"},
+		"minverse/singular": {content: "=MINVERSE(A1:B1*A1:A2)", num: true},
+		"mdeterm/square":    {content: "=MDETERM(A1:C1)"},
+		"mdeterm/overflow":  {content: "=MDETERM(D1:E2)", num: true},
+		"minverse/args":     {content: "=MINVERSE(A1:B2,A1:B2)"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			sheet.SetContent("A1", "1")
+			sheet.SetContent("B1", "2")
+			sheet.SetContent("A2", "3")
+			sheet.SetContent("B2", "4")
+			sheet.SetContent("C1", "x")
+			sheet.SetContent("D1", "1E200")
+			sheet.SetContent("E1", "0")
+			sheet.SetContent("D2", "0")
+			sheet.SetContent("E2", "1E200")
+			assert.NoError(sheet.SetContent("Z9", tt.content))
+			_, err := sheet.ValueAt("Z9")
+			assert.Error(err)
+			assert.Equal(tt.num, errors.Is(err, ErrNum), "%v", err)
+		})
+	}
+}
+
+func TestMatrixDecimal(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetDecimal(true)
+	sheet.SetContent("A1", "0.1")
+	sheet.SetContent("B1", "0.2")
+	sheet.SetContent("A2", "0.3")
+	sheet.SetContent("B2", "0.4")
+	sheet.SetContent("D1", "=MINVERSE(A1:B2)")
+	sheet.SetContent("D3", "=MMULT(A1:B2,D1:E2)")
+	assert.Equal([][]string{{"-20", "10"}, {"15", "-5"}, {"1", "0"}, {"0", "1"}}, contentBlock(sheet, "D1:E4"))
+	r, err := sheet.ExactValueAt("A1")
+	assert.NoError(err)
+	assert.Equal(0, big.NewRat(1, 10).Cmp(r), "%v", r)
+}
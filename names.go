@@ -0,0 +1,210 @@
+package sheet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefineName defines name for the whole of s, so that formulas can use it in place of definition.
+// definition may be a reference to a cell or range, a constant, or any other formula, with or
+// without the leading '=':
+//
+//	s.DefineName("TaxRate", "B2")
+//	s.DefineName("Prices", "D2:D200")
+//	s.DefineName("Currency", `"USD"`)
+//
+// Names are not case sensitive, and may hold letters, digits and underscores, but must start with
+// a letter and must not be cell addresses. A name used in a formula acts like its definition: a
+// name for a range can be summed, and cells using it depend on the cells in the range. A name may
+// use other names, including ones defined later, but not itself, unless it is a LAMBDA (see
+// DefineLambda). Names bound by LET or LAMBDA hide the names of s.
+//
+// Defining name again replaces its definition, and defining it as "" removes it. Either way, the
+// cells using it are recalculated. A name is renamed by defining the new name and removing the old
+// one.
+func (s *Sheet) DefineName(name, definition string) error {
+	name = strings.ToUpper(name)
+	if e, err := ParseExpression("=" + name); err != nil || e.op != NAME || e.val != name {
+		return fmt.Errorf("%s is not a valid name", name)
+	}
+	if _, ok := functions[name]; ok {
+		return fmt.Errorf("%s is a built-in function", name)
+	}
+	old, defined := s.names[name]
+	if definition == "" {
+		if !defined {
+			return nil
+		}
+		delete(s.names, name)
+		s.recalculateUses(name)
+		return nil
+	}
+	e, err := parseDefinition(definition)
+	if err != nil {
+		return err
+	}
+	if _, err := e.upstreamRefs(); err != nil {
+		return err
+	}
+	s.names[name] = e
+	if err := s.checkNames(); err != nil {
+		if defined {
+			s.names[name] = old
+		} else {
+			delete(s.names, name)
+		}
+		return err
+	}
+	s.recalculateUses(name)
+	return nil
+}
+
+// parseDefinition parses the definition of a name, which may leave out the leading '='.
+func parseDefinition(definition string) (*Expression, error) {
+	if !strings.HasPrefix(definition, "=") {
+		definition = "=" + definition
+	}
+	return ParseExpression(definition)
+}
+
+// definition returns the definition of the name e uses, if e is a name defined in s, or a call to
+// one. It returns nil otherwise.
+func (s *Sheet) definition(e *Expression) *Expression {
+	if e.op != FUNC && e.op != NAME {
+		return nil
+	}
+	if _, ok := functions[e.val]; ok && e.op == FUNC {
+		return nil
+	}
+	return s.names[e.val]
+}
+
+// checkNames returns an error if the definition of a name uses itself. Only a LAMBDA may call
+// itself, since the depth of its calls is limited.
+func (s *Sheet) checkNames() error {
+	for n, e := range s.names {
+		if e.op == FUNC && e.val == "LAMBDA" {
+			continue
+		}
+		seen := make(map[string]bool)
+		queue := e.usedNames()
+		for len(queue) > 0 {
+			u := queue[0]
+			queue = queue[1:]
+			if u == n {
+				return fmt.Errorf("The definition of %s uses itself", n)
+			}
+			if d, ok := s.names[u]; ok && !seen[u] {
+				seen[u] = true
+				queue = append(queue, d.usedNames()...)
+			}
+		}
+	}
+	return nil
+}
+
+// usedNames returns the names e uses, or calls as functions, other than built-in functions and
+// names bound inside of e by LET or LAMBDA. They are sorted and upper case.
+func (e *Expression) usedNames() []string {
+	found := make(map[string]bool)
+	e.collectNames(nil, found)
+	names := make([]string, 0, len(found))
+	for n := range found {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// collectNames adds the names e uses to found, except for the names in bound. See usedNames.
+func (e *Expression) collectNames(bound map[string]bool, found map[string]bool) {
+	_, builtin := functions[e.val]
+	if (e.op == NAME || e.op == FUNC && !builtin) && !bound[e.val] {
+		found[e.val] = true
+	}
+	if e.left != nil {
+		e.left.collectNames(bound, found)
+	}
+	if e.right != nil {
+		e.right.collectNames(bound, found)
+	}
+	// Names are hidden as they are by bind.
+	inner := bound
+	for i, a := range e.args {
+		if e.bindsName(i) {
+			if e.val == "LAMBDA" {
+				inner = with(inner, a.val)
+			}
+			continue
+		}
+		a.collectNames(inner, found)
+		if i > 0 && e.val == "LET" && e.bindsName(i-1) {
+			inner = with(inner, e.args[i-1].val)
+		}
+	}
+}
+
+// with returns a copy of names with name added.
+func with(names map[string]bool, name string) map[string]bool {
+	c := make(map[string]bool, len(names)+1)
+	for n := range names {
+		c[n] = true
+	}
+	c[name] = true
+	return c
+}
+
+// nameRefs returns references to the cells referenced by the definitions of the names e uses, and
+// by the definitions of the names they use in turn. seen holds the names already visited.
+func (e *Expression) nameRefs(s *Sheet, seen map[string]bool) []cellRef {
+	var refs []cellRef
+	for _, n := range e.usedNames() {
+		d, ok := s.names[n]
+		if !ok || seen[n] {
+			continue
+		}
+		seen[n] = true
+		// Definitions are checked by DefineName.
+		rs, _ := d.upstreamRefs()
+		refs = append(refs, rs...)
+		refs = append(refs, d.funcRefs(s)...)
+		refs = append(refs, d.nameRefs(s, seen)...)
+	}
+	return refs
+}
+
+// recalculateUses recalculates the cells that use name, directly or through other names.
+func (s *Sheet) recalculateUses(name string) {
+	// users holds the names whose definitions use name.
+	users := map[string]bool{name: true}
+	for changed := true; changed; {
+		changed = false
+		for n, e := range s.names {
+			if !users[n] && usesAny(e, users) {
+				users[n] = true
+				changed = true
+			}
+		}
+	}
+	var cells []*Cell
+	for _, rows := range s.matrix {
+		for _, c := range rows {
+			if c.exp != nil && usesAny(c.exp, users) {
+				cells = append(cells, c)
+			}
+		}
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i].addr.less(cells[j].addr) })
+	s.recalculateCells(cells)
+}
+
+// usesAny returns true if e uses any of names.
+func usesAny(e *Expression, names map[string]bool) bool {
+	for _, n := range e.usedNames() {
+		if names[n] {
+			return true
+		}
+	}
+	return false
+}
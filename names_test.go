@@ -0,0 +1,85 @@
+package sheet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefineName(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			setup(sheet)
+			sheet.SetContent("B2", "0.5")
+			sheet.SetContent("D1", "Price")
+			sheet.SetContent("D2", "10")
+			sheet.SetContent("D3", "20")
+			sheet.SetContent("D4", "30")
+			// Names may be used before they are defined.
+			sheet.SetContent("A1", "=SUM(Prices)")
+			assert.NoError(sheet.DefineName("TaxRate", "B2"))
+			assert.NoError(sheet.DefineName("Prices", "D1:D4"))
+			assert.NoError(sheet.DefineName("Total", "=SUM(Prices)*(1+TaxRate)"))
+			assert.NoError(sheet.DefineName("Greeting", `"hi"`))
+			sheet.SetContent("A2", "=Total")
+			sheet.SetContent("A3", `=greeting&"!"`)
+			sheet.SetContent("A4", "=SUM(OFFSET(Prices,2,0,2,1))")
+			sheet.SetContent("A5", "=LET(taxrate,2,TaxRate*10)")
+			assert.Equal([][]string{{"60"}, {"90"}, {"hi!"}, {"50"}, {"20"}}, contentBlock(sheet, "A1:A5"))
+
+			// Cells using a name depend on the cells it refers to.
+			sheet.SetContent("B2", "1")
+			sheet.SetContent("D2", "40")
+			assert.Equal([][]string{{"90"}, {"180"}, {"hi!"}, {"50"}, {"20"}}, contentBlock(sheet, "A1:A5"))
+			deps, err := sheet.Dependents("B2", false)
+			assert.NoError(err)
+			assert.Equal([]CellAddress{{"A", 2}}, deps)
+
+			// Redefining a name recalculates the cells using it.
+			assert.NoError(sheet.DefineName("PRICES", "D2:D3"))
+			assert.Equal([][]string{{"60"}, {"120"}, {"hi!"}, {"30"}}, contentBlock(sheet, "A1:A4"))
+			deps, err = sheet.Dependents("D1", false)
+			assert.NoError(err)
+			assert.Empty(deps)
+
+			// Renaming is defining the new name and removing the old one.
+			assert.NoError(sheet.DefineName("Cost", "D2:D3"))
+			assert.NoError(sheet.DefineName("Prices", ""))
+			_, err = sheet.ValueAt("A1")
+			assert.Error(err)
+			sheet.SetContent("A1", "=SUM(Cost)")
+			got, _ := sheet.ContentAt("A1")
+			assert.Equal("60", got)
+		})
+	}
+}
+
+func TestDefineNameErrors(t *testing.T) {
+	for name, tt := range map[string]struct{ name, definition string }{
+		"name/address": {"A1", "1"},
+		"name/builtin": {"SUM", "1"},
+		"name/digit":   {"1X", "1"},
+		"name/bool":    {"TRUE", "1"},
+		"self":         {"X", "=X+1"},
+		"cycle":        {"Y", "=Z"},
+		"parse":        {"X", `"abc`},
+		"range":        {"X", "A1:ZZ99999"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			assert.NoError(sheet.DefineName("Z", "=Y*2"))
+			assert.Error(sheet.DefineName(tt.name, tt.definition))
+			// The name is left undefined.
+			sheet.SetContent("A9", "=Y")
+			_, err := sheet.ValueAt("A9")
+			assert.Error(err)
+		})
+	}
+}
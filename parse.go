@@ -3,6 +3,7 @@ package sheet
 import (
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -10,14 +11,33 @@ import (
 type op int
 
 const (
-	NONE op = iota
-	ADD  op = iota
-	SUB  op = iota
-	MUL  op = iota
-	DIV  op = iota
-	LP   op = iota
-	RP   op = iota
-	ID   op = iota
+	NONE  op = iota
+	ADD   op = iota
+	SUB   op = iota
+	MUL   op = iota
+	DIV   op = iota
+	LP    op = iota
+	RP    op = iota
+	ID    op = iota
+	NUM   op = iota
+	COMMA op = iota
+	FUNC  op = iota
+	STR   op = iota
+	NEG   op = iota
+	CAT   op = iota
+	COLON op = iota
+	RANGE op = iota
+	BOOL  op = iota
+	NAME  op = iota
+	CALL  op = iota
+	CONST op = iota
+	SHEET op = iota
+	EQ    op = iota
+	NE    op = iota
+	LT    op = iota
+	LE    op = iota
+	GT    op = iota
+	GE    op = iota
 )
 
 type token struct {
@@ -31,41 +51,292 @@ type Expression struct {
 	left  *Expression
 	right *Expression
 	val   string
+	// args holds the arguments of a function call (op FUNC), whose name is in val, or of a call to
+	// the LAMBDA that left evaluates to (op CALL).
+	args []*Expression
+	// bound is the value of a CONST, which replaces a name bound by LET or LAMBDA when the
+	// expression it is bound in is evaluated. See bind.
+	bound value
+	// depth is the number of LAMBDA calls the expression was made by. See (*lambda).call.
+	depth int
+	// sheet is the name of the sheet a reference (op ID or RANGE) is to, as in Sheet2!A1, or "" for
+	// the sheet of the formula. See Workbook. lastSheet is the name of the last sheet of a 3D
+	// reference to the same cells of several sheets, as in Jan:Dec!B5, or "" for a reference to
+	// only one.
+	sheet     string
+	lastSheet string
+	// compiled evaluates the expression in place of evalValue's walk of the tree, and cell is the
+	// cell a reference (op ID) is to. They are set by compile, in the formulas of cells only.
+	compiled func(s *Sheet) (value, error)
+	cell     *Cell
 }
 
-// upstreamAddrs returns a list of CellAddresses that are used in this equation.
-func (e *Expression) upstreamAddrs() ([]CellAddress, error) {
+// maxRangeCells is the largest number of cells a range in an equation may cover.
+const maxRangeCells = 1 << 20
+
+// cellRef is a reference to the cell at addr from a formula, on the sheet named sheet in the
+// formula's Workbook, or on the formula's own sheet if sheet is "".
+type cellRef struct {
+	sheet string
+	addr  CellAddress
+}
+
+// sameRefs returns true if a and b hold the same references, in the same order.
+func sameRefs(a, b []cellRef) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// upstreamRefs returns a list of references to the cells that are used in this equation.
+func (e *Expression) upstreamRefs() ([]cellRef, error) {
 	if e.op == ID {
 		addr, err := CellAddr(e.val)
 		if err != nil {
 			return nil, err
 		}
-		return []CellAddress{addr}, nil
+		if e.lastSheet != "" {
+			// The sheets of a 3D reference depend on the order of the sheets. See spanRefs.
+			return nil, nil
+		}
+		return []cellRef{{e.sheet, addr}}, nil
+	}
+	if e.op == RANGE {
+		rng, err := CellRange(e.val)
+		if err != nil {
+			return nil, err
+		}
+		if rng.height()*rng.width() > maxRangeCells {
+			return nil, fmt.Errorf("Range %s is too large", e.val)
+		}
+		if e.lastSheet != "" {
+			return nil, nil
+		}
+		return rangeRefs(e.sheet, rng), nil
 	}
 
-	addrs := make([]CellAddress, 0)
+	refs := make([]cellRef, 0)
 	if e.left != nil {
-		leftas, err := e.left.upstreamAddrs()
+		leftrs, err := e.left.upstreamRefs()
 		if err != nil {
 			return nil, err
 		}
-		addrs = append(addrs, leftas...)
+		refs = append(refs, leftrs...)
 	}
 
 	if e.right != nil {
-		rightas, err := e.right.upstreamAddrs()
+		rightrs, err := e.right.upstreamRefs()
 		if err != nil {
 			return nil, err
 		}
-		addrs = append(addrs, rightas...)
+		refs = append(refs, rightrs...)
+	}
+
+	for _, arg := range e.args {
+		argrs, err := arg.upstreamRefs()
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, argrs...)
+	}
+	return refs, nil
+}
+
+// rangeRefs returns references to the cells of rng on the sheet named sheet.
+func rangeRefs(sheet string, rng Range) []cellRef {
+	addrs := rng.addrs()
+	refs := make([]cellRef, len(addrs))
+	for i, a := range addrs {
+		refs[i] = cellRef{sheet, a}
+	}
+	return refs
+}
+
+// dynamicRefs returns references to the cells referenced by calls in e to functions like
+// INDIRECT, whose references depend on the values of other cells, and by the names defined in s
+// that e uses. See (*Sheet).DefineName. 3D references are included as well, since the sheets they
+// span change as sheets are added and removed.
+func (e *Expression) dynamicRefs(s *Sheet) []cellRef {
+	refs := append(e.funcRefs(s), e.spanRefs(s)...)
+	return append(refs, e.nameRefs(s, make(map[string]bool))...)
+}
+
+// spanRefs returns references to the cells referenced by the 3D references in e, on each of the
+// sheets they span.
+func (e *Expression) spanRefs(s *Sheet) []cellRef {
+	var refs []cellRef
+	e.walk(func(e *Expression) {
+		if e.lastSheet == "" {
+			return
+		}
+		rng, err := e.refRange()
+		if err != nil {
+			return
+		}
+		sheets, err := s.sheetSpan(e.sheet, e.lastSheet)
+		if err != nil || len(sheets)*rng.height()*rng.width() > maxRangeCells {
+			return
+		}
+		for _, t := range sheets {
+			refs = append(refs, rangeRefs(t.name, rng)...)
+		}
+	})
+	return refs
+}
+
+// refRange returns the range referred to by e, which must be a cell address or a range.
+func (e *Expression) refRange() (Range, error) {
+	if e.op == ID {
+		a, err := CellAddr(e.val)
+		if err != nil {
+			return Range{}, err
+		}
+		return NewRange(a, a), nil
 	}
-	return addrs, nil
+	return CellRange(e.val)
+}
+
+// funcRefs returns references to the cells referenced by calls in e to functions like INDIRECT.
+func (e *Expression) funcRefs(s *Sheet) []cellRef {
+	var refs []cellRef
+	e.walk(func(e *Expression) {
+		if e.op != FUNC {
+			return
+		}
+		f, ok := functions[e.val]
+		if !ok || f.ref == nil {
+			return
+		}
+		t, rng, err := f.ref(s, e.args)
+		if err != nil || rng.height()*rng.width() > maxRangeCells {
+			return
+		}
+		sheet := ""
+		if t != s {
+			sheet = t.name
+		}
+		refs = append(refs, rangeRefs(sheet, rng)...)
+	})
+	return refs
+}
+
+// walk calls f for e and each of its subexpressions.
+func (e *Expression) walk(f func(*Expression)) {
+	f(e)
+	if e.left != nil {
+		e.left.walk(f)
+	}
+	if e.right != nil {
+		e.right.walk(f)
+	}
+	for _, arg := range e.args {
+		arg.walk(f)
+	}
+}
+
+// volatile returns true if this equation calls a volatile function, whose value can change even
+// when none of the cells it references do.
+func (e *Expression) volatile() bool {
+	if e.op == FUNC {
+		if f, ok := functions[e.val]; ok && f.volatile {
+			return true
+		}
+	}
+	if e.left != nil && e.left.volatile() {
+		return true
+	}
+	if e.right != nil && e.right.volatile() {
+		return true
+	}
+	for _, arg := range e.args {
+		if arg.volatile() {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseError is the error returned by ParseExpression for a formula that can't be parsed.
+type ParseError struct {
+	// Pos is the offset in runes into the formula at which the error was found, counting the
+	// leading '='.
+	Pos int
+	// Msg describes the error, as in "expected ')'".
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
 }
 
 // parser parses an Equation. See: ParseExpression
 type parser struct {
 	r    *strings.Reader
 	look token
+	// pos is the offset of the next rune of r in the formula. tokPos is the offset of the last token
+	// returned by nextTok, and lookPos that of look.
+	pos     int
+	tokPos  int
+	lookPos int
+}
+
+// errorf returns a *ParseError at the offset pos.
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	return &ParseError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// unexpected returns the error for tok, the last token read, or for the end of the formula if err
+// is io.EOF, when a token described by expected was expected instead. Other errors are returned as
+// they are.
+func (p *parser) unexpected(tok token, err error, expected string) error {
+	if err == io.EOF {
+		return p.errorf(p.pos, "expected %s", expected)
+	} else if err != nil {
+		return err
+	}
+	return p.errorf(p.tokPos, "expected %s, but found %s", expected, tok)
+}
+
+// String returns the text of t as it appears in a formula, quoted for use in an error message.
+func (t token) String() string {
+	switch t.op {
+	case ID, NUM:
+		return fmt.Sprintf("'%s'", t.val)
+	case STR:
+		return fmt.Sprintf("'\"%s\"'", strings.ReplaceAll(t.val, `"`, `""`))
+	case SHEET:
+		return fmt.Sprintf("'%s!'", quoteSheet(t.val))
+	case EQ, NE, LT, LE, GT, GE:
+		return fmt.Sprintf("'%s'", opText[t.op])
+	}
+	for r, op := range runeOps {
+		if op == t.op {
+			return fmt.Sprintf("'%c'", r)
+		}
+	}
+	return fmt.Sprintf("token %d", t.op)
+}
+
+// readRune reads the next rune of the formula.
+func (p *parser) readRune() (rune, error) {
+	rn, _, err := p.r.ReadRune()
+	if err == nil {
+		p.pos++
+	}
+	return rn, err
+}
+
+// unreadRune puts back the last rune read by readRune.
+func (p *parser) unreadRune() {
+	if p.r.UnreadRune() == nil {
+		p.pos--
+	}
 }
 
 // unreadToken returns a token onto the front of the parser's token stream.
@@ -75,9 +346,23 @@ func (p *parser) unreadToken(tok token) error {
 		return fmt.Errorf("Cannot unread more than one token.")
 	}
 	p.look = tok
+	p.lookPos = p.tokPos
 	return nil
 }
 
+// runeOps holds the tokens made of a single rune.
+var runeOps = map[rune]op{
+	'-': SUB,
+	'+': ADD,
+	'*': MUL,
+	'/': DIV,
+	'(': LP,
+	')': RP,
+	',': COMMA,
+	'&': CAT,
+	':': COLON,
+}
+
 // nextTok returns the next token from the token stream, or an error if there is an invalid token.
 // err is io.EOF when the end of the stream is reached.
 func (p *parser) nextTok() (tok token, err error) {
@@ -85,64 +370,228 @@ func (p *parser) nextTok() (tok token, err error) {
 		tok = p.look
 		p.look.op = NONE
 		p.look.val = ""
+		p.tokPos = p.lookPos
 		return
 	}
-	rn, _, err := p.r.ReadRune()
+	rn, err := p.readRune()
+	for err == nil && unicode.IsSpace(rn) {
+		rn, err = p.readRune()
+	}
+	p.tokPos = p.pos - 1
 	if err != nil {
+		p.tokPos = p.pos
 		return token{}, err
 	}
 
+	if op, ok := runeOps[rn]; ok {
+		return token{op: op}, nil
+	}
 	switch rn {
-	case rune('-'):
-		return token{op: SUB}, nil
-	case rune('+'):
-		return token{op: ADD}, nil
-	case rune('*'):
-		return token{op: MUL}, nil
-	case rune('/'):
-		return token{op: DIV}, nil
-	case rune('('):
-		return token{op: LP}, nil
-	case rune(')'):
-		return token{op: RP}, nil
+	case rune('"'):
+		return p.readString()
+	case rune('\''):
+		return p.readSheet()
+	case '=':
+		return token{op: EQ}, nil
+	case '<':
+		return p.readComparison(LT, map[rune]op{'=': LE, '>': NE}), nil
+	case '>':
+		return p.readComparison(GT, map[rune]op{'=': GE}), nil
 	}
 
-	if !(unicode.IsLetter(rn) || unicode.IsDigit(rn)) {
-		ret := string([]rune{rn})
-		return token{}, fmt.Errorf("Unexpected rune %s", ret)
+	if unicode.IsDigit(rn) || rn == '.' {
+		return p.readNumber(rn)
+	}
+
+	if !unicode.IsLetter(rn) {
+		return token{}, p.errorf(p.tokPos, "unexpected '%c'", rn)
 	}
 
 	var rs []rune
-	for err == nil && (unicode.IsLetter(rn) || unicode.IsDigit(rn)) {
+	for err == nil && (unicode.IsLetter(rn) || unicode.IsDigit(rn) || rn == '_') {
 		rs = append(rs, rn)
-		rn, _, err = p.r.ReadRune()
+		rn, err = p.readRune()
+	}
+	if err == nil && rn == '!' {
+		return token{op: SHEET, val: string(rs)}, nil
 	}
 	if err != io.EOF {
-		p.r.UnreadRune()
+		p.unreadRune()
 	}
 
 	return token{op: ID, val: string(rs)}, nil
 }
 
+// readComparison reads the rest of a comparison operator that begins with '<' or '>'. It is op on
+// its own, or longer[rn] if it is followed by rn.
+func (p *parser) readComparison(op op, longer map[rune]op) token {
+	rn, err := p.readRune()
+	if err != nil {
+		return token{op: op}
+	}
+	if o, ok := longer[rn]; ok {
+		return token{op: o}
+	}
+	p.unreadRune()
+	return token{op: op}
+}
+
+// readSheet reads the rest of a quoted sheet name, after the opening quote, along with the '!'
+// after it, as in 'Q3 Data'!. A quote inside of the name is written as two quotes.
+func (p *parser) readSheet() (token, error) {
+	var rs []rune
+	for {
+		rn, err := p.readRune()
+		if err == io.EOF {
+			return token{}, p.errorf(p.tokPos, "unterminated sheet name")
+		} else if err != nil {
+			return token{}, err
+		}
+		if rn == '\'' {
+			rn, err = p.readRune()
+			if err == nil && rn == '\'' {
+				rs = append(rs, rn)
+				continue
+			}
+			if err != nil || rn != '!' {
+				if err == nil {
+					p.unreadRune()
+				}
+				return token{}, p.errorf(p.pos, "expected '!' after sheet name '%s'", string(rs))
+			}
+			return token{op: SHEET, val: string(rs)}, nil
+		}
+		rs = append(rs, rn)
+	}
+}
+
+// readString reads the rest of a string literal, after the opening quote. A quote inside of the
+// string is written as two quotes, as in "say ""hi""".
+func (p *parser) readString() (token, error) {
+	var rs []rune
+	for {
+		rn, err := p.readRune()
+		if err == io.EOF {
+			return token{}, p.errorf(p.tokPos, "unterminated string")
+		} else if err != nil {
+			return token{}, err
+		}
+		if rn == '"' {
+			rn, err = p.readRune()
+			if err != nil || rn != '"' {
+				if err == nil {
+					p.unreadRune()
+				}
+				return token{op: STR, val: string(rs)}, nil
+			}
+		}
+		rs = append(rs, rn)
+	}
+}
+
+// readNumber reads the rest of a number literal beginning with rn, such as 12, 0.5, .5 or 1e-3.
+func (p *parser) readNumber(rn rune) (token, error) {
+	var (
+		rs  []rune
+		err error
+	)
+	seenDot, seenExp := false, false
+	for err == nil {
+		if rn == '.' && !seenDot && !seenExp {
+			seenDot = true
+		} else if (rn == 'e' || rn == 'E') && !seenExp && len(rs) > 0 {
+			seenExp = true
+			rs = append(rs, rn)
+			rn, err = p.readRune()
+			if err == nil && (rn == '+' || rn == '-') {
+				rs = append(rs, rn)
+				rn, err = p.readRune()
+			}
+			continue
+		} else if !unicode.IsDigit(rn) {
+			break
+		}
+		rs = append(rs, rn)
+		rn, err = p.readRune()
+	}
+	if err == nil {
+		p.unreadRune()
+	} else if err != io.EOF {
+		return token{}, err
+	}
+	if _, err := strconv.ParseFloat(string(rs), 64); err != nil {
+		return token{}, p.errorf(p.tokPos, "invalid number '%s'", string(rs))
+	}
+	return token{op: NUM, val: string(rs)}, nil
+}
+
 // expectTok is used to consume an expected token, t, from the stream. if the next token is not ==
 // t or there are no more tokens, expectTok returns an error.
 func (p *parser) expectTok(t token) error {
+	tok, err := p.nextTok()
+	if err != nil || tok != t {
+		return p.unexpected(tok, err, t.String())
+	}
+	return nil
+}
+
+// ARGS = EXP COMMA ARGS | EXP | END
+func (p *parser) parseARGS() ([]*Expression, error) {
 	tok, err := p.nextTok()
 	if err != nil {
-		return err
+		return nil, p.unexpected(tok, err, "')'")
 	}
-	if tok != t {
-		return fmt.Errorf("Expected %#v, but have %#v", t, tok)
+	if tok.op == RP {
+		return nil, nil
+	}
+	err = p.unreadToken(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []*Expression
+	for {
+		exp, err := p.parseEXP()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, exp)
+		tok, err := p.nextTok()
+		if err == nil && tok.op == COMMA {
+			continue
+		}
+		if err == nil && tok.op == RP {
+			return args, nil
+		}
+		return nil, p.unexpected(tok, err, "',' or ')'")
 	}
-	return nil
 }
 
-// SUBEXP = LP EXP RP | ID
-func (p *parser) parseSUBEXP() (*Expression, error) {
+// CALLS = LP ARGS RP CALLS | END
+func (p *parser) parseCALLS(callee *Expression) (*Expression, error) {
 	tok, err := p.nextTok()
+	if err == io.EOF {
+		return callee, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if tok.op != LP {
+		return callee, p.unreadToken(tok)
+	}
+	args, err := p.parseARGS()
 	if err != nil {
 		return nil, err
 	}
+	return p.parseCALLS(&Expression{op: CALL, left: callee, args: args})
+}
+
+// SUBEXP = LP EXP RP CALLS | SUB SUBEXP | NUM | STR | BOOL | ID LP ARGS RP CALLS | ID COLON ID | ID
+func (p *parser) parseSUBEXP() (*Expression, error) {
+	tok, err := p.nextTok()
+	if err != nil {
+		return nil, p.unexpected(tok, err, "a value")
+	}
+	start := p.tokPos
 
 	switch tok.op {
 	case LP:
@@ -154,11 +603,114 @@ func (p *parser) parseSUBEXP() (*Expression, error) {
 		if err != nil {
 			return nil, err
 		}
-		return exp, nil
+		return p.parseCALLS(exp)
+	case SUB:
+		exp, err := p.parseSUBEXP()
+		if err != nil {
+			return nil, err
+		}
+		return &Expression{op: NEG, left: exp}, nil
+	case NUM:
+		return &Expression{op: NUM, val: tok.val}, nil
+	case STR:
+		return &Expression{op: STR, val: tok.val}, nil
 	case ID:
+		next, err := p.nextTok()
+		if err == nil && next.op == LP {
+			args, err := p.parseARGS()
+			if err != nil {
+				return nil, err
+			}
+			return p.parseCALLS(&Expression{op: FUNC, val: strings.ToUpper(tok.val), args: args})
+		} else if err == nil && next.op == COLON {
+			end, err := p.nextTok()
+			if err == nil && end.op == SHEET {
+				// A 3D reference, as in Jan:Dec!B5.
+				p.tokPos = start
+				return p.parseSheetRef(token{op: SHEET, val: tok.val + ":" + end.val})
+			}
+			return p.rangeOf(tok, start, end, err)
+		} else if err == nil {
+			err = p.unreadToken(next)
+			if err != nil {
+				return nil, err
+			}
+		} else if err != io.EOF {
+			return nil, err
+		}
+		if b := strings.ToUpper(tok.val); b == "TRUE" || b == "FALSE" {
+			return &Expression{op: BOOL, val: b}, nil
+		}
+		if _, err := CellAddr(tok.val); err != nil {
+			// Anything that isn't a cell address is a name, such as one bound by LET.
+			return &Expression{op: NAME, val: strings.ToUpper(tok.val)}, nil
+		}
 		return &Expression{op: ID, val: tok.val}, nil
+	case SHEET:
+		return p.parseSheetRef(tok)
 	}
-	return nil, fmt.Errorf("Expected a SUBEXPR, but got token %#v", tok)
+	return nil, p.unexpected(tok, nil, "a value")
+}
+
+// parseSheetRef parses the reference after tok, the name of a sheet, or the names of the first and
+// last sheets of a 3D reference separated by a colon.
+func (p *parser) parseSheetRef(tok token) (*Expression, error) {
+	names := strings.Split(tok.val, ":")
+	if len(names) > 2 || names[0] == "" || names[len(names)-1] == "" {
+		return nil, p.errorf(p.tokPos, "invalid sheet name '%s'", tok.val)
+	}
+	start, err := p.nextTok()
+	if err != nil || start.op != ID {
+		return nil, p.unexpected(start, err, "a cell address")
+	}
+	ref, err := p.parseREF(start)
+	if err != nil {
+		return nil, err
+	}
+	ref.sheet = names[0]
+	if len(names) == 2 {
+		ref.lastSheet = names[1]
+	}
+	return ref, nil
+}
+
+// REF = ID COLON ID | ID
+func (p *parser) parseREF(start token) (*Expression, error) {
+	startPos := p.tokPos
+	next, err := p.nextTok()
+	if err == nil && next.op == COLON {
+		end, err := p.nextTok()
+		return p.rangeOf(start, startPos, end, err)
+	} else if err == nil {
+		if err := p.unreadToken(next); err != nil {
+			return nil, err
+		}
+	} else if err != io.EOF {
+		return nil, err
+	}
+	if _, err := CellAddr(start.val); err != nil {
+		return nil, p.errorf(startPos, "invalid cell address '%s'", start.val)
+	}
+	return &Expression{op: ID, val: start.val}, nil
+}
+
+// rangeOf returns the range from start to end, the tokens before and after the colon, with start
+// at the offset startPos. err is the error reading end.
+func (p *parser) rangeOf(start token, startPos int, end token, err error) (*Expression, error) {
+	if err != nil || end.op != ID {
+		return nil, p.unexpected(end, err, "a cell address")
+	}
+	if _, err := CellAddr(start.val); err != nil {
+		return nil, p.errorf(startPos, "invalid cell address '%s'", start.val)
+	}
+	if _, err := CellAddr(end.val); err != nil {
+		return nil, p.errorf(p.tokPos, "invalid cell address '%s'", end.val)
+	}
+	rng := start.val + ":" + end.val
+	if _, err := CellRange(rng); err != nil {
+		return nil, p.errorf(startPos, "invalid range '%s'", rng)
+	}
+	return &Expression{op: RANGE, val: rng}, nil
 }
 
 // MDEXP = MUL SUBEXP MDEXP | DIV SUBEXP MDEXP | END
@@ -167,6 +719,8 @@ func (p *parser) parseMDEXP(left *Expression) (*Expression, error) {
 	if err == io.EOF {
 		// We are at the end of the epression.
 		return left, nil
+	} else if err != nil {
+		return nil, err
 	}
 	switch tok.op {
 	case MUL:
@@ -203,6 +757,8 @@ func (p *parser) parsePMSEXP(left *Expression) (*Expression, error) {
 	if err == io.EOF {
 		// We are at the end of the epression.
 		return left, nil
+	} else if err != nil {
+		return nil, err
 	}
 	switch tok.op {
 	case ADD:
@@ -224,8 +780,8 @@ func (p *parser) parsePMSEXP(left *Expression) (*Expression, error) {
 	return left, nil
 }
 
-//  EXP = MDSEXP PMSEXP
-func (p *parser) parseEXP() (*Expression, error) {
+// SUMEXP = MDSEXP PMSEXP
+func (p *parser) parseSUMEXP() (*Expression, error) {
 	exp, err := p.parseMDSEXP()
 	if err != nil {
 		return nil, err
@@ -233,42 +789,130 @@ func (p *parser) parseEXP() (*Expression, error) {
 	return p.parsePMSEXP(exp)
 }
 
-func expectStr(r *strings.Reader, s string) error {
-	bs := make([]byte, len(s))
-	_, err := r.Read(bs)
+// CATEXP = CAT SUMEXP CATEXP | END
+func (p *parser) parseCATEXP(left *Expression) (*Expression, error) {
+	tok, err := p.nextTok()
+	if err == io.EOF {
+		// We are at the end of the epression.
+		return left, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if tok.op == CAT {
+		ex, err := p.parseSUMEXP()
+		if err != nil {
+			return nil, err
+		}
+		exp := &Expression{op: CAT, left: left, right: ex}
+		return p.parseCATEXP(exp)
+	}
+	// not EOF and not CAT, so not part of this production.
+	// We want to unread the token to not lose it.
+	err = p.unreadToken(tok)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if s != string(bs) {
-		return fmt.Errorf("Expected %s, got %s\n", s, bs)
+	return left, nil
+}
+
+// CATSEXP = SUMEXP CATEXP
+func (p *parser) parseCATSEXP() (*Expression, error) {
+	exp, err := p.parseSUMEXP()
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return p.parseCATEXP(exp)
+}
+
+// CMPEXP = CMP CATSEXP CMPEXP | END
+func (p *parser) parseCMPEXP(left *Expression) (*Expression, error) {
+	tok, err := p.nextTok()
+	if err == io.EOF {
+		// We are at the end of the epression.
+		return left, nil
+	} else if err != nil {
+		return nil, err
+	}
+	switch tok.op {
+	case EQ, NE, LT, LE, GT, GE:
+		ex, err := p.parseCATSEXP()
+		if err != nil {
+			return nil, err
+		}
+		exp := &Expression{op: tok.op, left: left, right: ex}
+		return p.parseCMPEXP(exp)
+	}
+	// not EOF and not a comparison, so not part of this production.
+	// We want to unread the token to not lose it.
+	err = p.unreadToken(tok)
+	if err != nil {
+		return nil, err
+	}
+	return left, nil
+}
+
+// EXP = CATSEXP CMPEXP
+func (p *parser) parseEXP() (*Expression, error) {
+	exp, err := p.parseCATSEXP()
+	if err != nil {
+		return nil, err
+	}
+	return p.parseCMPEXP(exp)
 }
 
 // ParseExpression parses an EXP according to the below grammar. ParseExpression is implemented as
-// a hand-written recursive descent parse.
+// a hand-written recursive descent parse. An ID on its own that isn't a cell address is a name,
+// such as one bound by LET. Spaces between tokens are skipped. The whole of eqn must be an EXP
+// after the leading '='; errors are returned as a *ParseError giving the position of the problem.
 //
-//  EXP = MDSEXP PMSEXP
+//  EXP = CATSEXP CMPEXP
+//  CMPEXP = CMP CATSEXP CMPEXP | END
+//  CATSEXP = SUMEXP CATEXP
+//  CATEXP = CAT SUMEXP CATEXP | END
+//  SUMEXP = MDSEXP PMSEXP
 //  PMSEXP = ADD MDSEXP PMSEXP | SUB MDSEXP PMSEXP | END
 //  MDSEXP = SUBEXP MDEXP
 //  MDEXP = MUL SUBEXP MDEXP | DIV SUBEXP MDEXP | END
-//  SUBEXP = LP EXP RP | ID
+//  SUBEXP = LP EXP RP CALLS | SUB SUBEXP | NUM | STR | BOOL | ID LP ARGS RP CALLS | ID COLON ID | ID |
+//           SHEET REF | ID COLON SHEET REF
+//  REF = ID COLON ID | ID
+//  CALLS = LP ARGS RP CALLS | END
+//  ARGS = EXP COMMA ARGS | EXP | END
 
-//  ID = '[a-zA-Z0-9]+'
+//  ID = '[a-zA-Z][a-zA-Z0-9_]*'
+//  SHEET = ID '!' | "'([^']|'')*'!"
+//  (A quoted SHEET may hold two names separated by a colon, as in 'Jan 1:Dec 1'!.)
+//  NUM = '[0-9]*\.?[0-9]*([eE][+-]?[0-9]+)?'
+//  COMMA = ','
+//  STR = '"([^"]|"")*"'
+//  BOOL = 'TRUE' | 'FALSE'
 //  ADD = '+'
 //  SUB = '-'
 //  MUL = '*'
 //  DIV = '/'
+//  CAT = '&'
+//  CMP = '=' | '<>' | '<' | '<=' | '>' | '>='
+//  COLON = ':'
 //  LP = '('
 //  RP = ')'
 //  OP = [+-*/]
 func ParseExpression(eqn string) (*Expression, error) {
-	r := strings.NewReader(eqn)
-	expectStr(r, "=")
-	prs := parser{r: r}
+	if !strings.HasPrefix(eqn, "=") {
+		return nil, &ParseError{Pos: 0, Msg: "expected '='"}
+	}
+	r := strings.NewReader(eqn[1:])
+	prs := parser{r: r, pos: 1}
 	p := &prs
 	e, err := p.parseEXP()
-	if err != nil {
+	if err == io.EOF {
+		return nil, p.errorf(p.pos, "unexpected end of formula")
+	} else if err != nil {
+		return nil, err
+	}
+	// Anything left over isn't part of the EXP.
+	if tok, err := p.nextTok(); err == nil {
+		return nil, p.errorf(p.tokPos, "unexpected %s", tok)
+	} else if err != io.EOF {
 		return nil, err
 	}
 	return e, nil
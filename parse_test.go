@@ -1,6 +1,7 @@
 package sheet
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -104,6 +105,145 @@ func TestParseExpression(t *testing.T) {
 				},
 			},
 		},
+		"num": {
+			parse: "=A1+2.5*1e3",
+			expect: &Expression{op: ADD,
+				left: &Expression{op: ID, val: "A1"},
+				right: &Expression{op: MUL,
+					left:  &Expression{op: NUM, val: "2.5"},
+					right: &Expression{op: NUM, val: "1e3"},
+				},
+			},
+		},
+		"neg": {
+			parse: "=-A1*-2",
+			expect: &Expression{op: MUL,
+				left:  &Expression{op: NEG, left: &Expression{op: ID, val: "A1"}},
+				right: &Expression{op: NEG, left: &Expression{op: NUM, val: "2"}},
+			},
+		},
+		"str": {
+			parse: `=DATEDIF(A1,A2,"say ""Y""")`,
+			expect: &Expression{op: FUNC, val: "DATEDIF", args: []*Expression{
+				&Expression{op: ID, val: "A1"},
+				&Expression{op: ID, val: "A2"},
+				&Expression{op: STR, val: `say "Y"`},
+			}},
+		},
+		"cat": {
+			parse: `=A1&" "&B1+1`,
+			expect: &Expression{op: CAT,
+				left: &Expression{op: CAT,
+					left:  &Expression{op: ID, val: "A1"},
+					right: &Expression{op: STR, val: " "},
+				},
+				right: &Expression{op: ADD,
+					left:  &Expression{op: ID, val: "B1"},
+					right: &Expression{op: NUM, val: "1"},
+				},
+			},
+		},
+		"range": {
+			parse: "=VLOOKUP(A1,b2:C10,2,false)",
+			expect: &Expression{op: FUNC, val: "VLOOKUP", args: []*Expression{
+				&Expression{op: ID, val: "A1"},
+				&Expression{op: RANGE, val: "b2:C10"},
+				&Expression{op: NUM, val: "2"},
+				&Expression{op: BOOL, val: "FALSE"},
+			}},
+		},
+		"func/noargs": {
+			parse: "=now()+A1",
+			expect: &Expression{op: ADD,
+				left:  &Expression{op: FUNC, val: "NOW"},
+				right: &Expression{op: ID, val: "A1"},
+			},
+		},
+		"func/args": {
+			parse: "=RANDBETWEEN(A1,B2*3)",
+			expect: &Expression{op: FUNC, val: "RANDBETWEEN", args: []*Expression{
+				&Expression{op: ID, val: "A1"},
+				&Expression{op: MUL,
+					left:  &Expression{op: ID, val: "B2"},
+					right: &Expression{op: NUM, val: "3"},
+				},
+			}},
+		},
+		"name": {
+			parse: "=tax_Rate*A1",
+			expect: &Expression{op: MUL,
+				left:  &Expression{op: NAME, val: "TAX_RATE"},
+				right: &Expression{op: ID, val: "A1"},
+			},
+		},
+		"name/address": {
+			parse:  "=Q3data",
+			expect: &Expression{op: NAME, val: "Q3DATA"},
+		},
+		"call": {
+			parse: "=LAMBDA(x,x)(A1)(2)",
+			expect: &Expression{op: CALL,
+				left: &Expression{op: CALL,
+					left: &Expression{op: FUNC, val: "LAMBDA", args: []*Expression{
+						&Expression{op: NAME, val: "X"},
+						&Expression{op: NAME, val: "X"},
+					}},
+					args: []*Expression{&Expression{op: ID, val: "A1"}},
+				},
+				args: []*Expression{&Expression{op: NUM, val: "2"}},
+			},
+		},
+		"sheet": {
+			parse: "=Sheet2!A1+SUM('Q3 Data'!B2:B9)",
+			expect: &Expression{op: ADD,
+				left: &Expression{op: ID, val: "A1", sheet: "Sheet2"},
+				right: &Expression{op: FUNC, val: "SUM", args: []*Expression{
+					&Expression{op: RANGE, val: "B2:B9", sheet: "Q3 Data"},
+				}},
+			},
+		},
+		"spaces": {
+			parse: "= SUM ( A1 ,\t'Q3 Data'! B2 : B9 ) * -1 ",
+			expect: &Expression{op: MUL,
+				left: &Expression{op: FUNC, val: "SUM", args: []*Expression{
+					&Expression{op: ID, val: "A1"},
+					&Expression{op: RANGE, val: "B2:B9", sheet: "Q3 Data"},
+				}},
+				right: &Expression{op: NEG, left: &Expression{op: NUM, val: "1"}},
+			},
+		},
+		"sheet/3d": {
+			parse: "=SUM(Jan:Dec!B5)",
+			expect: &Expression{op: FUNC, val: "SUM", args: []*Expression{
+				&Expression{op: ID, val: "B5", sheet: "Jan", lastSheet: "Dec"},
+			}},
+		},
+		"compare": {
+			parse: `=A1+1>=B1&"x"`,
+			expect: &Expression{op: GE,
+				left:  &Expression{op: ADD, left: &Expression{op: ID, val: "A1"}, right: &Expression{op: NUM, val: "1"}},
+				right: &Expression{op: CAT, left: &Expression{op: ID, val: "B1"}, right: &Expression{op: STR, val: "x"}},
+			},
+		},
+		"compare/assoc": {
+			parse: "=1<2=TRUE",
+			expect: &Expression{op: EQ,
+				left:  &Expression{op: LT, left: &Expression{op: NUM, val: "1"}, right: &Expression{op: NUM, val: "2"}},
+				right: &Expression{op: BOOL, val: "TRUE"},
+			},
+		},
+		"compare/ops": {
+			parse:  "=A1<>B1",
+			expect: &Expression{op: NE, left: &Expression{op: ID, val: "A1"}, right: &Expression{op: ID, val: "B1"}},
+		},
+		"sheet/3d/quote": {
+			parse:  "='Jan 1:Dec 1'!B2:B9",
+			expect: &Expression{op: RANGE, val: "B2:B9", sheet: "Jan 1", lastSheet: "Dec 1"},
+		},
+		"sheet/quote": {
+			parse:  "='Bob''s'!C3",
+			expect: &Expression{op: ID, val: "C3", sheet: "Bob's"},
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			assert := assert.New(t)
@@ -113,6 +253,127 @@ func TestParseExpression(t *testing.T) {
 			}
 
 			assert.Equal(exp, tt.expect)
+			again, err := ParseExpression(exp.String())
+			assert.NoError(err)
+			assert.Equal(exp, again)
+		})
+	}
+}
+
+func TestExpressionString(t *testing.T) {
+	for name, tt := range map[string]struct{ parse, expect string }{
+		"ref":            {"=A1", "=A1"},
+		"parens/extra":   {"=((A1+B1))*2", "=(A1+B1)*2"},
+		"parens/left":    {"=(A1*B1)+(C1/D1)", "=A1*B1+C1/D1"},
+		"parens/right":   {"=A1-(B1-C1)", "=A1-(B1-C1)"},
+		"parens/assoc":   {"=(A1-B1)-C1", "=A1-B1-C1"},
+		"parens/div":     {"=A1/(B1*C1)", "=A1/(B1*C1)"},
+		"parens/cat":     {`=(A1&"x")&(1+2)`, `=A1&"x"&1+2`},
+		"parens/catsum":  {`=(A1&"x")*2`, `=(A1&"x")*2`},
+		"neg":            {"=-(A1+1)*-B1", "=-(A1+1)*-B1"},
+		"neg/double":     {"=--A1", "=--A1"},
+		"neg/sub":        {"=A1-(-1)", "=A1--1"},
+		"func":           {"=sum(a1:a3,1.50,.5e-1)", "=SUM(a1:a3,1.50,.5e-1)"},
+		"func/empty":     {"=NOW()", "=NOW()"},
+		"str":            {`="say ""hi"""&TRUE`, `="say ""hi"""&TRUE`},
+		"name":           {"=LET(x,2,x*(x+1))", "=LET(X,2,X*(X+1))"},
+		"call":           {"=(LAMBDA(x,x*2))(3)(4)", "=LAMBDA(X,X*2)(3)(4)"},
+		"call/name":      {"=(f)(1)", "=(F)(1)"},
+		"sheet":          {"=Data!A1+'Q3 Data'!B2:B9", "=Data!A1+'Q3 Data'!B2:B9"},
+		"sheet/quote":    {"='Bob''s'!A1*Ünïcode!A1", "='Bob''s'!A1*'Ünïcode'!A1"},
+		"sheet/3d":       {"=SUM(Jan:Dec!B5,'Jan 1:Dec'!B5)", "=SUM(Jan:Dec!B5,'Jan 1:Dec'!B5)"},
+		"sheet/3d/quote": {"='Jan:Dec'!B5", "=Jan:Dec!B5"},
+		"compare":        {"=A1 <= B1", "=A1<=B1"},
+		"compare/all":    {"=A1=1<>(2<3)>4>=5<6", "=A1=1<>(2<3)>4>=5<6"},
+		"compare/left":   {`=((A1&"x")>B1)=FALSE`, `=A1&"x">B1=FALSE`},
+		"compare/nested": {"=(A1<B1)*2+-(C1>=1)", "=(A1<B1)*2+-(C1>=1)"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			exp, err := ParseExpression(tt.parse)
+			if !assert.NoError(err) {
+				return
+			}
+			assert.Equal(tt.expect, exp.String())
+			again, err := ParseExpression(exp.String())
+			assert.NoError(err)
+			assert.Equal(exp, again)
+		})
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	for name, parse := range map[string]string{
+		"func/unclosed":  "=SUM(A1,B1",
+		"func/separator": "=SUM(A1 B1)",
+		"num/exponent":   "=1e+",
+		"str/unclosed":   `="abc`,
+		"range/end":      "=SUM(A1:)",
+		"range/address":  "=SUM(A1:B)",
+		"sheet/address":  "=Sheet2!X",
+		"sheet/func":     "=Sheet2!SUM(A1)",
+		"sheet/unclosed": "='Q3 Data!A1",
+		"sheet/bang":     "='Q3 Data'A1",
+		"sheet/3d/names": "='a:b:c'!A1",
+		"sheet/3d/empty": "=':b'!A1",
+		"sheet/3d/range": "=A1:B2!C3:D",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseExpression(parse)
+			assert.Error(t, err)
 		})
 	}
 }
+
+func TestParseErrorPositions(t *testing.T) {
+	for name, tt := range map[string]struct{ parse, expect string }{
+		"paren":            {"=(A1+B1", "expected ')' at position 7"},
+		"paren/found":      {"=(A1+B1,2)", "expected ')', but found ',' at position 7"},
+		"trailing":         {"=A1 B1", "unexpected 'B1' at position 4"},
+		"trailing/paren":   {"=SUM(A1))", "unexpected ')' at position 8"},
+		"trailing/str":     {`=1"x"`, `unexpected '"x"' at position 2`},
+		"end":              {"=1+", "expected a value at position 3"},
+		"value":            {"=1+)", "expected a value, but found ')' at position 3"},
+		"args":             {"=SUM(A1 B1)", "expected ',' or ')', but found 'B1' at position 8"},
+		"args/end":         {"=SUM(", "expected ')' at position 5"},
+		"rune":             {"=A1#", "unexpected '#' at position 3"},
+		"rune/offset":      {`="é"+`, "expected a value at position 5"},
+		"str":              {`=1&"abc`, "unterminated string at position 3"},
+		"num":              {"=1e+", "invalid number '1e+' at position 1"},
+		"range/end":        {"=A1:", "expected a cell address at position 4"},
+		"range/address":    {"=SUM(A1:B)", "invalid cell address 'B' at position 8"},
+		"range/start":      {"=ZZZ1:A1", "invalid cell address 'ZZZ1' at position 1"},
+		"sheet/bang":       {"='Q3 Data'A1", "expected '!' after sheet name 'Q3 Data' at position 10"},
+		"sheet/address":    {"=Sheet2!X", "invalid cell address 'X' at position 8"},
+		"sheet/func":       {"=Sheet2!SUM(A1)", "invalid cell address 'SUM' at position 8"},
+		"sheet/unexpected": {"=Sheet2!1", "expected a cell address, but found '1' at position 8"},
+		"equals":           {"A1+1", "expected '=' at position 0"},
+		"compare/end":      {"=A1<=", "expected a value at position 5"},
+		"compare/double":   {"=A1=<B1", "expected a value, but found '<' at position 4"},
+		"compare/arrow":    {"=A1=>B1", "expected a value, but found '>' at position 4"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			_, err := ParseExpression(tt.parse)
+			var perr *ParseError
+			if assert.True(errors.As(err, &perr), "%v", err) {
+				assert.Equal(tt.expect, perr.Error())
+			}
+		})
+	}
+}
+
+func TestParseErrorContent(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	var events []CellEvent
+	sheet.Subscribe(func(ev CellEvent) { events = append(events, ev) })
+	err := sheet.SetContent("A1", "=SUM(A2")
+	assert.EqualError(err, "expected ',' or ')' at position 7")
+	edit, _ := sheet.EditAt("A1")
+	assert.Equal("=SUM(A2", edit)
+	if assert.Len(events, 1) {
+		assert.Equal("A1: expected ',' or ')' at position 7", events[0].NewContent)
+	}
+	assert.NoError(sheet.SetContent("A1", "=SUM(A2)"))
+}
@@ -0,0 +1,127 @@
+package sheet
+
+import (
+	"strings"
+)
+
+// String returns the formula e was parsed from, in canonical form: with the leading '=', no
+// spaces, upper-case function names and names, and only the parentheses needed to keep the order
+// of its operations. ParseExpression of the result returns an expression equal to e. A value bound
+// by LET or LAMBDA, which is only found in e while it is evaluated, is written as the value.
+func (e *Expression) String() string {
+	var b strings.Builder
+	b.WriteByte('=')
+	e.write(&b)
+	return b.String()
+}
+
+// precedence returns how tightly the operation of e binds its operands. Operations that bind more
+// tightly are evaluated first.
+func (e *Expression) precedence() int {
+	switch e.op {
+	case EQ, NE, LT, LE, GT, GE:
+		return 0
+	case CAT:
+		return 1
+	case ADD, SUB:
+		return 2
+	case MUL, DIV:
+		return 3
+	case NEG:
+		return 4
+	}
+	return 5
+}
+
+// write writes the text of e, without the leading '=', to b.
+func (e *Expression) write(b *strings.Builder) {
+	switch e.op {
+	case ID, RANGE:
+		if e.lastSheet != "" {
+			b.WriteString(quoteSheets(e.sheet, e.lastSheet))
+			b.WriteByte('!')
+		} else if e.sheet != "" {
+			b.WriteString(quoteSheet(e.sheet))
+			b.WriteByte('!')
+		}
+		b.WriteString(e.val)
+	case NUM, BOOL, NAME:
+		b.WriteString(e.val)
+	case STR:
+		writeString(b, e.val)
+	case CONST:
+		writeValue(b, e.bound)
+	case FUNC:
+		b.WriteString(e.val)
+		writeArgs(b, e.args)
+	case CALL:
+		// Only a call or a parenthesized expression can be called.
+		e.left.writeOperand(b, e.left.op != FUNC && e.left.op != CALL)
+		writeArgs(b, e.args)
+	case NEG:
+		b.WriteByte('-')
+		e.left.writeOperand(b, e.left.precedence() < e.precedence())
+	case ADD, SUB, MUL, DIV, CAT, EQ, NE, LT, LE, GT, GE:
+		// Operations are left associative, so an operand on the right of the same precedence must
+		// be parenthesized to keep its place in the tree.
+		e.left.writeOperand(b, e.left.precedence() < e.precedence())
+		b.WriteString(opText[e.op])
+		e.right.writeOperand(b, e.right.precedence() <= e.precedence())
+	}
+}
+
+// opText holds the text of the binary operations.
+var opText = map[op]string{
+	ADD: "+", SUB: "-", MUL: "*", DIV: "/", CAT: "&",
+	EQ: "=", NE: "<>", LT: "<", LE: "<=", GT: ">", GE: ">=",
+}
+
+// writeOperand writes e to b, in parentheses if paren is true.
+func (e *Expression) writeOperand(b *strings.Builder, paren bool) {
+	if paren {
+		b.WriteByte('(')
+	}
+	e.write(b)
+	if paren {
+		b.WriteByte(')')
+	}
+}
+
+// writeArgs writes the parenthesized arguments of a call to b.
+func writeArgs(b *strings.Builder, args []*Expression) {
+	b.WriteByte('(')
+	for i, a := range args {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		a.write(b)
+	}
+	b.WriteByte(')')
+}
+
+// writeString writes s to b as a string literal.
+func writeString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	b.WriteString(strings.ReplaceAll(s, `"`, `""`))
+	b.WriteByte('"')
+}
+
+// writeValue writes v to b as the formula that evaluates to it.
+func writeValue(b *strings.Builder, v value) {
+	switch v.kind {
+	case valString:
+		writeString(b, v.str)
+	case valLambda:
+		b.WriteString("LAMBDA(")
+		for _, p := range v.fn.params {
+			b.WriteString(p)
+			b.WriteByte(',')
+		}
+		v.fn.body.write(b)
+		b.WriteByte(')')
+	case valBlank:
+		b.WriteString(`""`)
+	default:
+		b.WriteString(v.toString())
+	}
+}
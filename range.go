@@ -0,0 +1,93 @@
+package sheet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Range is a rectangular block of cells, from the upper left cell start to the bottom right cell
+// end, inclusive.
+type Range struct {
+	start CellAddress
+	end   CellAddress
+}
+
+// NewRange creates a Range with corners a and b. The corners may be given in any order.
+func NewRange(a, b CellAddress) Range {
+	r := Range{start: a, end: b}
+	if r.end.LessCol(r.start) {
+		r.start.col, r.end.col = r.end.col, r.start.col
+	}
+	if r.end.row < r.start.row {
+		r.start.row, r.end.row = r.end.row, r.start.row
+	}
+	return r
+}
+
+// CellRange creates a new Range by parsing a range string, rng. rng must be of the format
+// [address]:[address], as in A1:C10, where each address is understood by CellAddr. A single
+// address is a range containing one cell.
+func CellRange(rng string) (Range, error) {
+	parts := strings.Split(rng, ":")
+	if len(parts) > 2 {
+		return Range{}, fmt.Errorf("Invalid range '%s'", rng)
+	}
+	start, err := CellAddr(parts[0])
+	if err != nil {
+		return Range{}, err
+	}
+	end := start
+	if len(parts) == 2 {
+		end, err = CellAddr(parts[1])
+		if err != nil {
+			return Range{}, err
+		}
+	}
+	return NewRange(start, end), nil
+}
+
+// Start returns the upper left cell of the Range.
+func (r Range) Start() CellAddress {
+	return r.start
+}
+
+// End returns the bottom right cell of the Range.
+func (r Range) End() CellAddress {
+	return r.end
+}
+
+// Contains returns true if ca is inside r.
+func (r Range) Contains(ca CellAddress) bool {
+	return ca.row >= r.start.row && ca.row <= r.end.row && r.start.LEQCol(ca) && ca.LEQCol(r.end)
+}
+
+// String returns a human-readable representation of r. This value can also be parsed by CellRange.
+func (r Range) String() string {
+	return fmt.Sprintf("%s:%s", r.start, r.end)
+}
+
+// height returns the number of rows in r.
+func (r Range) height() int {
+	return int(r.end.row-r.start.row) + 1
+}
+
+// width returns the number of columns in r.
+func (r Range) width() int {
+	return colIndex(r.end.col) - colIndex(r.start.col) + 1
+}
+
+// addrAt returns the address of the cell in row i and column j of r, counting from 0.
+func (r Range) addrAt(i, j int) CellAddress {
+	return CellAddress{col: colName(colIndex(r.start.col) + j), row: r.start.row + uint32(i)}
+}
+
+// addrs returns the addresses of all of the cells in r, row by row.
+func (r Range) addrs() []CellAddress {
+	addrs := make([]CellAddress, 0, r.height()*r.width())
+	for i := 0; i < r.height(); i++ {
+		for j := 0; j < r.width(); j++ {
+			addrs = append(addrs, r.addrAt(i, j))
+		}
+	}
+	return addrs
+}
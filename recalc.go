@@ -0,0 +1,402 @@
+package sheet
+
+import (
+	"sort"
+	"sync"
+)
+
+// recalcPlan is the set of cells affected by a change, grouped into levels. Every cell in a level
+// only depends on cells in earlier levels (or on cells unaffected by the change), so the cells
+// within one level can be evaluated in any order, or at the same time.
+type recalcPlan struct {
+	levels [][]*Cell
+	// cyclic holds the cells that are members of a dependency cycle. These are not evaluated.
+	cyclic map[*Cell]bool
+}
+
+// affectedCells returns starts and every cell downstream of them, in the order they are found.
+func affectedCells(starts ...*Cell) []*Cell {
+	seen := make(map[*Cell]bool)
+	var cells []*Cell
+	for _, c := range starts {
+		if !seen[c] {
+			seen[c] = true
+			cells = append(cells, c)
+		}
+	}
+	for i := 0; i < len(cells); i++ {
+		for _, d := range cells[i].downstream {
+			if !seen[d] {
+				seen[d] = true
+				cells = append(cells, d)
+			}
+		}
+	}
+	return cells
+}
+
+// components returns the strongly connected components of the dependency graph reachable from
+// cells by following the edges returned by next, found with Tarjan's algorithm. Every component
+// comes before the components that can reach it, so when following downstream edges, components
+// are returned in reverse topological order.
+func components(cells []*Cell, next func(*Cell) []*Cell) [][]*Cell {
+	var (
+		index   = make(map[*Cell]int, len(cells))
+		lowlink = make(map[*Cell]int, len(cells))
+		onStack = make(map[*Cell]bool)
+		stack   []*Cell
+		comps   [][]*Cell
+		counter int
+	)
+	var connect func(c *Cell)
+	connect = func(c *Cell) {
+		index[c] = counter
+		lowlink[c] = counter
+		counter++
+		stack = append(stack, c)
+		onStack[c] = true
+		for _, d := range next(c) {
+			if _, ok := index[d]; !ok {
+				connect(d)
+				if lowlink[d] < lowlink[c] {
+					lowlink[c] = lowlink[d]
+				}
+			} else if onStack[d] && index[d] < lowlink[c] {
+				lowlink[c] = index[d]
+			}
+		}
+		if lowlink[c] == index[c] {
+			var comp []*Cell
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				comp = append(comp, top)
+				if top == c {
+					break
+				}
+			}
+			comps = append(comps, comp)
+		}
+	}
+	for _, c := range cells {
+		if _, ok := index[c]; !ok {
+			connect(c)
+		}
+	}
+	return comps
+}
+
+// downstreamOf returns the cells downstream of c.
+func downstreamOf(c *Cell) []*Cell {
+	return c.downstream
+}
+
+// isCycle returns true if the component comp is a dependency cycle, meaning it has more than one
+// cell, or its single cell depends on itself.
+func isCycle(comp []*Cell) bool {
+	if len(comp) > 1 {
+		return true
+	}
+	for _, d := range comp[0].downstream {
+		if d == comp[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// planRecalc builds a recalcPlan for cells, which must be closed under the downstream relation
+// (as returned by affectedCells).
+func planRecalc(cells []*Cell) *recalcPlan {
+	comps := components(cells, downstreamOf)
+
+	// Tarjan's algorithm emits components in reverse topological order, so walk them backwards
+	// to see every component after all of the components upstream of it.
+	plan := &recalcPlan{cyclic: make(map[*Cell]bool)}
+	level := make(map[*Cell]int, len(cells))
+	for i := len(comps) - 1; i >= 0; i-- {
+		comp := comps[i]
+		member := make(map[*Cell]bool, len(comp))
+		for _, c := range comp {
+			member[c] = true
+		}
+		l := 0
+		for _, c := range comp {
+			for _, u := range c.upstream {
+				if member[u] {
+					continue
+				}
+				if ul, ok := level[u]; ok && ul+1 > l {
+					l = ul + 1
+				}
+			}
+		}
+		cyclic := isCycle(comp)
+		for _, c := range comp {
+			level[c] = l
+			if cyclic {
+				plan.cyclic[c] = true
+			}
+		}
+		for len(plan.levels) <= l {
+			plan.levels = append(plan.levels, nil)
+		}
+		plan.levels[l] = append(plan.levels[l], comp...)
+	}
+	for _, cells := range plan.levels {
+		sort.Slice(cells, func(i, j int) bool {
+			if cells[i].sheet != cells[j].sheet {
+				// Cells of other sheets of the same workbook, whose names differ.
+				return cells[i].sheet.name < cells[j].sheet.name
+			}
+			return cells[i].addr.less(cells[j].addr)
+		})
+	}
+	return plan
+}
+
+// evaluateParallel evaluates cells using up to workers goroutines and waits for them to finish.
+func evaluateParallel(cells []*Cell, workers int) {
+	if workers > len(cells) {
+		workers = len(cells)
+	}
+	if workers <= 1 {
+		for _, c := range cells {
+			c.evaluate()
+		}
+		return
+	}
+	// Hand each worker a contiguous slice of the level rather than sending cells one at a time;
+	// evaluating a single cell is usually cheaper than a channel operation.
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(part []*Cell) {
+			defer wg.Done()
+			for _, c := range part {
+				c.evaluate()
+			}
+		}(cells[i*len(cells)/workers : (i+1)*len(cells)/workers])
+	}
+	wg.Wait()
+}
+
+// recalculateParallel recalculates start and everything downstream of it, one level at a time,
+// using s.RecalcWorkers goroutines. startOld and cause describe the change to start.
+func (s *Sheet) recalculateParallel(start *Cell, startOld string, cause ChangeCause) {
+	s.runPlan(planRecalc(affectedCells(start)), s.RecalcWorkers, start, startOld, cause)
+}
+
+// runPlan evaluates the cells in plan one level at a time, using up to workers goroutines.
+// Subscribers are notified after each level has been evaluated, for the cells of that level in
+// row-major order. If start is not nil, the change to it is reported with startOld and cause
+// rather than as a recalculation.
+//
+// The plan is made before evaluating anything, so it does not know about references that change
+// during evaluation, as with INDIRECT, or about the cells array results spill into. Cells whose
+// references changed and cells whose spilled values changed are evaluated again, along with
+// everything downstream of them, once the plan is done. That can change more of them, so this is
+// repeated up to maxReplans times.
+func (s *Sheet) runPlan(plan *recalcPlan, workers int, start *Cell, startOld string, cause ChangeCause) {
+	relinked := s.runLevels(plan, workers, start, startOld, cause)
+	for i := 0; i < maxReplans && len(relinked) > 0; i++ {
+		relinked = s.runLevels(planRecalc(affectedCells(relinked...)), workers, nil, "", CauseRecalc)
+	}
+}
+
+// maxReplans is the most times runPlan evaluates cells again because of changes found while
+// evaluating them, which stops references that keep changing each other from looping forever.
+const maxReplans = 4
+
+// runLevels does the work of runPlan, returning the cells that must be evaluated again.
+func (s *Sheet) runLevels(plan *recalcPlan, workers int, start *Cell, startOld string, cause ChangeCause) []*Cell {
+	var relinked []*Cell
+	for _, cells := range plan.levels {
+		old := make([]string, len(cells))
+		eval := make([]*Cell, 0, len(cells))
+		for i, c := range cells {
+			if c == start {
+				old[i] = startOld
+			} else {
+				old[i], _ = c.Content()
+			}
+			if plan.cyclic[c] {
+				if c.resolveCycle() {
+					relinked = append(relinked, c)
+				}
+			} else {
+				eval = append(eval, c)
+			}
+		}
+		evaluateParallel(eval, workers)
+		for _, c := range eval {
+			if c.relink() {
+				relinked = append(relinked, c)
+			}
+		}
+		for _, c := range cells {
+			relinked = append(relinked, c.respill()...)
+		}
+		for i, c := range cells {
+			if c == start {
+				c.sheet.cellUpdated(c, old[i], cause)
+			} else {
+				c.sheet.cellUpdated(c, old[i], CauseRecalc)
+			}
+		}
+	}
+	return relinked
+}
+
+// SetLazy selects whether s evaluates formulas lazily. In a lazy sheet, changing a cell only marks
+// the cells downstream of it as out of date. Their values are computed the first time they are
+// needed, through ValueAt, ContentAt, (*Cell).Value or (*Cell).Content, and remembered until
+// something upstream of them changes again. This makes editing a large sheet cheap when only part
+// of it is ever looked at. Lazy evaluation takes precedence over RecalcWorkers.
+//
+// Subscribers to a lazy sheet are only told about cells that are changed directly, since the
+// cells downstream of them are not recalculated at that time.
+//
+// The cells an array result spills into are only known once its formula has been evaluated, so
+// formulas that have spilled before are brought up to date whenever a value is needed. A formula
+// that starts returning an array only spills once something needs its value.
+//
+// Turning lazy evaluation off brings every out of date cell up to date.
+func (s *Sheet) SetLazy(lazy bool) {
+	s.lazy = lazy
+	if lazy {
+		return
+	}
+	for _, rows := range s.matrix {
+		for _, c := range rows {
+			c.refresh()
+		}
+	}
+}
+
+// Lazy returns true if s evaluates formulas lazily. See SetLazy.
+func (s *Sheet) Lazy() bool {
+	return s.lazy
+}
+
+// markDirty marks c and every cell downstream of it as out of date. A cell that is already dirty
+// has dirty downstream cells, since a cell is never brought up to date before its upstream cells.
+func (c *Cell) markDirty() {
+	if c.dirty {
+		return
+	}
+	c.dirty = true
+	for _, d := range c.downstream {
+		d.markDirty()
+	}
+}
+
+// dirtyUpstreamOf returns the cells upstream of c that are out of date.
+func dirtyUpstreamOf(c *Cell) []*Cell {
+	var dirty []*Cell
+	for _, u := range c.upstream {
+		if u.dirty {
+			dirty = append(dirty, u)
+		}
+	}
+	return dirty
+}
+
+// refresh brings c up to date if it is dirty, first bringing every dirty cell it depends on up to
+// date. Dependency cycles among those cells are found and marked the same way as when
+// recalculating eagerly.
+func (c *Cell) refresh() {
+	c.sheet.refreshSpills()
+	if !c.dirty {
+		return
+	}
+	// Following upstream edges, components come out with every cell's precedents before it.
+	var broken []*Cell
+	for _, comp := range components([]*Cell{c}, dirtyUpstreamOf) {
+		if isCycle(comp) {
+			for _, cc := range comp {
+				if cc.resolveCycle() {
+					broken = append(broken, comp...)
+				}
+				for _, d := range cc.respill() {
+					d.markDirty()
+				}
+			}
+			continue
+		}
+		comp[0].evaluate()
+		if comp[0].relink() {
+			// The other cells of a new cycle may have been brought up to date by the evaluation,
+			// with the value comp[0] had before it.
+			for _, cc := range comp[0].cycle() {
+				cc.markCycle()
+			}
+		}
+		for _, d := range comp[0].respill() {
+			d.markDirty()
+		}
+	}
+	if len(broken) > 0 {
+		// Cells were marked as part of a cycle that no longer exists, so evaluate them again.
+		for _, cc := range broken {
+			cc.markDirty()
+		}
+		c.refresh()
+	}
+}
+
+// resolveCycle handles c being found in a dependency cycle. If c references cells dynamically, the
+// cycle may only be due to the references found by an earlier evaluation, so c is evaluated again
+// to update them. It returns true if that broke the cycle. Otherwise, c is marked as part of the
+// cycle.
+func (c *Cell) resolveCycle() bool {
+	if len(c.dynamic) > 0 {
+		c.evaluate()
+		if c.relink() && !c.inCycle() {
+			return true
+		}
+	}
+	c.markCycle()
+	return false
+}
+
+// RecalculateAll recalculates every cell in s. In a lazy sheet, every cell is marked out of date
+// instead. This is mostly useful for refreshing the values of volatile functions along with
+// everything else; see RecalculateVolatile.
+func (s *Sheet) RecalculateAll() {
+	var cells []*Cell
+	for _, rows := range s.matrix {
+		for _, c := range rows {
+			cells = append(cells, c)
+		}
+	}
+	s.recalculateCells(cells)
+}
+
+// RecalculateVolatile recalculates the cells whose equations call volatile functions, such as
+// NOW or RAND, along with every cell downstream of them. Volatile functions can return a different
+// value each time they are called, so nothing else in the sheet triggers their recalculation. In a
+// lazy sheet, the cells are marked out of date instead.
+func (s *Sheet) RecalculateVolatile() {
+	cells := make([]*Cell, 0, len(s.volatile))
+	for c := range s.volatile {
+		cells = append(cells, c)
+	}
+	s.recalculateCells(cells)
+}
+
+// recalculateCells recalculates cells and everything downstream of them, in dependency order.
+func (s *Sheet) recalculateCells(cells []*Cell) {
+	if s.lazy {
+		for _, c := range cells {
+			c.markDirty()
+		}
+		return
+	}
+	workers := s.RecalcWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	s.runPlan(planRecalc(affectedCells(cells...)), workers, nil, "", CauseRecalc)
+}
@@ -0,0 +1,364 @@
+package sheet
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildWideSheet fills a sheet with cols independent columns, each a chain of depth cells that
+// depends on A1. Setting A1 recalculates every chain.
+func buildWideSheet(s *Sheet, cols, depth int) {
+	s.SetContent("A1", "1")
+	s.SetContent("A2", "1")
+	col := CellAddress{col: "B", row: 1}
+	for i := 0; i < cols; i++ {
+		s.SetContent(fmt.Sprintf("%s1", col.col), "=A1+A2")
+		for row := 2; row <= depth; row++ {
+			s.SetContent(fmt.Sprintf("%s%d", col.col, row), fmt.Sprintf("=%s%d+A2", col.col, row-1))
+		}
+		col, _ = col.NextCol()
+	}
+}
+
+func TestParallelRecalc(t *testing.T) {
+	assert := assert.New(t)
+	serial := NewSheet()
+	parallel := NewSheet()
+	parallel.RecalcWorkers = 4
+	for _, s := range []*Sheet{serial, parallel} {
+		buildWideSheet(s, 30, 10)
+		s.SetContent("A1", "5")
+	}
+
+	max := serial.MaxAddr()
+	assert.Equal(max, parallel.MaxAddr())
+	for row := uint32(1); row <= max.row; row++ {
+		for col := (CellAddress{col: "A", row: row}); col.LEQCol(max); col, _ = col.NextCol() {
+			sv, err := serial.ContentAt(col.String())
+			assert.NoError(err)
+			pv, err := parallel.ContentAt(col.String())
+			assert.NoError(err)
+			assert.Equal(sv, pv, col.String())
+		}
+	}
+	v, err := parallel.ValueAt("AE10")
+	assert.NoError(err)
+	assert.Equal(float64(15), v)
+
+	// A cycle downstream of the chains and of a text cell gives the same results as well.
+	for _, e := range [][2]string{
+		{"A3", "label"},
+		{"AF1", "=A3&AG1"},
+		{"AH1", `=AG1&"!"`},
+		{"AG1", "=AF1&AE10"},
+		{"AF2", "=AH1&B2"},
+		{"A1", "6"},
+		{"A3", "other"},
+		{"AG1", "=AE10"},
+	} {
+		for _, s := range []*Sheet{serial, parallel} {
+			assert.NoError(s.SetContent(e[0], e[1]))
+		}
+		assertSameContent(t, serial, parallel)
+		if e[0] == "A3" {
+			c, err := serial.ContentAt("A3")
+			assert.NoError(err)
+			assert.Equal(e[1], c)
+		}
+	}
+	assert.Equal([][]string{{"other16", "16", "16!"}, {"16!8", "", ""}}, contentBlock(serial, "AF1:AH2"))
+}
+
+func TestParallelRecalcOrder(t *testing.T) {
+	assert := assert.New(t)
+	var first []string
+	for i := 0; i < 10; i++ {
+		sheet := NewSheet()
+		sheet.RecalcWorkers = 8
+		buildWideSheet(sheet, 20, 3)
+		var order []string
+		sheet.OnCellUpdated = func(addr string, c *Cell) {
+			order = append(order, addr)
+		}
+		sheet.SetContent("A1", "2")
+		if first == nil {
+			first = order
+			continue
+		}
+		assert.Equal(first, order)
+	}
+	if !assert.Len(first, 61) {
+		return
+	}
+	assert.Equal([]string{"A1", "B1", "C1"}, first[:3])
+	assert.Equal("U3", first[len(first)-1])
+}
+
+func TestParallelEquationLoop(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.RecalcWorkers = 2
+
+	assert.NoError(sheet.SetContent("A1", "=A2"))
+	assert.NoError(sheet.SetContent("A2", "=A3"))
+	assert.NoError(sheet.SetContent("B1", "=A1+A4"))
+	assert.NoError(sheet.SetContent("A3", "=A1"))
+
+	for _, addr := range []string{"A1", "A2", "A3"} {
+		v, err := sheet.ContentAt(addr)
+		assert.NoError(err)
+		assert.Equal(addr+": Cyclical equations detected.", v)
+	}
+	_, err := sheet.ValueAt("B1")
+	assert.Error(err)
+
+	assert.NoError(sheet.SetContent("A3", "7"))
+	v, err := sheet.ValueAt("B1")
+	assert.NoError(err)
+	assert.Equal(float64(7), v)
+
+	assert.NoError(sheet.SetContent("C1", "=C1"))
+	c, err := sheet.ContentAt("C1")
+	assert.NoError(err)
+	assert.Equal("C1: Cyclical equations detected.", c)
+}
+
+func benchmarkRecalc(b *testing.B, workers int) {
+	sheet := NewSheet()
+	sheet.RecalcWorkers = workers
+	buildWideSheet(sheet, 600, 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sheet.SetContent("A1", fmt.Sprintf("%d", i))
+	}
+}
+
+func BenchmarkRecalcSerial(b *testing.B)     { benchmarkRecalc(b, 0) }
+func BenchmarkRecalcParallel2(b *testing.B)  { benchmarkRecalc(b, 2) }
+func BenchmarkRecalcParallel4(b *testing.B)  { benchmarkRecalc(b, 4) }
+func BenchmarkRecalcParallel16(b *testing.B) { benchmarkRecalc(b, 16) }
+
+// assertSameContent checks that every cell in want has the same content in got.
+func assertSameContent(t *testing.T, want, got *Sheet) {
+	max := want.MaxAddr()
+	for row := uint32(1); row <= max.row; row++ {
+		for col := (CellAddress{col: "A", row: row}); col.LEQCol(max); col, _ = col.NextCol() {
+			wv, err := want.ContentAt(col.String())
+			assert.NoError(t, err)
+			gv, err := got.ContentAt(col.String())
+			assert.NoError(t, err)
+			assert.Equal(t, wv, gv, col.String())
+		}
+	}
+}
+
+func TestLazyRecalc(t *testing.T) {
+	edits := [][2]string{
+		{"A1", "1"},
+		{"A2", "=A1+B1"},
+		{"A3", "=A2+A2"},
+		{"B1", "=C1"},
+		{"C1", "=A3"},
+		{"D1", "=A3+B1"},
+		{"C1", "4"},
+		{"A1", "Text"},
+		{"A1", "2"},
+		{"B2", "=B2"},
+		{"A2", ""},
+	}
+	eager := NewSheet()
+	lazy := NewSheet()
+	lazy.SetLazy(true)
+	for _, e := range edits {
+		assert.NoError(t, eager.SetContent(e[0], e[1]))
+		assert.NoError(t, lazy.SetContent(e[0], e[1]))
+		assertSameContent(t, eager, lazy)
+	}
+}
+
+func TestCycleRecalc(t *testing.T) {
+	edits := [][2]string{
+		{"A1", "x"},
+		{"A2", "5"},
+		{"B1", "=A1&C1"},
+		{"D1", `=C1&"!"`},
+		{"C1", "=B1"},
+		{"B2", "=A2+C2"},
+		{"D2", "=C2+1"},
+		{"C2", "=B2*2"},
+		{"A1", "y"},
+		{"A2", "6"},
+		{"E1", "=D1&D2"},
+		{"C1", "z"},
+		{"C2", "3"},
+		{"C2", "=D2"},
+		{"A2", "7"},
+	}
+	serial := NewSheet()
+	parallel := NewSheet()
+	parallel.RecalcWorkers = 4
+	lazy := NewSheet()
+	lazy.SetLazy(true)
+	for _, e := range edits {
+		for _, s := range []*Sheet{serial, parallel, lazy} {
+			assert.NoError(t, s.SetContent(e[0], e[1]))
+		}
+		assertSameContent(t, serial, parallel)
+		assertSameContent(t, serial, lazy)
+	}
+
+	// Only the formulas in a cycle show its error, not the cells upstream of it.
+	for addr, want := range map[string]string{
+		"A1": "y",
+		"A2": "7",
+		"B2": "B2: C2: Cyclical equations detected.",
+		"C2": "C2: Cyclical equations detected.",
+		"D2": "D2: Cyclical equations detected.",
+		"E1": "E1: D2: Cyclical equations detected.",
+	} {
+		c, err := serial.ContentAt(addr)
+		assert.NoError(t, err)
+		assert.Equal(t, want, c, addr)
+	}
+}
+
+func TestLazyDefersEvaluation(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetLazy(true)
+	assert.True(sheet.Lazy())
+	sheet.SetContent("A1", "1")
+	sheet.SetContent("A2", "=A1+A1")
+	sheet.SetContent("A3", "=A2+A1")
+
+	a3 := sheet.cellAt(CellAddress{col: "A", row: 3})
+	assert.True(a3.dirty)
+	v, err := sheet.ValueAt("A3")
+	assert.NoError(err)
+	assert.Equal(float64(3), v)
+	assert.False(a3.dirty)
+
+	sheet.SetContent("A1", "2")
+	assert.True(a3.dirty)
+	assert.Equal(float64(3), a3.val)
+
+	sheet.SetLazy(false)
+	assert.False(a3.dirty)
+	assert.Equal(float64(6), a3.val)
+}
+
+func TestLazyEquationLoop(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetLazy(true)
+
+	assert.NoError(sheet.SetContent("A1", "=A2"))
+	assert.NoError(sheet.SetContent("A2", "=A3"))
+	assert.NoError(sheet.SetContent("A3", "=A1"))
+
+	for _, addr := range []string{"A1", "A2", "A3"} {
+		v, err := sheet.ContentAt(addr)
+		assert.NoError(err)
+		assert.Equal(addr+": Cyclical equations detected.", v)
+	}
+}
+
+func TestIndirectCycle(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			setup(sheet)
+			sheet.SetContent("B1", "7")
+			sheet.SetContent("C1", "=INDIRECT(A1)*10")
+			sheet.SetContent("D1", "=C1+1")
+			sheet.SetContent("A1", "B1")
+			assert.Equal([][]string{{"70", "71"}}, contentBlock(sheet, "C1:D1"))
+
+			// The cycle is only found by evaluating C1, which refers to D1 through A1 from now on.
+			sheet.SetContent("A1", "D1")
+			for _, addr := range []string{"C1", "D1"} {
+				_, err := sheet.ValueAt(addr)
+				assert.EqualError(err, addr+": Cyclical equations detected.", addr)
+			}
+		})
+	}
+}
+
+func TestCompiledFormulas(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("A1", "=-B1*2+SUM(B1:B3)")
+	sheet.SetContent("A2", `=B2-1&"!"`)
+	sheet.SetContent("A3", "=LET(x,B1,LAMBDA(y,x+y)(B3))")
+	sheet.SetContent("A4", "=INDIRECT(C1)+1")
+	sheet.SetContent("C1", "B1")
+	for _, row := range []uint32{1, 2} {
+		c := sheet.cellAt(CellAddress{col: "A", row: row})
+		assert.NotNil(c.exp.compiled, row)
+		assert.False(c.hasDynamic, row)
+	}
+	assert.True(sheet.cellAt(CellAddress{col: "A", row: 4}).hasDynamic)
+	assert.Equal([][]string{{"0"}, {"-1!"}, {"0"}, {"1"}}, contentBlock(sheet, "A1:A4"))
+
+	// The cells referred to are the same when they are set, cleared and spilled into.
+	sheet.SetContent("B1", "2")
+	sheet.SetContent("B2", "=SEQUENCE(2)")
+	assert.Equal([][]string{{"1"}, {"0!"}, {"4"}, {"3"}}, contentBlock(sheet, "A1:A4"))
+	sheet.SetContent("B2", "")
+	sheet.SetContent("B3", "5")
+	assert.Equal([][]string{{"3"}, {"-1!"}, {"7"}, {"3"}}, contentBlock(sheet, "A1:A4"))
+
+	// Numbers are exact in decimal mode.
+	sheet.SetContent("A5", "=0.1+0.2")
+	sheet.SetDecimal(true)
+	r, err := sheet.ExactValueAt("A5")
+	assert.NoError(err)
+	assert.Equal("3/10", r.String())
+}
+
+// buildChain fills column A of sheet with a chain of n formulas, each adding one to the cell above
+// it, starting from a number in A1.
+func buildChain(sheet *Sheet, n int) {
+	sheet.SetContent("A1", "0")
+	for i := 2; i <= n; i++ {
+		sheet.SetContent(fmt.Sprintf("A%d", i), fmt.Sprintf("=A%d+1", i-1))
+	}
+}
+
+// chainLength is the number of cells in the chains of the chain benchmarks.
+const chainLength = 100000
+
+func benchmarkChain(b *testing.B, setup func(s *Sheet)) {
+	sheet := NewSheet()
+	setup(sheet)
+	buildChain(sheet, chainLength)
+	last := fmt.Sprintf("A%d", chainLength)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sheet.SetContent("A1", fmt.Sprintf("%d", i))
+		v, err := sheet.ValueAt(last)
+		if err != nil || v != float64(i+chainLength-1) {
+			b.Fatalf("%s = %v, %v", last, v, err)
+		}
+	}
+	b.ReportMetric(chainLength, "cells/op")
+}
+
+func BenchmarkChainSerial(b *testing.B)    { benchmarkChain(b, func(s *Sheet) {}) }
+func BenchmarkChainParallel4(b *testing.B) { benchmarkChain(b, func(s *Sheet) { s.RecalcWorkers = 4 }) }
+func BenchmarkChainLazy(b *testing.B)      { benchmarkChain(b, func(s *Sheet) { s.SetLazy(true) }) }
+func BenchmarkChainDecimal(b *testing.B)   { benchmarkChain(b, func(s *Sheet) { s.SetDecimal(true) }) }
+
+func BenchmarkChainBuild(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		buildChain(NewSheet(), chainLength)
+	}
+	b.ReportMetric(chainLength, "cells/op")
+}
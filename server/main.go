@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"bufio"
+	"sync"
 	
 	"github.com/knusbaum/go9p"
 	"github.com/knusbaum/go9p/fs"
@@ -11,21 +12,39 @@ import (
 )
 
 func main() {
-	sheetFS := fs.NewFS("glenda", "glenda", 0555)
+	sheetFS, root := fs.NewFS("glenda", "glenda", 0555)
 
-	outputStream := fs.NewStream(100, false)
+	outputStream := fs.NewSkippingStream(100)
 	updates := fs.NewStreamFile(sheetFS.NewStat("updates", "glenda", "glenda", 0444), outputStream)
-	sheetFS.Root.AddChild(updates)
+	root.AddChild(updates)
 
-	inputStream := fs.NewStream(100, false)
+	inputStream := fs.NewBlockingStream(100)
 	ctl := fs.NewStreamFile(sheetFS.NewStat("ctl", "glenda", "glenda", 0222), inputStream)
-	sheetFS.Root.AddChild(ctl)
+	root.AddChild(ctl)
 
 	s := sheet.NewSheet()
-	s.OnCellUpdated = func(addr string, c *sheet.Cell) {
-		content, _ := c.Content()
-		outputStream.Write([]byte(fmt.Sprintf("%s %d %s\n", addr, len(content), content)))
-	}
+	// mu serializes the use of s by the ctl reader and the deps file, which run on different
+	// goroutines.
+	var mu sync.Mutex
+
+	// Writing an address to deps reads back the dependency tree for that cell.
+	deps := fs.NewPipeFile(sheetFS.NewStat("deps", "glenda", "glenda", 0666), func(st fs.BiDiStream) {
+		scanner := bufio.NewScanner(st)
+		for scanner.Scan() {
+			var b strings.Builder
+			mu.Lock()
+			err := s.WriteDependencyTree(&b, strings.TrimSpace(scanner.Text()))
+			mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(&b, "%s\n", err)
+			}
+			st.Write([]byte(b.String()))
+		}
+	})
+	root.AddChild(deps)
+	s.Subscribe(func(ev sheet.CellEvent) {
+		outputStream.Write([]byte(fmt.Sprintf("%s %d %s\n", ev.Addr, len(ev.NewContent), ev.NewContent)))
+	})
 
 	err := s.Read(strings.NewReader("A4 11 Hello World\n"))
 	fmt.Printf("%v\n", []byte("A4 11 Hello World\n"))
@@ -37,7 +56,14 @@ func main() {
 		r := inputStream.AddReader()
 		br := bufio.NewReader(r)
 		for {
+			// Wait for input before taking the lock, so that deps isn't blocked in the meantime.
+			if _, err := br.Peek(1); err != nil {
+				fmt.Printf("Failed to read: %s\n", err)
+				continue
+			}
+			mu.Lock()
 			err := s.Read(br)
+			mu.Unlock()
 			if err != nil {
 				fmt.Printf("Failed to read: %s\n", err)
 			}
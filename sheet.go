@@ -4,24 +4,71 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
+	"math/rand"
+	"sync"
+	"time"
 )
 
 // Sheet represents a spreadsheet.
 type Sheet struct {
 	matrix map[string]map[uint32]*Cell
-	// OnCellUpdated is a callback that will be called when a cell is updated during
-	// recalculations. It is *NOT* called when 	explicitly setting the content of a cell.
-	// OnCellUpdated may be set by the user.
+	// OnCellUpdated is a callback that will be called when a cell is updated, either because its
+	// content was set or because it was recalculated. OnCellUpdated may be set by the user.
+	// Subscribe delivers the same updates with more detail, and allows more than one listener.
 	OnCellUpdated func(addr string, c *Cell)
+	// RecalcWorkers is the number of goroutines used to recalculate the cells affected by a change.
+	// When it is greater than 1, affected cells are evaluated in topological order, and cells that
+	// do not depend on each other are evaluated in parallel. OnCellUpdated is still called from the
+	// goroutine making the change, in an order that does not depend on scheduling. When it is 0 or
+	// 1, cells are recalculated serially by (*Cell).Recalculate.
+	// RecalcWorkers may be set by the user.
+	RecalcWorkers int
+	// Clock returns the current time for volatile functions such as NOW and TODAY. If it is nil,
+	// time.Now is used. Clock may be set by the user, for instance to make tests deterministic.
+	Clock func() time.Time
+	// Rand is the source of random numbers for volatile functions such as RAND. If it is nil, a
+	// source seeded with the current time is created when first needed. Rand may be set by the
+	// user.
+	Rand *rand.Rand
+	// SolverIterations is the most iterations the numeric solvers of functions such as IRR and
+	// RATE may take to find a solution before giving up with ErrNum. If it is 0,
+	// DefaultSolverIterations is used. SolverIterations may be set by the user.
+	SolverIterations int
+
+	lazy    bool
+	decimal bool
+	// volatile holds the cells whose equations call volatile functions.
+	volatile map[*Cell]bool
+	randMu   sync.Mutex
+	// spills holds the cells whose equations evaluated to arrays, with the ranges their results
+	// spill into, or would spill into if they weren't blocked. See (*Cell).respill.
+	spills           map[*Cell]Range
+	refreshingSpills bool
+	// names holds the definitions of the names defined by DefineName, by upper-case name.
+	names map[string]*Expression
+	// book is the Workbook s belongs to, under name, or nil if it was made by NewSheet.
+	book *Workbook
+	name string
+
+	subMu   sync.Mutex
+	subs    []subscription
+	nextSub uint64
+	version uint64
 }
 
 // NewSheet creates a new, empty spreadsheet.
 func NewSheet() *Sheet {
-	return &Sheet{matrix: make(map[string]map[uint32]*Cell)}
+	return &Sheet{
+		matrix:   make(map[string]map[uint32]*Cell),
+		volatile: make(map[*Cell]bool),
+		spills:   make(map[*Cell]Range),
+		names:    make(map[string]*Expression),
+	}
 }
 
 // SetContent sets the content of the cell at address addr in the sheet.
-// If the address is invalid, SetContent returns an error.
+// If the address is invalid, SetContent returns an error. If content is an equation that can't be
+// parsed, the cell shows the error, and SetContent returns it as well. See (*Cell).SetContent.
 func (s *Sheet) SetContent(addr string, content string) error {
 	//fmt.Printf("Setting %s -> %s\n", addr, content)
 	a, err := CellAddr(addr)
@@ -41,6 +88,50 @@ func (s *Sheet) SetContent(addr string, content string) error {
 	return cell.SetContent(content)
 }
 
+// SetFormat sets the format that the numeric value of the cell at address addr is displayed in by
+// ContentAt. Formats set this way take precedence over the formats detected from the content of a
+// cell, and stay with the cell when its content changes. An empty format removes the format.
+// Formats are either one of the following names, optionally followed by a number of decimals, or a
+// custom pattern:
+//
+//  general            1234.5
+//  fixed:3            1234.500
+//  thousands:1        1,234.5
+//  currency           $1,234.50
+//  currency:0:€       €1,235
+//  percent:1          123450.0%
+//  scientific         1.23E+03
+//  date, datetime, time, duration
+//
+// Custom patterns are in the style of other spreadsheets: zeros are digits, a comma in the whole
+// part groups thousands, a point starts the decimals, a trailing % shows percentages, E+00 shows
+// scientific notation, and any other text is shown as is. For instance "$#,##0.00" is the same as
+// currency, and `0.0 "kg"` shows one decimal followed by kg.
+func (s *Sheet) SetFormat(addr string, format string) error {
+	a, err := CellAddr(addr)
+	if err != nil {
+		return err
+	}
+	if format == "" && s.cellAt(a) == nil {
+		return nil
+	}
+	return s.cellOrNewAt(a).SetFormat(format)
+}
+
+// FormatAt returns the format that the value of the cell at address addr is displayed in. See
+// SetFormat.
+func (s *Sheet) FormatAt(addr string) (string, error) {
+	a, err := CellAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	c := s.cellAt(a)
+	if c == nil {
+		return numberFormat{}.String(), nil
+	}
+	return c.Format(), nil
+}
+
 // setCellAt puts a Cell into s at address addr.
 func (s *Sheet) setCellAt(addr CellAddress, c *Cell) {
 	rows := s.matrix[addr.col]
@@ -69,6 +160,30 @@ func (s *Sheet) cellOrNewAt(addr CellAddress) *Cell {
 	return cell
 }
 
+// refSheet returns the sheet named name in the workbook of s, which is s itself if name is "". It
+// returns an error wrapping ErrRef if there is no such sheet.
+func (s *Sheet) refSheet(name string) (*Sheet, error) {
+	if name == "" {
+		return s, nil
+	}
+	if s.book != nil {
+		if t := s.book.Sheet(name); t != nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%w Unknown sheet %s", ErrRef, name)
+}
+
+// cellOrNewAtRef is like cellOrNewAt, for the cell r refers to from a formula in s. It returns nil
+// if r is to a sheet that doesn't exist.
+func (s *Sheet) cellOrNewAtRef(r cellRef) *Cell {
+	t, err := s.refSheet(r.sheet)
+	if err != nil {
+		return nil
+	}
+	return t.cellOrNewAt(r.addr)
+}
+
 // cellAt returns a cell from addr in s if there is one, or nil if there is none.
 func (s *Sheet) cellAt(addr CellAddress) *Cell {
 	rows := s.matrix[addr.col]
@@ -133,26 +248,32 @@ func (s *Sheet) editAt(addr CellAddress) (string, error) {
 	return cell.EditValue()
 }
 
-// MaxCol returns the last column containing a cell with a value in the sheet.
+// MaxCol returns the last column containing a cell with a value in the sheet. Empty cells that
+// are only kept because formulas refer to them don't count.
 func (s *Sheet) MaxCol() CellAddress {
 	max := CellAddress{col: "A", row: 1}
-	for k := range s.matrix {
-		//fmt.Printf("KEY: %s\n", k)
+	for k, col := range s.matrix {
 		addr := CellAddress{col: k, row: 1}
-		//fmt.Printf("Comparing %v to %v\n", max, addr)
-		if max.LessCol(addr) {
-			max = addr
+		if !max.LessCol(addr) {
+			continue
+		}
+		for _, c := range col {
+			if c.cell_type != cell_transient {
+				max = addr
+				break
+			}
 		}
 	}
 	return max
 }
 
-// MaxRow returns the highest number row containing a cell with a value in the sheet.
+// MaxRow returns the highest number row containing a cell with a value in the sheet. Empty cells
+// that are only kept because formulas refer to them don't count.
 func (s *Sheet) MaxRow() uint32 {
 	max := uint32(1)
 	for _, col := range s.matrix {
-		for k := range col {
-			if k > max {
+		for k, c := range col {
+			if k > max && c.cell_type != cell_transient {
 				max = k
 			}
 		}
@@ -174,8 +295,8 @@ func (s *Sheet) MaxAddr() CellAddress {
 // WriteCSV writes out a CSV containing the contents of the sheet. This uses the ContentAt function
 // to write human-readable values of the cells, including the results of the evaluated equations.
 func (s *Sheet) WriteCSV(w io.Writer) {
-	for row := uint32(1); row <= s.MaxRow(); row++ {
-		mc := s.MaxCol()
+	mc, mr := s.MaxCol(), s.MaxRow()
+	for row := uint32(1); row <= mr; row++ {
 		var err error
 		col := CellAddress{col: "A", row: row}
 		for {
@@ -204,8 +325,8 @@ func (s *Sheet) WriteCSV2(w io.Writer, headers, edit bool) {
 		}
 		cw.Write(hs)
 	}
-	mc := s.MaxCol()
-	for row := uint32(1); row <= s.MaxRow(); row++ {
+	mc, mr := s.MaxCol(), s.MaxRow()
+	for row := uint32(1); row <= mr; row++ {
 		rv := []string{}
 		if headers {
 			rv = append(rv, fmt.Sprintf("%d", row))
@@ -241,7 +362,8 @@ func (s *Sheet) WriteRange(start CellAddress, end CellAddress, w io.Writer) erro
 			//fmt.Printf("COL: %s, end: %s, leq: %v\n", col, end, col.LEQCol(end))
 
 			cell := s.cellAt(col)
-			if cell == nil {
+			if cell == nil || cell.cell_type == cell_spill {
+				// Spilled values are recreated by the formula they come from.
 				continue
 			}
 
@@ -299,6 +421,5 @@ func (s *Sheet) Read(r io.Reader) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("SETTING CONTENT: %s to [%#v]\n", a.String(), c)
 	return s.SetContent(a.String(), c)
 }
@@ -3,8 +3,11 @@ package sheet
 import (
 	"fmt"
 	"io"
+	"math"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/quick"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -312,6 +315,17 @@ func TestMaxRow(t *testing.T) {
 	assert.Equal(uint32(2991), row)
 }
 
+func TestMaxAddrReferences(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	assert.NoError(sheet.SetContent("B2", "=SUM(A1:A200000)+ZZ9"))
+	assert.Equal(CellAddress{col: "B", row: 2}, sheet.MaxAddr())
+
+	var b strings.Builder
+	sheet.WriteCSV(&b)
+	assert.Equal(",,\n,0,\n", b.String())
+}
+
 //func TestCSV(t *testing.T) {
 //	//assert := assert.New(t)
 //	sheet := NewSheet()
@@ -346,11 +360,11 @@ func TestWriteRange(t *testing.T) {
 	sheet.WriteRange(start, end, b)
 	out := b.String()
 	expected := `A1 5 Count
-B1 8 1.000000
-C1 8 2.000000
-D1 8 3.000000
-E1 8 4.000000
-F1 8 5.000000
+B1 1 1
+C1 1 2
+D1 1 3
+E1 1 4
+F1 1 5
 F2 5 Total
 F3 15 =B1+C1+D1+E1+F1
 `
@@ -417,3 +431,226 @@ func TestTransient(t *testing.T) {
 	assert.Nil(sheet.cellAt(CellAddress{col: "F", row: 3}))
 	assert.Nil(sheet.cellAt(CellAddress{col: "B", row: 1}))
 }
+
+func TestSubscribe(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("A1", "1")
+	sheet.SetContent("B1", "1")
+	sheet.SetContent("A2", "=A1+B1")
+
+	var events []CellEvent
+	unsubscribe := sheet.Subscribe(func(ev CellEvent) {
+		events = append(events, ev)
+	})
+	base := sheet.Version()
+
+	sheet.SetContent("A1", "2")
+	if !assert.Len(events, 2) {
+		return
+	}
+	// The edit comes before the recalculations it causes.
+	assert.Equal("A1", events[0].Addr.String())
+	assert.Equal(CauseEdit, events[0].Cause)
+	assert.Equal("1", events[0].OldContent)
+	assert.Equal("2", events[0].NewContent)
+	assert.Equal(base+1, events[0].Version)
+	assert.Equal("A2", events[1].Addr.String())
+	assert.Equal(CauseRecalc, events[1].Cause)
+	assert.Equal("2", events[1].OldContent)
+	assert.Equal("3", events[1].NewContent)
+	assert.Equal(base+2, events[1].Version)
+	assert.Equal(base+2, sheet.Version())
+
+	unsubscribe()
+	unsubscribe()
+	sheet.SetContent("A1", "3")
+	assert.Len(events, 2)
+}
+
+func TestSubscribeParallel(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.RecalcWorkers = 2
+	sheet.SetContent("A1", "1")
+	sheet.SetContent("B1", "=A1+A1")
+	sheet.SetContent("C1", "=B1+A1")
+
+	var got []string
+	sheet.Subscribe(func(ev CellEvent) {
+		got = append(got, fmt.Sprintf("%d %s %s %s->%s", ev.Version, ev.Addr, ev.Cause, ev.OldContent, ev.NewContent))
+	})
+	v := sheet.Version()
+	sheet.SetContent("A1", "")
+	assert.Equal([]string{
+		fmt.Sprintf("%d A1 edit 1->", v+1),
+		fmt.Sprintf("%d B1 recalc 2->0", v+2),
+		fmt.Sprintf("%d C1 recalc 3->0", v+3),
+	}, got)
+}
+
+func TestPrecedentsDependents(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("A1", "1")
+	sheet.SetContent("A2", "2")
+	sheet.SetContent("B1", "=A1+A2")
+	sheet.SetContent("B2", "=B1+A1")
+	sheet.SetContent("C1", "=B2+D4")
+
+	addrs := func(strs ...string) []CellAddress {
+		var as []CellAddress
+		for _, s := range strs {
+			a, _ := CellAddr(s)
+			as = append(as, a)
+		}
+		return as
+	}
+
+	p, err := sheet.Precedents("C1", false)
+	assert.NoError(err)
+	assert.Equal(addrs("B2", "D4"), p)
+	p, err = sheet.Precedents("C1", true)
+	assert.NoError(err)
+	assert.Equal(addrs("A1", "B1", "A2", "B2", "D4"), p)
+	p, err = sheet.Precedents("A1", true)
+	assert.NoError(err)
+	assert.Empty(p)
+
+	d, err := sheet.Dependents("A1", false)
+	assert.NoError(err)
+	assert.Equal(addrs("B1", "B2"), d)
+	d, err = sheet.Dependents("A2", true)
+	assert.NoError(err)
+	assert.Equal(addrs("B1", "C1", "B2"), d)
+	d, err = sheet.Dependents("Z99", true)
+	assert.NoError(err)
+	assert.Empty(d)
+
+	_, err = sheet.Dependents("!!", true)
+	assert.Error(err)
+}
+
+func TestWriteDependencyTree(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("A1", "1")
+	sheet.SetContent("B1", "=A1+A1")
+	sheet.SetContent("B2", "=B1+A1")
+	sheet.SetContent("C1", "=B2")
+
+	b := &strings.Builder{}
+	assert.NoError(sheet.WriteDependencyTree(b, "B2"))
+	expected := `B2 =B1+A1
+precedents:
+  A1 1
+  B1 =A1+A1
+    A1 ...
+dependents:
+  C1 =B2
+`
+	assert.Equal(expected, b.String())
+}
+
+func TestWriteDOT(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("A1", "1")
+	sheet.SetContent("B1", "=A1+C3")
+	sheet.SetContent("A2", "=B2")
+	sheet.SetContent("B2", "=A2")
+	sheet.SetContent("F9", "Unrelated")
+
+	r, err := CellRange("A1:B2")
+	if !assert.NoError(err) {
+		return
+	}
+	b := &strings.Builder{}
+	assert.NoError(sheet.WriteDOT(b, r))
+	expected := `digraph sheet {
+	node [shape=box];
+	"A1" [label="A1\n1"];
+	"B1" [label="B1\n=A1+C3"];
+	"A2" [label="A2\n=B2", color=red, fontcolor=red];
+	"B2" [label="B2\n=A2", color=red, fontcolor=red];
+	"A1" -> "B1";
+	"C3" [label="C3", style=dashed];
+	"C3" -> "B1";
+	"B2" -> "A2" [color=red];
+	"A2" -> "B2" [color=red];
+}
+`
+	assert.Equal(expected, b.String())
+}
+
+func TestCellRange(t *testing.T) {
+	assert := assert.New(t)
+	r, err := CellRange("c5:A1")
+	assert.NoError(err)
+	assert.Equal("A1:C5", r.String())
+	assert.True(r.Contains(CellAddress{col: "B", row: 3}))
+	assert.False(r.Contains(CellAddress{col: "D", row: 3}))
+	assert.False(r.Contains(CellAddress{col: "B", row: 6}))
+
+	r, err = CellRange("B2")
+	assert.NoError(err)
+	assert.Equal("B2:B2", r.String())
+
+	_, err = CellRange("A1:B2:C3")
+	assert.Error(err)
+	_, err = CellRange("A1:")
+	assert.Error(err)
+}
+
+func TestRoundTripNumbers(t *testing.T) {
+	forms := []func(v float64) string{
+		func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) },
+		func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) },
+		func(v float64) string { return strconv.FormatFloat(v, 'e', 12, 64) },
+		func(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) },
+	}
+	roundTrip := func(vals []float64, scale int8) bool {
+		sheet := NewSheet()
+		for i, v := range vals {
+			v = v * math.Pow(10, float64(scale%40))
+			addr := fmt.Sprintf("A%d", i+1)
+			sheet.SetContent(addr, forms[i%len(forms)](v))
+		}
+		start, _ := CellAddr("A1")
+		b := &strings.Builder{}
+		if err := sheet.WriteRange(start, sheet.MaxAddr(), b); err != nil {
+			t.Log(err)
+			return false
+		}
+		read := NewSheet()
+		r := strings.NewReader(b.String())
+		for {
+			if err := read.Read(r); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Log(err)
+				return false
+			}
+		}
+		for i := range vals {
+			addr := fmt.Sprintf("A%d", i+1)
+			// Numbers too large for a float64 are written as +Inf, which is read as text.
+			want, werr := sheet.ValueAt(addr)
+			got, err := read.ValueAt(addr)
+			if (err != nil) != (werr != nil) || got != want {
+				t.Logf("%s: want %v (%v), got %v (%v)", addr, want, werr, got, err)
+				return false
+			}
+			we, _ := sheet.EditAt(addr)
+			ge, _ := read.EditAt(addr)
+			if we != ge {
+				t.Logf("%s: want %q, got %q", addr, we, ge)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
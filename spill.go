@@ -0,0 +1,184 @@
+package sheet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrSpill is wrapped by the error of a formula whose array result can't spill into the cells
+// below and to the right of it, because some of them aren't blank or they are outside of the sheet.
+// Spreadsheets show these errors as #SPILL!. It can be detected with errors.Is on the errors
+// returned by (*Sheet).ValueAt.
+var ErrSpill = errors.New("#SPILL!")
+
+// An equation can evaluate to an array of values, as =A1:A10*2 or =SORT(A1:B10) do. Its cell shows
+// the first value, and the rest spill into the cells below and to the right of it, which show the
+// values in the same position of the array. Spilled cells can't be edited, but can be referenced
+// like any other cell: they are downstream of the cell whose equation they come from. If any of
+// the cells the array would spill into isn't blank, nothing is spilled and the equation's cell
+// shows an error wrapping ErrSpill until they are cleared.
+
+// setArray records rows, the array result of c's expression, to be spilled by respill. It returns
+// the value shown by c itself.
+func (c *Cell) setArray(rows [][]value) (value, error) {
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return value{}, fmt.Errorf("Result is an empty array")
+	}
+	if len(rows) > 1 || len(rows[0]) > 1 {
+		c.array = rows
+	}
+	return rows[0][0], nil
+}
+
+// spillValue returns the value of c's array result spilled into the cell at addr.
+func (c *Cell) spillValue(addr CellAddress) value {
+	i := int(addr.row - c.addr.row)
+	j := colIndex(addr.col) - colIndex(c.addr.col)
+	if i < 0 || i >= len(c.array) || j < 0 || j >= len(c.array[i]) {
+		return value{}
+	}
+	return c.array[i][j]
+}
+
+// spillArea returns the range c's array result spills into, including c itself.
+func (c *Cell) spillArea() (Range, error) {
+	height, width := len(c.array), len(c.array[0])
+	lastRow := uint64(c.addr.row) + uint64(height) - 1
+	lastColumn := colIndex(c.addr.col) + width - 1
+	if lastRow > math.MaxUint32 || lastColumn > colIndex(lastCol) {
+		return Range{}, fmt.Errorf("%w Array of %d rows and %d columns does not fit in the sheet", ErrSpill, height, width)
+	}
+	end := CellAddress{col: colName(lastColumn), row: uint32(lastRow)}
+	return NewRange(c.addr, end), nil
+}
+
+// spillBlocker returns the first cell in area that c's array result can't spill into, or nil if
+// there is none.
+func (c *Cell) spillBlocker(area Range) *Cell {
+	for _, u := range c.upstream {
+		if u != c && u.sheet == c.sheet && area.Contains(u.addr) {
+			// Spilling into a cell the equation references would make a cycle.
+			return u
+		}
+	}
+	for _, a := range area.addrs() {
+		t := c.sheet.cellAt(a)
+		if t != nil && t != c && t.cell_type != cell_transient && t.spillFrom != c {
+			return t
+		}
+	}
+	return nil
+}
+
+// respill updates the cells c spills its array result into after an evaluation of c, returning
+// the cells that were spilled into or cleared, along with the cells whose array results may now
+// spill into the cleared ones. These must be evaluated again, along with everything downstream of
+// them. If c's array result is blocked, c's error is set. Like relink, respill modifies the
+// dependency graph, so it must not be called for several cells at once.
+func (c *Cell) respill() []*Cell {
+	s := c.sheet
+	var want []CellAddress
+	if c.array == nil || c.expErr != nil {
+		delete(s.spills, c)
+	} else if area, err := c.spillArea(); err != nil {
+		delete(s.spills, c)
+		c.spillError(err)
+	} else {
+		s.spills[c] = area
+		if t := c.spillBlocker(area); t != nil {
+			c.spillError(fmt.Errorf("%w Spill range %s is blocked by %s", ErrSpill, area, t.addr))
+		} else {
+			want = area.addrs()[1:]
+		}
+	}
+	if len(want) == 0 && len(c.spilled) == 0 {
+		return nil
+	}
+
+	old := make(map[CellAddress]*Cell, len(c.spilled))
+	for _, t := range c.spilled {
+		old[t.addr] = t
+	}
+	var changed []*Cell
+	spilled := make([]*Cell, 0, len(want))
+	for _, a := range want {
+		if t, ok := old[a]; ok {
+			delete(old, a)
+			spilled = append(spilled, t)
+			continue
+		}
+		t := s.cellOrNewAt(a)
+		t.cell_type = cell_spill
+		t.spillFrom = c
+		t.upstream = []*Cell{c}
+		c.addDownstream(t)
+		spilled = append(spilled, t)
+		changed = append(changed, t)
+	}
+	c.spilled = spilled
+
+	cleared := make([]*Cell, 0, len(old))
+	for _, t := range old {
+		cleared = append(cleared, t)
+	}
+	sort.Slice(cleared, func(i, j int) bool { return cleared[i].addr.less(cleared[j].addr) })
+	for _, t := range cleared {
+		*t = Cell{sheet: s, addr: t.addr, cell_type: cell_transient, downstream: t.downstream, userFormat: t.userFormat}
+		c.removeDownstream(t)
+		t.deleteSelfIfNecessary()
+		changed = append(changed, t)
+		for _, b := range s.blockedSpills(t.addr) {
+			if b != c {
+				changed = append(changed, b)
+			}
+		}
+	}
+	return changed
+}
+
+// spillError sets the error of c, whose array result can't be spilled.
+func (c *Cell) spillError(err error) {
+	c.array = nil
+	c.expErr = err
+	c.content = "##ERROR"
+}
+
+// blockedSpills returns the cells whose array results are blocked from spilling into the cell at
+// addr, in row-major order.
+func (s *Sheet) blockedSpills(addr CellAddress) []*Cell {
+	var cells []*Cell
+	for c, area := range s.spills {
+		if c.addr != addr && area.Contains(addr) && errors.Is(c.expErr, ErrSpill) {
+			cells = append(cells, c)
+		}
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i].addr.less(cells[j].addr) })
+	return cells
+}
+
+// recalculateBlocked recalculates the cells whose array results were blocked from spilling into
+// the cell at addr, which has been cleared.
+func (s *Sheet) recalculateBlocked(addr CellAddress) {
+	for _, c := range s.blockedSpills(addr) {
+		c.Recalculate()
+	}
+}
+
+// refreshSpills brings the cells of a lazy sheet whose equations evaluated to arrays up to date,
+// since which cells they spill into is only known once they have been evaluated.
+func (s *Sheet) refreshSpills() {
+	if !s.lazy || s.refreshingSpills || len(s.spills) == 0 {
+		return
+	}
+	s.refreshingSpills = true
+	defer func() { s.refreshingSpills = false }()
+	cells := make([]*Cell, 0, len(s.spills))
+	for c := range s.spills {
+		cells = append(cells, c)
+	}
+	for _, c := range cells {
+		c.refresh()
+	}
+}
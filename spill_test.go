@@ -0,0 +1,268 @@
+package sheet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// contentBlock returns the content of the cells in rng, row by row.
+func contentBlock(sheet *Sheet, rng string) [][]string {
+	r, err := CellRange(rng)
+	if err != nil {
+		panic(err)
+	}
+	rows := make([][]string, r.height())
+	for i := range rows {
+		rows[i] = make([]string, r.width())
+		for j := range rows[i] {
+			rows[i][j], _ = sheet.contentAt(r.addrAt(i, j))
+		}
+	}
+	return rows
+}
+
+func TestArrayFunctions(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		expect  [][]string
+	}{
+		"range":           {content: "=A1:A5", expect: [][]string{{"Pear"}, {"apple"}, {"Fig"}, {"Apple"}, {"Fig"}}},
+		"arith":           {content: "=B1:B5*2", expect: [][]string{{"6"}, {"2"}, {"4"}, {"2"}, {"10"}}},
+		"arith/broadcast": {content: "=B1:B2+D1:E1", expect: [][]string{{"13", "23"}, {"11", "21"}}},
+		"arith/arrays":    {content: "=B1:B3-B1:B3", expect: [][]string{{"0"}, {"0"}, {"0"}}},
+		"neg":             {content: "=-B1:B2", expect: [][]string{{"-3"}, {"-1"}}},
+		"cat":             {content: `=A1:A2&"!"`, expect: [][]string{{"Pear!"}, {"apple!"}}},
+		"sum":             {content: "=SUM(B1:B5*2)", expect: [][]string{{"24"}}},
+		"sequence":        {content: "=SEQUENCE(2,3)", expect: [][]string{{"1", "2", "3"}, {"4", "5", "6"}}},
+		"sequence/step":   {content: "=SEQUENCE(3,1,10,0-5)", expect: [][]string{{"10"}, {"5"}, {"0"}}},
+		"sort":            {content: "=SORT(A1:B5)", expect: [][]string{{"apple", "1"}, {"Apple", "1"}, {"Fig", "2"}, {"Fig", "5"}, {"Pear", "3"}}},
+		"sort/desc":       {content: "=SORT(A1:B5,2,0-1)", expect: [][]string{{"Fig", "5"}, {"Pear", "3"}, {"Fig", "2"}, {"apple", "1"}, {"Apple", "1"}}},
+		"sort/column":     {content: "=SORT(D1:F2,2,1,TRUE)", expect: [][]string{{"30", "20", "10"}, {"1", "2", "3"}}},
+		"sort/mixed":      {content: "=SORT(C1:C5)", expect: [][]string{{"1"}, {"2"}, {"b"}, {"TRUE"}, {"0"}}},
+		"filter":          {content: "=FILTER(A1:A5,B1:B5-1)", expect: [][]string{{"Pear"}, {"Fig"}, {"Fig"}}},
+		"filter/compare":  {content: "=FILTER(A1:A5,B1:B5>1)", expect: [][]string{{"Pear"}, {"Fig"}, {"Fig"}}},
+		"filter/text":     {content: `=FILTER(A1:B5,A1:A5="fig")`, expect: [][]string{{"Fig", "2"}, {"Fig", "5"}}},
+		"compare":         {content: "=B1:B3>=2", expect: [][]string{{"TRUE"}, {"FALSE"}, {"TRUE"}}},
+		"filter/column":   {content: "=FILTER(D1:F2,D2:F2)", expect: [][]string{{"10", "20", "30"}, {"3", "2", "1"}}},
+		"filter/empty":    {content: `=FILTER(A1:A2,Z1:Z2,"none")`, expect: [][]string{{"none"}}},
+		"unique":          {content: "=UNIQUE(A1:A5)", expect: [][]string{{"Pear"}, {"apple"}, {"Fig"}}},
+		"unique/rows":     {content: "=UNIQUE(A1:B5)", expect: [][]string{{"Pear", "3"}, {"apple", "1"}, {"Fig", "2"}, {"Fig", "5"}}},
+		"unique/once":     {content: "=UNIQUE(A1:A5,FALSE,TRUE)", expect: [][]string{{"Pear"}}},
+		"unique/column":   {content: "=UNIQUE(B1:B5,TRUE)", expect: [][]string{{"3"}, {"1"}, {"2"}, {"1"}, {"5"}}},
+		"index/row":       {content: "=INDEX(A1:B5,2,0)", expect: [][]string{{"apple", "1"}}},
+		"nested":          {content: "=SORT(UNIQUE(B1:B5),1,0-1)", expect: [][]string{{"5"}, {"3"}, {"2"}, {"1"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			for i, row := range [][]string{
+				{"Pear", "3", "1", "10", "20", "30"},
+				{"apple", "1", "=TRUE", "3", "2", "1"},
+				{"Fig", "2", "", "", "", ""},
+				{"Apple", "1", "b", "", "", ""},
+				{"Fig", "5", "2", "", "", ""},
+			} {
+				for j, v := range row {
+					sheet.SetContent(CellAddress{colName(j + 1), uint32(i + 1)}.String(), v)
+				}
+			}
+			assert.NoError(sheet.SetContent("H1", tt.content))
+			end := CellAddress{colName(8 + len(tt.expect[0]) - 1), uint32(len(tt.expect))}
+			assert.Equal(tt.expect, contentBlock(sheet, "H1:"+end.String()))
+			// Nothing spills past the end of the array.
+			below := CellAddress{"H", end.row + 1}
+			got, _ := sheet.contentAt(below)
+			assert.Equal("", got)
+		})
+	}
+}
+
+func TestArrayErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"arith/size":      "=A1:A3+A1:A2",
+		"sequence/zero":   "=SEQUENCE(0)",
+		"sequence/large":  "=SEQUENCE(100000,100)",
+		"sort/index":      "=SORT(A1:A3,2)",
+		"sort/order":      "=SORT(A1:A3,1,2)",
+		"filter/size":     "=FILTER(A1:A3,A1:A2)",
+		"filter/none":     "=FILTER(A1:A3,Z1:Z3)",
+		"filter/text":     `=FILTER(A1:A2,B1:B2)`,
+		"unique/none":     "=UNIQUE(C1:C2,FALSE,TRUE)",
+		"scalar/function": "=SQRT(A1:A3)",
+	} {
+		t.Run(name, func(t *testing.T) {
+			sheet := NewSheet()
+			sheet.SetContent("A1", "1")
+			sheet.SetContent("A2", "2")
+			sheet.SetContent("A3", "3")
+			sheet.SetContent("B1", "x")
+			sheet.SetContent("C1", "7")
+			sheet.SetContent("C2", "7")
+			assert.NoError(t, sheet.SetContent("Z9", content))
+			_, err := sheet.ValueAt("Z9")
+			assert.Error(t, err)
+			got, _ := sheet.ContentAt("Z10")
+			assert.Equal(t, "", got)
+		})
+	}
+}
+
+func TestSpill(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			setup(sheet)
+			sheet.SetContent("A1", "3")
+			sheet.SetContent("B1", "=SEQUENCE(A1)*10")
+			sheet.SetContent("C1", "=B3+1")
+			sheet.SetContent("C2", "=B5+1")
+			sheet.SetContent("C3", "=SUM(B1:B3)")
+			assert.Equal([][]string{{"10", "31"}, {"20", "1"}, {"30", "60"}, {"", ""}, {"", ""}}, contentBlock(sheet, "B1:C5"))
+
+			// Growing spills into more cells, and cells referencing them are recalculated.
+			sheet.SetContent("A1", "5")
+			assert.Equal([][]string{{"10", "31"}, {"20", "51"}, {"30", "60"}, {"40", ""}, {"50", ""}}, contentBlock(sheet, "B1:C5"))
+
+			// Shrinking clears the cells no longer spilled into.
+			sheet.SetContent("A1", "2")
+			assert.Equal([][]string{{"10", "1"}, {"20", "1"}, {"", "30"}, {"", ""}, {"", ""}}, contentBlock(sheet, "B1:C5"))
+			assert.Nil(sheet.cellAt(CellAddress{"B", 4}))
+
+			// Replacing the formula clears everything it spilled.
+			sheet.SetContent("A1", "4")
+			sheet.SetContent("B1", "7")
+			assert.Equal([][]string{{"7", "1"}, {"", "1"}, {"", "7"}, {"", ""}}, contentBlock(sheet, "B1:C4"))
+		})
+	}
+}
+
+func TestSpillBlocked(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			setup(sheet)
+			sheet.SetContent("A3", "note")
+			sheet.SetContent("A1", "=SEQUENCE(3)")
+			sheet.SetContent("B1", "=A1*2")
+			_, err := sheet.ValueAt("A1")
+			assert.ErrorIs(err, ErrSpill)
+			_, err = sheet.ValueAt("B1")
+			assert.ErrorIs(err, ErrSpill)
+			got, _ := sheet.ContentAt("A2")
+			assert.Equal("", got)
+
+			sheet.SetContent("A3", "")
+			assert.Equal([][]string{{"1", "2"}, {"2", ""}, {"3", ""}}, contentBlock(sheet, "A1:B3"))
+
+			// Spilled cells can't be edited.
+			assert.Error(sheet.SetContent("A2", "5"))
+			assert.NoError(sheet.SetContent("A2", ""))
+			edit, err := sheet.EditAt("A2")
+			assert.NoError(err)
+			assert.Equal("", edit)
+
+			// One array blocks another until it shrinks.
+			sheet.SetContent("E1", "2")
+			sheet.SetContent("C1", "=SEQUENCE(E1,2)")
+			sheet.SetContent("C3", "=SEQUENCE(1,2,5)")
+			_, err = sheet.ValueAt("C1")
+			assert.NoError(err)
+			_, err = sheet.ValueAt("C3")
+			assert.NoError(err)
+			sheet.SetContent("E1", "3")
+			_, err = sheet.ValueAt("C1")
+			assert.ErrorIs(err, ErrSpill)
+			assert.Equal([][]string{{"5", "6"}}, contentBlock(sheet, "C3:D3"))
+			sheet.SetContent("C3", "")
+			assert.Equal([][]string{{"1", "2"}, {"3", "4"}, {"5", "6"}}, contentBlock(sheet, "C1:D3"))
+		})
+	}
+}
+
+func TestSpillCycle(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			setup(sheet)
+			sheet.SetContent("A1", "x")
+			sheet.SetContent("B1", "y")
+			sheet.SetContent("F1", `=D1&"!"`)
+			sheet.SetContent("C1", "=A1:B1")
+			assert.Equal([][]string{{"x", "y", "", "y!"}}, contentBlock(sheet, "C1:F1"))
+
+			// An array formula referring to its own cell clears what it spilled.
+			sheet.SetContent("C1", "=A1:C1")
+			sheet.SetContent("C3", "=B3:C3")
+			assert.Equal([][]string{
+				{"C1: Cyclical equations detected.", "", "", "!"},
+				{"", "", "", ""},
+				{"C3: Cyclical equations detected.", "", "", ""},
+			}, contentBlock(sheet, "C1:F3"))
+			assert.Nil(sheet.cellAt(CellAddress{"D", 3}))
+
+			sheet.SetContent("C1", "=A1:B1")
+			assert.Equal([][]string{{"x", "y", "", "y!"}}, contentBlock(sheet, "C1:F1"))
+		})
+	}
+}
+
+func TestSpillEdges(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("ZY1", "=SEQUENCE(1,3)")
+	_, err := sheet.ValueAt("ZY1")
+	assert.ErrorIs(err, ErrSpill)
+
+	// Spilling into a referenced cell would make a cycle.
+	sheet.SetContent("A1", "=SEQUENCE(3)+A3")
+	_, err = sheet.ValueAt("A1")
+	assert.ErrorIs(err, ErrSpill)
+
+	var updated []string
+	sheet.Subscribe(func(ev CellEvent) { updated = append(updated, ev.Addr.String()) })
+	sheet.SetContent("B1", "=SEQUENCE(2)")
+	assert.ElementsMatch([]string{"B1", "B2"}, updated)
+	updated = nil
+	sheet.SetContent("B1", "")
+	assert.ElementsMatch([]string{"B1", "B2"}, updated)
+}
+
+func TestSpillWriteRange(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetContent("A1", "=SEQUENCE(3)")
+	sheet.SetContent("B2", "=A3")
+	b := &strings.Builder{}
+	assert.NoError(sheet.WriteRange(CellAddress{"A", 1}, sheet.MaxAddr(), b))
+	assert.Equal("A1 12 =SEQUENCE(3)\nB2 3 =A3\n", b.String())
+
+	read := NewSheet()
+	r := strings.NewReader(b.String())
+	assert.NoError(read.Read(r))
+	assert.NoError(read.Read(r))
+	got, err := read.ContentAt("B2")
+	assert.NoError(err)
+	assert.Equal("3", got)
+
+	var dot strings.Builder
+	assert.NoError(sheet.WriteDOT(&dot, NewRange(CellAddress{"A", 1}, CellAddress{"B", 3})))
+	assert.Contains(dot.String(), `"A1" -> "A3";`)
+	assert.Contains(dot.String(), `"A3" -> "B2";`)
+}
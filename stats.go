@@ -0,0 +1,560 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// isReference returns true if e refers to cells, rather than computing a value.
+func isReference(e *Expression) bool {
+	switch e.op {
+	case ID, RANGE:
+		return true
+	case FUNC:
+		f, ok := functions[e.val]
+		return ok && f.ref != nil
+	}
+	return false
+}
+
+// numberArgs evaluates the arguments of the aggregate function name and returns the numbers among
+// them, checking their number as for evalArgs. Text, logical values and blanks in referenced cells
+// are skipped, as users expect when summing a column with a heading. Values given directly are
+// converted, so that SUM("3", TRUE) is 4, and text that isn't a number is an error.
+func numberArgs(s *Sheet, name string, args []*Expression, min, max int) ([]value, error) {
+	vals, err := evalValueArgs(s, name, args, min, max)
+	if err != nil {
+		return nil, err
+	}
+	var nums []value
+	for i, v := range vals {
+		if isReference(args[i]) || v.kind == valArray {
+			for _, row := range v.rows() {
+				for _, v := range row {
+					if v.kind == valNumber {
+						nums = append(nums, v)
+					}
+				}
+			}
+			continue
+		}
+		if v.kind == valNumber {
+			nums = append(nums, v)
+			continue
+		}
+		f, err := v.toNumber()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		nums = append(nums, numberValue(f))
+	}
+	return nums, nil
+}
+
+// floatArgs is like numberArgs, but returns the numbers as float64.
+func floatArgs(s *Sheet, name string, args []*Expression, min, max int) ([]float64, error) {
+	nums, err := numberArgs(s, name, args, min, max)
+	if err != nil {
+		return nil, err
+	}
+	fs := make([]float64, len(nums))
+	for i := range nums {
+		fs[i] = nums[i].num
+	}
+	return fs, nil
+}
+
+// sumValues returns the sum of nums, computed exactly in a sheet in decimal mode.
+func (s *Sheet) sumValues(nums []value) (value, error) {
+	if !s.decimal {
+		sum := 0.0
+		for _, v := range nums {
+			sum += v.num
+		}
+		return numberValue(sum), nil
+	}
+	sum := new(big.Rat)
+	for _, v := range nums {
+		r, err := v.toRat()
+		if err != nil {
+			return value{}, err
+		}
+		sum.Add(sum, r)
+	}
+	return ratValue(sum), nil
+}
+
+// SUM(values...) returns the sum of its arguments.
+func fnSum(s *Sheet, args []*Expression) (value, error) {
+	nums, err := numberArgs(s, "SUM", args, 1, -1)
+	if err != nil {
+		return value{}, err
+	}
+	return s.sumValues(nums)
+}
+
+// AVERAGE(values...) returns the arithmetic mean of its arguments.
+func fnAverage(s *Sheet, args []*Expression) (value, error) {
+	nums, err := numberArgs(s, "AVERAGE", args, 1, -1)
+	if err != nil {
+		return value{}, err
+	}
+	return s.average("AVERAGE", nums)
+}
+
+// average returns the mean of nums for the function name, computed exactly in a sheet in decimal
+// mode.
+func (s *Sheet) average(name string, nums []value) (value, error) {
+	if len(nums) == 0 {
+		return value{}, fmt.Errorf("%s: no numbers to average", name)
+	}
+	sum, err := s.sumValues(nums)
+	if err != nil {
+		return value{}, err
+	}
+	if sum.rat != nil {
+		return ratValue(new(big.Rat).Quo(sum.rat, big.NewRat(int64(len(nums)), 1))), nil
+	}
+	return numberValue(sum.num / float64(len(nums))), nil
+}
+
+// COUNT(values...) returns the number of numbers among its arguments.
+func fnCount(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "COUNT", args, 1, -1)
+	if err != nil {
+		return value{}, err
+	}
+	n := 0
+	for _, v := range vals {
+		for _, row := range v.rows() {
+			for _, v := range row {
+				if v.kind == valNumber {
+					n++
+				}
+			}
+		}
+	}
+	return numberValue(float64(n)), nil
+}
+
+// extremum returns MIN or MAX, which return the smallest or largest of their arguments, or 0 if
+// there are no numbers among them.
+func extremum(name string, less bool) func(s *Sheet, args []*Expression) (value, error) {
+	return func(s *Sheet, args []*Expression) (value, error) {
+		nums, err := numberArgs(s, name, args, 1, -1)
+		if err != nil {
+			return value{}, err
+		}
+		if len(nums) == 0 {
+			return numberValue(0), nil
+		}
+		best := nums[0]
+		for _, v := range nums[1:] {
+			cmp, _ := compareValues(v, best)
+			if less && cmp < 0 || !less && cmp > 0 {
+				best = v
+			}
+		}
+		return best, nil
+	}
+}
+
+// MEDIAN(values...) returns the middle value of its arguments, or the mean of the two middle
+// values if there is an even number of them.
+func fnMedian(s *Sheet, args []*Expression) (value, error) {
+	fs, err := floatArgs(s, "MEDIAN", args, 1, -1)
+	if err != nil {
+		return value{}, err
+	}
+	if len(fs) == 0 {
+		return value{}, fmt.Errorf("MEDIAN: no numbers")
+	}
+	sort.Float64s(fs)
+	n := len(fs)
+	if n%2 == 1 {
+		return numberValue(fs[n/2]), nil
+	}
+	return numberValue((fs[n/2-1] + fs[n/2]) / 2), nil
+}
+
+// MODE(values...) returns the most frequent value among its arguments. Of several values that are
+// equally frequent, the one that appears first is returned. It is an error if no value appears
+// more than once.
+func fnMode(s *Sheet, args []*Expression) (value, error) {
+	fs, err := floatArgs(s, "MODE", args, 1, -1)
+	if err != nil {
+		return value{}, err
+	}
+	counts := make(map[float64]int)
+	for _, f := range fs {
+		counts[f]++
+	}
+	best := 0
+	for i, f := range fs {
+		if counts[f] > counts[fs[best]] {
+			best = i
+		}
+	}
+	if len(fs) == 0 || counts[fs[best]] < 2 {
+		return value{}, fmt.Errorf("MODE: no value appears more than once")
+	}
+	return numberValue(fs[best]), nil
+}
+
+// variance returns the variance of fs, either of fs as a sample of a larger population or of fs
+// as the whole population.
+func variance(name string, fs []float64, sample bool) (float64, error) {
+	n := float64(len(fs))
+	if sample && n < 2 || n < 1 {
+		return 0, fmt.Errorf("%s: not enough numbers", name)
+	}
+	mean := 0.0
+	for _, f := range fs {
+		mean += f
+	}
+	mean /= n
+	ss := 0.0
+	for _, f := range fs {
+		ss += (f - mean) * (f - mean)
+	}
+	if sample {
+		return ss / (n - 1), nil
+	}
+	return ss / n, nil
+}
+
+// varianceFunction returns VAR, VARP, STDEV or STDEVP. VAR and STDEV treat their arguments as a
+// sample of a population; VARP and STDEVP treat them as the whole population.
+func varianceFunction(name string, sample, stdev bool) func(s *Sheet, args []*Expression) (value, error) {
+	return func(s *Sheet, args []*Expression) (value, error) {
+		fs, err := floatArgs(s, name, args, 1, -1)
+		if err != nil {
+			return value{}, err
+		}
+		v, err := variance(name, fs, sample)
+		if err != nil {
+			return value{}, err
+		}
+		if stdev {
+			v = math.Sqrt(v)
+		}
+		return numberValue(v), nil
+	}
+}
+
+// percentile returns the k-th percentile of fs, 0 <= k <= 1, interpolating between the closest
+// ranks.
+func percentile(name string, fs []float64, k float64) (float64, error) {
+	if len(fs) == 0 {
+		return 0, fmt.Errorf("%s: no numbers", name)
+	}
+	if k < 0 || k > 1 {
+		return 0, fmt.Errorf("%s: %v is not between 0 and 1", name, k)
+	}
+	sort.Float64s(fs)
+	rank := k * float64(len(fs)-1)
+	lo := math.Floor(rank)
+	if int(lo) == len(fs)-1 {
+		return fs[len(fs)-1], nil
+	}
+	return fs[int(lo)] + (rank-lo)*(fs[int(lo)+1]-fs[int(lo)]), nil
+}
+
+// PERCENTILE(values, k) returns the k-th percentile of values, where k is between 0 and 1.
+func fnPercentile(s *Sheet, args []*Expression) (value, error) {
+	if err := checkArgCount("PERCENTILE", args, 2, 2); err != nil {
+		return value{}, err
+	}
+	fs, err := floatArgs(s, "PERCENTILE", args[:1], 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	k, err := args[1].Eval(s)
+	if err != nil {
+		return value{}, err
+	}
+	p, err := percentile("PERCENTILE", fs, k)
+	if err != nil {
+		return value{}, err
+	}
+	return numberValue(p), nil
+}
+
+// QUARTILE(values, q) returns the q-th quartile of values, where q is 0 (the minimum), 1, 2 (the
+// median), 3 or 4 (the maximum).
+func fnQuartile(s *Sheet, args []*Expression) (value, error) {
+	if err := checkArgCount("QUARTILE", args, 2, 2); err != nil {
+		return value{}, err
+	}
+	fs, err := floatArgs(s, "QUARTILE", args[:1], 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	q, err := args[1].Eval(s)
+	if err != nil {
+		return value{}, err
+	}
+	q = math.Trunc(q)
+	if q < 0 || q > 4 {
+		return value{}, fmt.Errorf("QUARTILE: %v is not between 0 and 4", q)
+	}
+	p, err := percentile("QUARTILE", fs, q/4)
+	if err != nil {
+		return value{}, err
+	}
+	return numberValue(p), nil
+}
+
+// CORREL(xs, ys) returns the Pearson correlation coefficient of the pairs of values in xs and ys,
+// which must be the same size. Pairs where either value isn't a number are skipped.
+func fnCorrel(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "CORREL", args, 2, 2)
+	if err != nil {
+		return value{}, err
+	}
+	xs, ys := flatten(vals[0]), flatten(vals[1])
+	if len(xs) != len(ys) {
+		return value{}, fmt.Errorf("CORREL: ranges are different sizes")
+	}
+	var sx, sy, sxx, syy, sxy, n float64
+	for i := range xs {
+		if xs[i].kind != valNumber || ys[i].kind != valNumber {
+			continue
+		}
+		x, y := xs[i].num, ys[i].num
+		sx += x
+		sy += y
+		sxx += x * x
+		syy += y * y
+		sxy += x * y
+		n++
+	}
+	den := math.Sqrt((n*sxx - sx*sx) * (n*syy - sy*sy))
+	if n < 2 || den == 0 {
+		return value{}, fmt.Errorf("CORREL: correlation is undefined")
+	}
+	return numberValue((n*sxy - sx*sy) / den), nil
+}
+
+// flatten returns the values of v row by row.
+func flatten(v value) []value {
+	var vals []value
+	for _, row := range v.rows() {
+		vals = append(vals, row...)
+	}
+	return vals
+}
+
+// criterion is a condition on a value, as used by SUMIF and COUNTIF. It is written as a string
+// with an optional comparison operator, as in ">10", "<>done", "=" (blank) or "app*" (wildcards
+// as understood by wildcardMatch), or given as a value to match exactly.
+type criterion struct {
+	// op is one of "=", "<>", "<", "<=", ">" or ">=".
+	op string
+	// operand is the value compared with. If it is text, comparisons ignore case and wildcards
+	// are understood by "=" and "<>", using pattern.
+	operand value
+	pattern *regexp.Regexp
+}
+
+var criterionOps = []string{"<=", ">=", "<>", "<", ">", "="}
+
+// parseCriterion returns the criterion described by v.
+func parseCriterion(v value) (criterion, error) {
+	v, err := v.scalar()
+	if err != nil {
+		return criterion{}, err
+	}
+	if v.kind != valString {
+		return criterion{op: "=", operand: v}, nil
+	}
+	c := criterion{op: "="}
+	text := v.str
+	for _, op := range criterionOps {
+		if strings.HasPrefix(text, op) {
+			c.op = op
+			text = text[len(op):]
+			break
+		}
+	}
+	if f, ok := parseNumber(strings.TrimSpace(text)); ok {
+		c.operand = numberValue(f)
+	} else if f, _, ok := parseDateTime(strings.TrimSpace(text)); ok {
+		c.operand = numberValue(f)
+	} else if b := strings.ToUpper(text); b == "TRUE" || b == "FALSE" {
+		c.operand = boolValue(b == "TRUE")
+	} else if text != "" {
+		c.operand = stringValue(text)
+		c.pattern = wildcardRegexp(text)
+	}
+	return c, nil
+}
+
+// match returns true if v satisfies c.
+func (c criterion) match(v value) bool {
+	if c.operand.kind == valBlank {
+		// "=" matches blank cells and "<>" matches any other cell.
+		blank := v.kind == valBlank || v.kind == valString && v.str == ""
+		switch c.op {
+		case "=":
+			return blank
+		case "<>":
+			return !blank
+		}
+		return false
+	}
+	if c.operand.kind == valString && (c.op == "=" || c.op == "<>") {
+		eq := v.kind == valString && c.pattern.MatchString(v.str)
+		return eq == (c.op == "=")
+	}
+	cmp, ok := compareValues(v, c.operand)
+	if !ok {
+		return c.op == "<>"
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "<>":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// conditionalArgs evaluates the criteria ranges and criteria in args, which alternate, and returns
+// which of the positions in the ranges meet all of the criteria. Every range must have size
+// values.
+func conditionalArgs(s *Sheet, name string, args []*Expression, size int) ([]bool, error) {
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("%s expects pairs of ranges and criteria", name)
+	}
+	vals, err := evalValueArgs(s, name, args, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	ok := make([]bool, size)
+	for i := range ok {
+		ok[i] = true
+	}
+	for i := 0; i < len(vals); i += 2 {
+		rng := flatten(vals[i])
+		if len(rng) != size {
+			return nil, fmt.Errorf("%s: ranges are different sizes", name)
+		}
+		c, err := parseCriterion(vals[i+1])
+		if err != nil {
+			return nil, err
+		}
+		for j := range rng {
+			ok[j] = ok[j] && c.match(rng[j])
+		}
+	}
+	return ok, nil
+}
+
+// selectNumbers returns the numbers in vals at the positions where ok is true.
+func selectNumbers(vals []value, ok []bool) []value {
+	var nums []value
+	for i, v := range vals {
+		if ok[i] && v.kind == valNumber {
+			nums = append(nums, v)
+		}
+	}
+	return nums
+}
+
+// conditionalFunction returns SUMIF or AVERAGEIF, as in SUMIF(range, criterion, [values]), which
+// sum or average the values (or the numbers in range, if values is omitted) at the positions where
+// range meets criterion.
+func conditionalFunction(name string, average bool) func(s *Sheet, args []*Expression) (value, error) {
+	return func(s *Sheet, args []*Expression) (value, error) {
+		if err := checkArgCount(name, args, 2, 3); err != nil {
+			return value{}, err
+		}
+		target := args[0]
+		if len(args) > 2 {
+			target = args[2]
+		}
+		v, err := target.evalValue(s)
+		if err != nil {
+			return value{}, err
+		}
+		vals := flatten(v)
+		ok, err := conditionalArgs(s, name, args[:2], len(vals))
+		if err != nil {
+			return value{}, err
+		}
+		if average {
+			return s.average(name, selectNumbers(vals, ok))
+		}
+		return s.sumValues(selectNumbers(vals, ok))
+	}
+}
+
+// multiConditionalFunction returns SUMIFS or AVERAGEIFS, as in SUMIFS(values, range1, criterion1,
+// range2, criterion2, ...), which sum or average the values at the positions where every range
+// meets its criterion.
+func multiConditionalFunction(name string, average bool) func(s *Sheet, args []*Expression) (value, error) {
+	return func(s *Sheet, args []*Expression) (value, error) {
+		if err := checkArgCount(name, args, 3, -1); err != nil {
+			return value{}, err
+		}
+		v, err := args[0].evalValue(s)
+		if err != nil {
+			return value{}, err
+		}
+		vals := flatten(v)
+		ok, err := conditionalArgs(s, name, args[1:], len(vals))
+		if err != nil {
+			return value{}, err
+		}
+		if average {
+			return s.average(name, selectNumbers(vals, ok))
+		}
+		return s.sumValues(selectNumbers(vals, ok))
+	}
+}
+
+// COUNTIF(range, criterion) returns the number of values in range that meet criterion.
+func fnCountIf(s *Sheet, args []*Expression) (value, error) {
+	if err := checkArgCount("COUNTIF", args, 2, 2); err != nil {
+		return value{}, err
+	}
+	return countIfs(s, "COUNTIF", args)
+}
+
+// COUNTIFS(range1, criterion1, range2, criterion2, ...) returns the number of positions at which
+// every range meets its criterion.
+func fnCountIfs(s *Sheet, args []*Expression) (value, error) {
+	if err := checkArgCount("COUNTIFS", args, 2, -1); err != nil {
+		return value{}, err
+	}
+	return countIfs(s, "COUNTIFS", args)
+}
+
+func countIfs(s *Sheet, name string, args []*Expression) (value, error) {
+	v, err := args[0].evalValue(s)
+	if err != nil {
+		return value{}, err
+	}
+	ok, err := conditionalArgs(s, name, args, len(flatten(v)))
+	if err != nil {
+		return value{}, err
+	}
+	n := 0
+	for _, b := range ok {
+		if b {
+			n++
+		}
+	}
+	return numberValue(float64(n)), nil
+}
@@ -0,0 +1,130 @@
+package sheet
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// metrics is a table of regions, products, units and revenue with a heading row, a blank row and
+// a note in the units column.
+var metrics = map[string]string{
+	"A1": "Region", "B1": "Product", "C1": "Units", "D1": "Revenue",
+	"A2": "North", "B2": "Apple", "C2": "10", "D2": "100",
+	"A3": "South", "B3": "Apricot", "C3": "25", "D3": "250",
+	"A4": "North", "B4": "Banana", "C4": "5", "D4": "40",
+	"A5": "East", "B5": "Apple", "C5": "n/a", "D5": "0",
+	"A7": "South", "B7": "Cherry", "C7": "40", "D7": "360",
+	"A8": "North", "B8": "apple", "C8": "10", "D8": "90",
+}
+
+func TestStatisticalFunctions(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		expect  string
+	}{
+		"sum":               {content: "=SUM(C1:C8)", expect: "90"},
+		"sum/args":          {content: `=SUM(C2,C4,"3",TRUE,1.5)`, expect: "20.5"},
+		"sum/blank":         {content: "=SUM(Z1:Z5)", expect: "0"},
+		"average":           {content: "=AVERAGE(C1:C8)", expect: "18"},
+		"count":             {content: "=COUNT(A1:D8)", expect: "11"},
+		"min":               {content: "=MIN(C1:C8)", expect: "5"},
+		"max":               {content: "=MAX(C1:C8,D2)", expect: "100"},
+		"median":            {content: "=MEDIAN(C1:C8)", expect: "10"},
+		"median/even":       {content: "=MEDIAN(C2:C4,C7)", expect: "17.5"},
+		"mode":              {content: "=MODE(C1:C8)", expect: "10"},
+		"mode/first":        {content: "=MODE(1,2,2,1)", expect: "1"},
+		"var":               {content: "=VAR(2,4,4,4,5,5,7,9)", expect: "4.57142857142857"},
+		"varp":              {content: "=VARP(2,4,4,4,5,5,7,9)", expect: "4"},
+		"stdevp":            {content: "=STDEVP(2,4,4,4,5,5,7,9)", expect: "2"},
+		"stdev":             {content: "=ROUND(STDEV(C1:C8),6)", expect: "14.40486"},
+		"percentile":        {content: "=PERCENTILE(C1:C8,0.25)", expect: "10"},
+		"percentile/interp": {content: "=PERCENTILE(D2:D8,0.9)", expect: "305"},
+		"quartile/min":      {content: "=QUARTILE(C1:C8,0)", expect: "5"},
+		"quartile/median":   {content: "=QUARTILE(C1:C8,2)", expect: "10"},
+		"quartile/max":      {content: "=QUARTILE(C1:C8,4)", expect: "40"},
+		"correl":            {content: "=ROUND(CORREL(C1:C8,D1:D8),6)", expect: "0.996179"},
+		"correl/perfect":    {content: "=CORREL(C2:C4,C2:C4)", expect: "1"},
+		"sumif":             {content: `=SUMIF(A1:A8,"North",D1:D8)`, expect: "230"},
+		"sumif/self":        {content: `=SUMIF(C1:C8,">10")`, expect: "65"},
+		"sumif/number":      {content: `=SUMIF(C1:C8,10,D1:D8)`, expect: "190"},
+		"sumif/wildcard":    {content: `=SUMIF(B1:B8,"ap*",D1:D8)`, expect: "440"},
+		"sumif/single":      {content: `=SUMIF(B1:B8,"?pple",C1:C8)`, expect: "20"},
+		"sumif/escape":      {content: `=SUMIF(B1:B8,"~*",D1:D8)`, expect: "0"},
+		"countif":           {content: `=COUNTIF(A1:A8,"<>North")`, expect: "5"},
+		"countif/blank":     {content: `=COUNTIF(A1:A8,"")`, expect: "1"},
+		"countif/nonblank":  {content: `=COUNTIF(A1:A8,"<>")`, expect: "7"},
+		"countif/le":        {content: `=COUNTIF(C1:C8,"<=10")`, expect: "3"},
+		"countif/text":      {content: `=COUNTIF(C1:C8,"n/a")`, expect: "1"},
+		"averageif":         {content: `=AVERAGEIF(A1:A8,"South",C1:C8)`, expect: "32.5"},
+		"averageif/ge":      {content: `=AVERAGEIF(D1:D8,">=100")`, expect: "236.666666666667"},
+		"sumifs":            {content: `=SUMIFS(D1:D8,A1:A8,"North",C1:C8,">5")`, expect: "190"},
+		"countifs":          {content: `=COUNTIFS(A1:A8,"North",B1:B8,"apple")`, expect: "2"},
+		"averageifs":        {content: `=AVERAGEIFS(C1:C8,B1:B8,"A*",D1:D8,">0")`, expect: "15"},
+		"countif/date":      {content: `=COUNTIF(E1:E3,">2026-06-01")`, expect: "2"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sheet := NewSheet()
+			for addr, content := range metrics {
+				assert.NoError(sheet.SetContent(addr, content))
+			}
+			sheet.SetContent("E1", "2026-01-15")
+			sheet.SetContent("E2", "2026-07-01")
+			sheet.SetContent("E3", "2026-10-16")
+			assert.NoError(sheet.SetContent("Z9", tt.content))
+			got, err := sheet.ContentAt("Z9")
+			assert.NoError(err)
+			assert.Equal(tt.expect, got)
+		})
+	}
+}
+
+func TestStatisticalErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"sum/text":         `=SUM("abc")`,
+		"average/empty":    `=AVERAGE(Z1:Z3)`,
+		"median/empty":     `=MEDIAN(A1:B8)`,
+		"mode/unique":      `=MODE(1,2,3)`,
+		"stdev/one":        `=STDEV(5)`,
+		"percentile/range": `=PERCENTILE(C1:C8,1.5)`,
+		"quartile/range":   `=QUARTILE(C1:C8,5)`,
+		"correl/size":      `=CORREL(C1:C8,D1:D7)`,
+		"correl/constant":  `=CORREL(C2:C3,C4:C5)`,
+		"sumif/size":       `=SUMIF(A1:A8,"North",D1:D7)`,
+		"countifs/pairs":   `=COUNTIFS(A1:A8,"North",B1:B8)`,
+		"averageif/none":   `=AVERAGEIF(A1:A8,"West",C1:C8)`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			sheet := NewSheet()
+			for addr, content := range metrics {
+				assert.NoError(t, sheet.SetContent(addr, content))
+			}
+			assert.NoError(t, sheet.SetContent("Z9", content))
+			_, err := sheet.ValueAt("Z9")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSumDecimal(t *testing.T) {
+	assert := assert.New(t)
+	sheet := NewSheet()
+	sheet.SetDecimal(true)
+	sheet.SetContent("A1", "0.1")
+	sheet.SetContent("B1", "0.2")
+	sheet.SetContent("C1", "0.3")
+	sheet.SetContent("D1", "=SUM(A1:C1)")
+	sheet.SetContent("D2", `=SUMIF(A1:C1,">0.1")`)
+	sheet.SetContent("D3", "=AVERAGE(A1:C1)")
+	for addr, want := range map[string]*big.Rat{
+		"D1": big.NewRat(6, 10),
+		"D2": big.NewRat(5, 10),
+		"D3": big.NewRat(2, 10),
+	} {
+		r, err := sheet.ExactValueAt(addr)
+		assert.NoError(err)
+		assert.Equal(0, want.Cmp(r), addr)
+	}
+}
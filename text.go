@@ -0,0 +1,309 @@
+package sheet
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Text functions count characters as Unicode code points, so LEN("héllo") is 5 and positions
+// passed to MID or returned by FIND never fall in the middle of a character.
+
+// textArgs evaluates the arguments of the function name as text, checking their number as for
+// evalArgs.
+func textArgs(s *Sheet, name string, args []*Expression, min, max int) ([]string, error) {
+	vals, err := evalValueArgs(s, name, args, min, max)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(vals))
+	for i := range vals {
+		strs[i] = vals[i].toString()
+	}
+	return strs, nil
+}
+
+// countArg converts v to a whole number of characters for the function name. Counts above max,
+// the most characters there are to count, are taken as max.
+func countArg(name string, v value, max int) (int, error) {
+	f, err := v.toNumber()
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 {
+		return 0, fmt.Errorf("%s: %v is negative", name, f)
+	}
+	if f > float64(max) {
+		return max, nil
+	}
+	return int(f), nil
+}
+
+// positionArg converts v to a 1-based character position for the function name. Positions above
+// max, which is past the end of the text, are taken as max.
+func positionArg(name string, v value, max int) (int, error) {
+	f, err := v.toNumber()
+	if err != nil {
+		return 0, err
+	}
+	if f < 1 {
+		return 0, fmt.Errorf("%s: position %v is less than 1", name, f)
+	}
+	if f > float64(max) {
+		return max, nil
+	}
+	return int(f), nil
+}
+
+// LEN(text) returns the number of characters in text.
+func fnLen(s *Sheet, args []*Expression) (value, error) {
+	strs, err := textArgs(s, "LEN", args, 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	return numberValue(float64(utf8.RuneCountInString(strs[0]))), nil
+}
+
+// LEFT(text, [n]) returns the first n characters of text, or the first character if n is omitted.
+func fnLeft(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "LEFT", args, 1, 2)
+	if err != nil {
+		return value{}, err
+	}
+	r := []rune(vals[0].toString())
+	n := 1
+	if len(vals) > 1 {
+		if n, err = countArg("LEFT", vals[1], len(r)); err != nil {
+			return value{}, err
+		}
+	}
+	if n > len(r) {
+		n = len(r)
+	}
+	return stringValue(string(r[:n])), nil
+}
+
+// RIGHT(text, [n]) returns the last n characters of text, or the last character if n is omitted.
+func fnRight(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "RIGHT", args, 1, 2)
+	if err != nil {
+		return value{}, err
+	}
+	r := []rune(vals[0].toString())
+	n := 1
+	if len(vals) > 1 {
+		if n, err = countArg("RIGHT", vals[1], len(r)); err != nil {
+			return value{}, err
+		}
+	}
+	if n > len(r) {
+		n = len(r)
+	}
+	return stringValue(string(r[len(r)-n:])), nil
+}
+
+// MID(text, start, n) returns n characters of text, beginning with the character at position
+// start.
+func fnMid(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "MID", args, 3, 3)
+	if err != nil {
+		return value{}, err
+	}
+	r := []rune(vals[0].toString())
+	start, err := positionArg("MID", vals[1], len(r)+1)
+	if err != nil {
+		return value{}, err
+	}
+	n, err := countArg("MID", vals[2], len(r))
+	if err != nil {
+		return value{}, err
+	}
+	if start > len(r) {
+		return stringValue(""), nil
+	}
+	end := start - 1 + n
+	if end > len(r) {
+		end = len(r)
+	}
+	return stringValue(string(r[start-1 : end])), nil
+}
+
+// UPPER(text) returns text in upper case.
+func fnUpper(s *Sheet, args []*Expression) (value, error) {
+	strs, err := textArgs(s, "UPPER", args, 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	return stringValue(strings.ToUpper(strs[0])), nil
+}
+
+// LOWER(text) returns text in lower case.
+func fnLower(s *Sheet, args []*Expression) (value, error) {
+	strs, err := textArgs(s, "LOWER", args, 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	return stringValue(strings.ToLower(strs[0])), nil
+}
+
+// TRIM(text) removes leading and trailing spaces from text, and collapses runs of spaces inside it
+// to a single space. Other whitespace is left alone.
+func fnTrim(s *Sheet, args []*Expression) (value, error) {
+	strs, err := textArgs(s, "TRIM", args, 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	var words []string
+	for _, w := range strings.Split(strs[0], " ") {
+		if w != "" {
+			words = append(words, w)
+		}
+	}
+	return stringValue(strings.Join(words, " ")), nil
+}
+
+// SUBSTITUTE(text, old, new, [instance]) replaces old with new in text. If instance is given, only
+// that occurrence of old, counting from 1, is replaced.
+func fnSubstitute(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "SUBSTITUTE", args, 3, 4)
+	if err != nil {
+		return value{}, err
+	}
+	text, old, repl := vals[0].toString(), vals[1].toString(), vals[2].toString()
+	if old == "" {
+		return stringValue(text), nil
+	}
+	if len(vals) == 3 {
+		return stringValue(strings.ReplaceAll(text, old, repl)), nil
+	}
+	instance, err := positionArg("SUBSTITUTE", vals[3], len(text)+1)
+	if err != nil {
+		return value{}, err
+	}
+	i := 0
+	for ; instance > 0; instance-- {
+		j := strings.Index(text[i:], old)
+		if j < 0 {
+			return stringValue(text), nil
+		}
+		i += j
+		if instance > 1 {
+			i += len(old)
+		}
+	}
+	return stringValue(text[:i] + repl + text[i+len(old):]), nil
+}
+
+// FIND(find, within, [start]) returns the position of the first occurrence of find in within at or
+// after position start. The search is case-sensitive. It is an error if find doesn't occur.
+func fnFind(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "FIND", args, 2, 3)
+	if err != nil {
+		return value{}, err
+	}
+	find, within := vals[0].toString(), []rune(vals[1].toString())
+	start := 1
+	if len(vals) > 2 {
+		// A start of len(within)+2 stands for every position past the end.
+		if start, err = positionArg("FIND", vals[2], len(within)+2); err != nil {
+			return value{}, err
+		}
+	}
+	if start > len(within)+1 {
+		return value{}, fmt.Errorf("FIND: start %s is past the end of the text", vals[2].toString())
+	}
+	i := strings.Index(string(within[start-1:]), find)
+	if i < 0 {
+		return value{}, fmt.Errorf("FIND: \"%s\" not found", find)
+	}
+	return numberValue(float64(start + utf8.RuneCountInString(string(within[start-1:])[:i]))), nil
+}
+
+// TEXT(value, format) returns value formatted as text with format, which is any format accepted by
+// (*Sheet).SetFormat, as in TEXT(A1, "fixed:2") or TEXT(A1, "date").
+func fnText(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "TEXT", args, 2, 2)
+	if err != nil {
+		return value{}, err
+	}
+	f, err := vals[0].toNumber()
+	if err != nil {
+		return value{}, err
+	}
+	format, err := parseNumberFormat(vals[1].toString())
+	if err != nil {
+		return value{}, fmt.Errorf("TEXT: %v", err)
+	}
+	return stringValue(format.format(f)), nil
+}
+
+// VALUE(text) converts text to a number. It understands everything that can be typed into a
+// cell as a number, including dates, times and durations.
+func fnValue(s *Sheet, args []*Expression) (value, error) {
+	vals, err := evalValueArgs(s, "VALUE", args, 1, 1)
+	if err != nil {
+		return value{}, err
+	}
+	if vals[0].kind != valString {
+		return numberValue(vals[0].num), nil
+	}
+	text := strings.TrimSpace(vals[0].str)
+	if f, ok := parseNumber(text); ok {
+		return numberValue(f), nil
+	}
+	if f, _, ok := parseDateTime(text); ok {
+		return numberValue(f), nil
+	}
+	return value{}, fmt.Errorf("VALUE: \"%s\" is not a number", vals[0].str)
+}
+
+// regexArgs evaluates the arguments of the regular expression function name, the first two of
+// which are the text and the pattern, in Go's regexp syntax.
+func regexArgs(s *Sheet, name string, args []*Expression, min, max int) ([]string, *regexp.Regexp, error) {
+	strs, err := textArgs(s, name, args, min, max)
+	if err != nil {
+		return nil, nil, err
+	}
+	re, err := regexp.Compile(strs[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return strs, re, nil
+}
+
+// REGEXMATCH(text, pattern) returns whether text contains a match for pattern.
+func fnRegexMatch(s *Sheet, args []*Expression) (value, error) {
+	strs, re, err := regexArgs(s, "REGEXMATCH", args, 2, 2)
+	if err != nil {
+		return value{}, err
+	}
+	return boolValue(re.MatchString(strs[0])), nil
+}
+
+// REGEXEXTRACT(text, pattern) returns the first match of pattern in text, or the text matched by
+// its first capturing group if it has one. It is an error if there is no match.
+func fnRegexExtract(s *Sheet, args []*Expression) (value, error) {
+	strs, re, err := regexArgs(s, "REGEXEXTRACT", args, 2, 2)
+	if err != nil {
+		return value{}, err
+	}
+	m := re.FindStringSubmatch(strs[0])
+	if m == nil {
+		return value{}, fmt.Errorf("REGEXEXTRACT: no match for \"%s\"", strs[1])
+	}
+	if len(m) > 1 {
+		return stringValue(m[1]), nil
+	}
+	return stringValue(m[0]), nil
+}
+
+// REGEXREPLACE(text, pattern, replacement) replaces every match of pattern in text with
+// replacement, in which $1 or ${name} refer to the text matched by capturing groups.
+func fnRegexReplace(s *Sheet, args []*Expression) (value, error) {
+	strs, re, err := regexArgs(s, "REGEXREPLACE", args, 3, 3)
+	if err != nil {
+		return value{}, err
+	}
+	return stringValue(re.ReplaceAllString(strs[0], strs[2])), nil
+}
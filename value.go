@@ -0,0 +1,250 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// valueKind is the kind of a value.
+type valueKind int
+
+const (
+	// valBlank is the value of an empty cell. It acts as 0 in arithmetic and as "" in text.
+	valBlank valueKind = iota
+	valNumber
+	valString
+	valBool
+	// valArray is a rectangular block of values, such as the cells of a range.
+	valArray
+	// valLambda is a function made by LAMBDA, which can be called or bound to a name.
+	valLambda
+)
+
+// value is the result of evaluating an expression.
+type value struct {
+	kind valueKind
+	num  float64
+	// rat is the exact value of num in a sheet in decimal mode, if it is known. See
+	// (*Sheet).SetDecimal.
+	rat *big.Rat
+	str string
+	// arr holds the rows of an array value.
+	arr [][]value
+	// fn is the function of a LAMBDA value.
+	fn *lambda
+}
+
+func numberValue(f float64) value {
+	return value{kind: valNumber, num: f}
+}
+
+func ratValue(r *big.Rat) value {
+	f, _ := r.Float64()
+	return value{kind: valNumber, num: f, rat: r}
+}
+
+func stringValue(s string) value {
+	return value{kind: valString, str: s}
+}
+
+func boolValue(b bool) value {
+	if b {
+		return value{kind: valBool, num: 1}
+	}
+	return value{kind: valBool}
+}
+
+func arrayValue(rows [][]value) value {
+	return value{kind: valArray, arr: rows}
+}
+
+// errArray is returned when an array with more than one value is used where a single value is
+// expected.
+var errArray = fmt.Errorf("Expected a single value, but got a range")
+
+// errLambda is returned when a LAMBDA is used where a value is expected, rather than being called.
+var errLambda = fmt.Errorf("Expected a value, but got a LAMBDA that isn't called")
+
+// scalar returns the single value in a 1x1 array, or v itself if it isn't an array.
+func (v value) scalar() (value, error) {
+	if v.kind != valArray {
+		return v, nil
+	}
+	if len(v.arr) != 1 || len(v.arr[0]) != 1 {
+		return value{}, errArray
+	}
+	return v.arr[0][0], nil
+}
+
+// parseNumber parses text as a finite number. strconv.ParseFloat also accepts "NaN" and "Inf",
+// which are text in a sheet.
+func parseNumber(text string) (float64, bool) {
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// toNumber returns the numeric value of v. Text is converted if it looks like a number.
+func (v value) toNumber() (float64, error) {
+	switch v.kind {
+	case valArray:
+		s, err := v.scalar()
+		if err != nil {
+			return 0, err
+		}
+		return s.toNumber()
+	case valLambda:
+		return 0, errLambda
+	case valString:
+		f, ok := parseNumber(strings.TrimSpace(v.str))
+		if !ok {
+			return 0, fmt.Errorf("Cannot get numeric value from string \"%s\"", v.str)
+		}
+		return f, nil
+	default:
+		return v.num, nil
+	}
+}
+
+// toRat returns the exact numeric value of v. See toNumber.
+func (v value) toRat() (*big.Rat, error) {
+	if v.rat != nil {
+		return v.rat, nil
+	}
+	if v.kind == valArray {
+		s, err := v.scalar()
+		if err != nil {
+			return nil, err
+		}
+		return s.toRat()
+	}
+	if v.kind == valString {
+		if r, ok := new(big.Rat).SetString(strings.TrimSpace(v.str)); ok {
+			return r, nil
+		}
+	}
+	f, err := v.toNumber()
+	if err != nil {
+		return nil, err
+	}
+	r := floatRat(f)
+	if r == nil {
+		return nil, fmt.Errorf("%v is not a finite number", f)
+	}
+	return r, nil
+}
+
+// toString returns v as text, as it would be shown in a cell with the general format. An array is
+// shown as its first value.
+func (v value) toString() string {
+	switch v.kind {
+	case valArray:
+		if len(v.arr) == 0 || len(v.arr[0]) == 0 {
+			return ""
+		}
+		return v.arr[0][0].toString()
+	case valBlank:
+		return ""
+	case valString:
+		return v.str
+	case valBool:
+		if v.num != 0 {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	if v.rat != nil {
+		return numberFormat{}.formatRat(v.rat)
+	}
+	return formatGeneralNumber(v.num)
+}
+
+// rows returns the rows of v, which is a single row holding v if v isn't an array.
+func (v value) rows() [][]value {
+	if v.kind == valArray {
+		return v.arr
+	}
+	return [][]value{{v}}
+}
+
+// vector returns the values of v, which must be a single row or column.
+func (v value) vector() ([]value, error) {
+	rows := v.rows()
+	if len(rows) == 1 {
+		return rows[0], nil
+	}
+	vec := make([]value, len(rows))
+	for i := range rows {
+		if len(rows[i]) != 1 {
+			return nil, fmt.Errorf("Expected a single row or column")
+		}
+		vec[i] = rows[i][0]
+	}
+	return vec, nil
+}
+
+// compareValues compares a and b, returning -1, 0 or 1 as a is less than, equal to or greater
+// than b. Text is compared ignoring case. ok is false if a and b are of different kinds and so
+// can't be compared, or if either is blank.
+func compareValues(a, b value) (cmp int, ok bool) {
+	if a.kind != b.kind || a.kind == valBlank || a.kind == valArray {
+		return 0, false
+	}
+	if a.kind == valString {
+		return strings.Compare(strings.ToLower(a.str), strings.ToLower(b.str)), true
+	}
+	if a.rat != nil && b.rat != nil {
+		return a.rat.Cmp(b.rat), true
+	}
+	switch {
+	case a.num < b.num:
+		return -1, true
+	case a.num > b.num:
+		return 1, true
+	}
+	return 0, true
+}
+
+// hasWildcards returns true if pattern contains any of the wildcards understood by
+// wildcardMatch.
+func hasWildcards(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?~")
+}
+
+// wildcardMatch reports whether s matches pattern, ignoring case. In pattern, * matches any
+// sequence of characters, ? matches any single character, and ~ makes the character after it
+// match literally, as in "~*".
+func wildcardMatch(pattern, s string) bool {
+	return wildcardRegexp(pattern).MatchString(s)
+}
+
+// wildcardRegexp returns a regular expression matching the same text as pattern does in
+// wildcardMatch.
+func wildcardRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	rs := []rune(pattern)
+	for i := 0; i < len(rs); i++ {
+		switch rs[i] {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '~':
+			if i+1 < len(rs) {
+				i++
+			}
+			fallthrough
+		default:
+			b.WriteString(regexp.QuoteMeta(string(rs[i])))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
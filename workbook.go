@@ -0,0 +1,277 @@
+package sheet
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ErrRef is wrapped by the error of a formula that refers to a sheet that doesn't exist, as when
+// the sheet has been deleted. Spreadsheets show these errors as #REF!. It can be detected with
+// errors.Is on the errors returned by (*Sheet).ValueAt.
+var ErrRef = errors.New("#REF!")
+
+// Workbook holds several named sheets, whose formulas can refer to each other's cells by the name
+// of the sheet followed by '!', as in =Sheet2!A1 or =SUM('Q3 Data'!B2:B9). Names holding anything
+// but letters, digits and underscores, or starting with a digit, are quoted with single quotes, and
+// a quote inside of a quoted name is written twice. Sheet names are not case sensitive.
+//
+// A 3D reference refers to the same cells of every sheet from the first sheet named to the last,
+// in the order of the sheets in the workbook, as in =SUM(Jan:Dec!B5) or =MAX('Jan 1:Dec 1'!B2:B9).
+// It evaluates to the values of each of the sheets in turn, one after another, so it is mostly
+// useful with functions that aggregate many values, like SUM, COUNT, AVERAGE, MIN and MAX. Sheets
+// inserted between the first and the last sheet are included from then on.
+//
+// Cells depend on the cells of other sheets they refer to just as they do on cells of their own
+// sheet. A change is recalculated the way the sheet it was made in is, by RecalcWorkers and
+// SetLazy, including in the cells of other sheets it affects, and subscribers to each sheet are
+// told about the changes to its own cells.
+type Workbook struct {
+	sheets []*Sheet
+}
+
+// NewWorkbook creates a new workbook with no sheets.
+func NewWorkbook() *Workbook {
+	return &Workbook{}
+}
+
+// invalidSheetName matches the names that can't be given to sheets.
+var invalidSheetName = regexp.MustCompile(`^$|[:\\/?*\[\]]|^'|'$`)
+
+// AddSheet adds a new, empty sheet named name after the other sheets of b, and returns it. Sheet
+// names must be unique, must not be empty, must not begin or end with a quote, and must not hold
+// any of : \ / ? * [ ]. Formulas that referred to a sheet named name before it was added refer to
+// the new sheet, and are recalculated.
+func (b *Workbook) AddSheet(name string) (*Sheet, error) {
+	return b.InsertSheet(len(b.sheets), name)
+}
+
+// InsertSheet is like AddSheet, but inserts the new sheet before the sheet at index i of Sheets,
+// or after the last sheet if i is the number of sheets. If the new sheet is inside of the sheets
+// spanned by a 3D reference, it is included in them.
+func (b *Workbook) InsertSheet(i int, name string) (*Sheet, error) {
+	if i < 0 || i > len(b.sheets) {
+		return nil, fmt.Errorf("Sheet index %d is out of range", i)
+	}
+	if invalidSheetName.MatchString(name) {
+		return nil, fmt.Errorf("%q is not a valid sheet name", name)
+	}
+	if b.Sheet(name) != nil {
+		return nil, fmt.Errorf("There is already a sheet named %s", name)
+	}
+	s := NewSheet()
+	s.book = b
+	s.name = name
+	b.sheets = append(b.sheets[:i:i], append([]*Sheet{s}, b.sheets[i:]...)...)
+	relinkCells(b.uses(name))
+	return s, nil
+}
+
+// Sheet returns the sheet of b named name, or nil if there is none.
+func (b *Workbook) Sheet(name string) *Sheet {
+	for _, s := range b.sheets {
+		if strings.EqualFold(s.name, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Sheets returns the sheets of b, in order.
+func (b *Workbook) Sheets() []*Sheet {
+	return append([]*Sheet(nil), b.sheets...)
+}
+
+// RenameSheet renames the sheet of b named old to new, which must be a valid name as for AddSheet.
+// The formulas referring to it, in every sheet of b, are rewritten to use new, in the canonical form
+// of (*Expression).String, and subscribers are told about them as recalculated cells. Formulas that referred to a sheet named new before refer
+// to the renamed sheet.
+func (b *Workbook) RenameSheet(old, new string) error {
+	s := b.Sheet(old)
+	if s == nil {
+		return fmt.Errorf("There is no sheet named %s", old)
+	}
+	if invalidSheetName.MatchString(new) {
+		return fmt.Errorf("%q is not a valid sheet name", new)
+	}
+	if t := b.Sheet(new); t != nil && t != s {
+		return fmt.Errorf("There is already a sheet named %s", new)
+	}
+	old = s.name
+	for _, c := range b.uses(old) {
+		if renameSheet(c.exp, old, new) {
+			c.expstr = c.exp.String()
+		}
+	}
+	for _, t := range b.sheets {
+		for _, d := range t.names {
+			renameSheet(d, old, new)
+		}
+	}
+	s.name = new
+	relinkCells(b.uses(new))
+	return nil
+}
+
+// DeleteSheet removes the sheet of b named name. The formulas referring to it, including 3D
+// references that begin or end with it, are recalculated, and have errors wrapping ErrRef until a
+// sheet of that name is added again. 3D references spanning it leave it out. The removed sheet keeps
+// its cells, but its own references to other sheets are errors as well.
+func (b *Workbook) DeleteSheet(name string) error {
+	s := b.Sheet(name)
+	if s == nil {
+		return fmt.Errorf("There is no sheet named %s", name)
+	}
+	// 3D references spanning s only refer to it while it is in b.
+	var cells []*Cell
+	for _, c := range b.uses(s.name) {
+		if c.sheet != s {
+			cells = append(cells, c)
+		}
+	}
+	for i, t := range b.sheets {
+		if t == s {
+			b.sheets = append(b.sheets[:i:i], b.sheets[i+1:]...)
+			break
+		}
+	}
+	s.book = nil
+	for _, rows := range s.matrix {
+		for _, c := range rows {
+			if c.exp != nil {
+				cells = append(cells, c)
+			}
+		}
+	}
+	relinkCells(cells)
+	return nil
+}
+
+// uses returns the cells of b whose formulas refer to the sheet named name.
+func (b *Workbook) uses(name string) []*Cell {
+	var cells []*Cell
+	for _, s := range b.sheets {
+		for _, rows := range s.matrix {
+			for _, c := range rows {
+				if c.exp != nil && c.refersToSheet(name) {
+					cells = append(cells, c)
+				}
+			}
+		}
+	}
+	return cells
+}
+
+// relinkCells rebuilds the references of the formulas of cells, after a sheet they refer to has
+// been added, renamed or deleted, and recalculates them.
+func relinkCells(cells []*Cell) {
+	var sheets []*Sheet
+	bySheet := make(map[*Sheet][]*Cell)
+	for _, c := range cells {
+		c.relinkAll()
+		if bySheet[c.sheet] == nil {
+			sheets = append(sheets, c.sheet)
+		}
+		bySheet[c.sheet] = append(bySheet[c.sheet], c)
+	}
+	for _, s := range sheets {
+		s.recalculateCells(bySheet[s])
+	}
+}
+
+// sheetSpan returns the sheets of the workbook of s from the sheet named first to the sheet named
+// last, in either order. It returns an error wrapping ErrRef if either of them doesn't exist.
+func (s *Sheet) sheetSpan(first, last string) ([]*Sheet, error) {
+	if s.book == nil {
+		return nil, fmt.Errorf("%w Unknown sheet %s", ErrRef, first)
+	}
+	i, j := -1, -1
+	for k, t := range s.book.sheets {
+		if strings.EqualFold(t.name, first) {
+			i = k
+		}
+		if strings.EqualFold(t.name, last) {
+			j = k
+		}
+	}
+	if i < 0 {
+		return nil, fmt.Errorf("%w Unknown sheet %s", ErrRef, first)
+	}
+	if j < 0 {
+		return nil, fmt.Errorf("%w Unknown sheet %s", ErrRef, last)
+	}
+	if i > j {
+		i, j = j, i
+	}
+	return s.book.sheets[i : j+1], nil
+}
+
+// Name returns the name of s in its Workbook, or "" if it was made by NewSheet or has been deleted.
+func (s *Sheet) Name() string {
+	if s.book == nil {
+		return ""
+	}
+	return s.name
+}
+
+// refersToSheet returns true if the formula of c refers to cells of the sheet named name, directly,
+// through a 3D reference or through the names it uses.
+func (c *Cell) refersToSheet(name string) bool {
+	found := false
+	c.exp.walk(func(e *Expression) {
+		if strings.EqualFold(e.sheet, name) || strings.EqualFold(e.lastSheet, name) {
+			found = true
+		}
+	})
+	for _, r := range c.exp.dynamicRefs(c.sheet) {
+		if strings.EqualFold(r.sheet, name) {
+			found = true
+		}
+	}
+	return found
+}
+
+// renameSheet changes the references in e to the sheet named old to refer to new, returning true
+// if there were any.
+func renameSheet(e *Expression, old, new string) bool {
+	renamed := false
+	e.walk(func(e *Expression) {
+		if strings.EqualFold(e.sheet, old) {
+			e.sheet = new
+			renamed = true
+		}
+		if strings.EqualFold(e.lastSheet, old) {
+			e.lastSheet = new
+			renamed = true
+		}
+	})
+	return renamed
+}
+
+// quoteSheet returns name as it is written before the '!' of a reference to a cell of the sheet.
+func quoteSheet(name string) string {
+	if plainSheet(name) {
+		return name
+	}
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
+
+// quoteSheets is like quoteSheet, for the first and last sheets of a 3D reference.
+func quoteSheets(first, last string) string {
+	if plainSheet(first) && plainSheet(last) {
+		return first + ":" + last
+	}
+	return "'" + strings.ReplaceAll(first+":"+last, "'", "''") + "'"
+}
+
+// plainSheet returns true if name can be written without quotes in a reference.
+func plainSheet(name string) bool {
+	for i, r := range name {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || i > 0 && (unicode.IsDigit(r) || r == '_')) {
+			return false
+		}
+	}
+	return name != ""
+}
@@ -0,0 +1,253 @@
+package sheet
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkbook(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			book := NewWorkbook()
+			summary, err := book.AddSheet("Summary")
+			assert.NoError(err)
+			data, err := book.AddSheet("Q3 Data")
+			assert.NoError(err)
+			rates, err := book.AddSheet("Rates")
+			assert.NoError(err)
+			for _, s := range book.Sheets() {
+				setup(s)
+			}
+			data.SetContent("B2", "10")
+			data.SetContent("B3", "20")
+			data.SetContent("B4", "30")
+			rates.SetContent("B1", "0.5")
+			summary.SetContent("A1", "=SUM('Q3 Data'!B2:B4)")
+			summary.SetContent("A2", "=A1*rates!B1")
+			summary.SetContent("A3", "=Summary!A1+1")
+			rates.SetContent("A1", "=Summary!A2*2")
+			assert.Equal([][]string{{"60"}, {"30"}, {"61"}}, contentBlock(summary, "A1:A3"))
+			assert.Equal([][]string{{"60"}}, contentBlock(rates, "A1:A1"))
+
+			// Cells depend on the cells of other sheets they refer to.
+			data.SetContent("B3", "50")
+			rates.SetContent("B1", "2")
+			assert.Equal([][]string{{"90"}, {"180"}, {"91"}}, contentBlock(summary, "A1:A3"))
+			assert.Equal([][]string{{"360"}}, contentBlock(rates, "A1:A1"))
+
+			// Only the cells of the sheet itself are dependents.
+			deps, err := data.Dependents("B3", true)
+			assert.NoError(err)
+			assert.Empty(deps)
+			deps, err = summary.Dependents("A1", true)
+			assert.NoError(err)
+			assert.Equal([]CellAddress{{"A", 2}, {"A", 3}}, deps)
+
+			// Cycles across sheets are found.
+			rates.SetContent("B1", "=Summary!A2")
+			_, err = summary.ValueAt("A2")
+			assert.Error(err)
+			rates.SetContent("B1", "1")
+			assert.Equal([][]string{{"90"}, {"90"}, {"91"}}, contentBlock(summary, "A1:A3"))
+		})
+	}
+}
+
+func TestWorkbookRenameSheet(t *testing.T) {
+	assert := assert.New(t)
+	book := NewWorkbook()
+	data, _ := book.AddSheet("Data")
+	summary, _ := book.AddSheet("Summary")
+	data.SetContent("A1", "1")
+	data.SetContent("A2", "2")
+	summary.SetContent("A1", "=Data!A1+'data'!A2")
+	summary.SetContent("A2", `="Data!"&Data!A1`)
+	summary.SetContent("A3", "=Other!A1")
+	summary.DefineName("Total", "SUM(Data!A1:A2)")
+	summary.SetContent("A4", "=Total*10")
+	_, err := summary.ValueAt("A3")
+	assert.True(errors.Is(err, ErrRef))
+
+	var events []CellEvent
+	summary.Subscribe(func(ev CellEvent) { events = append(events, ev) })
+	assert.NoError(book.RenameSheet("DATA", "Q3 Data"))
+	assert.Equal("Q3 Data", data.Name())
+	assert.Nil(book.Sheet("Data"))
+	edits := make([]string, 4)
+	for i, a := range []string{"A1", "A2", "A3", "A4"} {
+		edits[i], _ = summary.EditAt(a)
+	}
+	assert.Equal([]string{"='Q3 Data'!A1+'Q3 Data'!A2", `="Data!"&'Q3 Data'!A1`, "=Other!A1", "=Total*10"}, edits)
+	assert.Len(events, 3)
+	data.SetContent("A1", "5")
+	assert.Equal([][]string{{"7"}, {"Data!5"}, {"A3: #REF! Unknown sheet Other"}, {"70"}}, contentBlock(summary, "A1:A4"))
+
+	// Formulas referring to the new name refer to the renamed sheet.
+	assert.NoError(book.RenameSheet("Q3 Data", "Other"))
+	data.SetContent("A1", "6")
+	assert.Equal([][]string{{"8"}, {"Data!6"}, {"6"}, {"80"}}, contentBlock(summary, "A1:A4"))
+	edit, _ := summary.EditAt("A1")
+	assert.Equal("=Other!A1+Other!A2", edit)
+}
+
+func TestWorkbookDeleteSheet(t *testing.T) {
+	assert := assert.New(t)
+	book := NewWorkbook()
+	data, _ := book.AddSheet("Data")
+	summary, _ := book.AddSheet("Summary")
+	data.SetContent("A1", "3")
+	data.SetContent("A2", "=Summary!B1")
+	summary.SetContent("A1", "=Data!A1*2")
+	summary.SetContent("B1", "4")
+	assert.Equal([][]string{{"4"}}, contentBlock(data, "A2:A2"))
+
+	assert.NoError(book.DeleteSheet("data"))
+	assert.Equal([]*Sheet{summary}, book.Sheets())
+	assert.Equal("", data.Name())
+	_, err := summary.ValueAt("A1")
+	assert.True(errors.Is(err, ErrRef))
+	deps, err := summary.Dependents("B1", false)
+	assert.NoError(err)
+	assert.Empty(deps)
+	// The deleted sheet keeps its cells, but its references are errors.
+	_, err = data.ValueAt("A2")
+	assert.True(errors.Is(err, ErrRef))
+	assert.Equal([][]string{{"3"}}, contentBlock(data, "A1:A1"))
+
+	// Adding a sheet of the same name again restores the references.
+	data, err = book.AddSheet("Data")
+	assert.NoError(err)
+	assert.Equal([][]string{{"0"}}, contentBlock(summary, "A1:A1"))
+	data.SetContent("A1", "5")
+	assert.Equal([][]string{{"10"}}, contentBlock(summary, "A1:A1"))
+}
+
+func TestWorkbookErrors(t *testing.T) {
+	assert := assert.New(t)
+	book := NewWorkbook()
+	_, err := book.AddSheet("Data")
+	assert.NoError(err)
+	for _, name := range []string{"", "a:b", "a/b", "a?", "x[1]", "'x", "x'", "DATA"} {
+		_, err := book.AddSheet(name)
+		assert.Error(err, name)
+	}
+	assert.Len(book.Sheets(), 1)
+	book.AddSheet("Other")
+	assert.Error(book.RenameSheet("Missing", "X"))
+	assert.Error(book.RenameSheet("Data", "other"))
+	assert.Error(book.RenameSheet("Data", "a*b"))
+	assert.NoError(book.RenameSheet("Data", "data"))
+	assert.Error(book.DeleteSheet("Missing"))
+
+	// A sheet outside of a workbook has no other sheets to refer to.
+	s := NewSheet()
+	s.SetContent("A1", "=Sheet2!A1")
+	_, err = s.ValueAt("A1")
+	assert.True(errors.Is(err, ErrRef))
+}
+
+func TestWorkbookDynamicReferences(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			book := NewWorkbook()
+			summary, _ := book.AddSheet("Summary")
+			mar, _ := book.AddSheet("Mar")
+			data, _ := book.AddSheet("Q3 Data")
+			for _, s := range book.Sheets() {
+				setup(s)
+			}
+			mar.SetContent("B5", "7")
+			mar.SetContent("B6", "8")
+			data.SetContent("B1", "10")
+			data.SetContent("B2", "20")
+			summary.SetContent("B1", "Mar")
+			summary.SetContent("A1", `=INDIRECT("Mar!B5")`)
+			summary.SetContent("A2", `=SUM(INDIRECT("'Q3 Data'!B1:B2"))`)
+			summary.SetContent("A3", `=INDIRECT(B1&"!B6")*10`)
+			summary.SetContent("A4", "=SUM(OFFSET(Mar!B5,0,0,2,1))")
+			summary.SetContent("A5", `=INDIRECT("Nowhere!A1")`)
+			summary.SetContent("A6", `=INDIRECT("Jan:Mar!B5")`)
+			assert.Equal([][]string{{"7"}, {"30"}, {"80"}, {"15"}}, contentBlock(summary, "A1:A4"))
+			_, err := summary.ValueAt("A5")
+			assert.True(errors.Is(err, ErrRef))
+			_, err = summary.ValueAt("A6")
+			assert.EqualError(err, "A6: INDIRECT: Invalid reference 'Jan:Mar!B5'")
+
+			// Cells depend on the cells of other sheets they refer to through INDIRECT and OFFSET.
+			mar.SetContent("B5", "1")
+			data.SetContent("B2", "5")
+			assert.Equal([][]string{{"1"}, {"15"}, {"80"}, {"9"}}, contentBlock(summary, "A1:A4"))
+			summary.SetContent("B1", "'Q3 Data'")
+			mar.SetContent("B6", "2")
+			assert.Equal([][]string{{"1"}, {"15"}, {"0"}, {"3"}}, contentBlock(summary, "A1:A4"))
+			data.SetContent("B6", "4")
+			assert.Equal([][]string{{"40"}}, contentBlock(summary, "A3:A3"))
+		})
+	}
+}
+
+func Test3DReferences(t *testing.T) {
+	for name, setup := range map[string]func(s *Sheet){
+		"serial":   func(s *Sheet) {},
+		"parallel": func(s *Sheet) { s.RecalcWorkers = 4 },
+		"lazy":     func(s *Sheet) { s.SetLazy(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			book := NewWorkbook()
+			summary, _ := book.AddSheet("Summary")
+			setup(summary)
+			for i, month := range []string{"Jan", "Feb", "Mar"} {
+				s, _ := book.AddSheet(month)
+				setup(s)
+				s.SetContent("B5", fmt.Sprint(i+1))
+				s.SetContent("B6", "Total")
+			}
+			summary.SetContent("A1", "=SUM(Jan:Mar!B5)")
+			summary.SetContent("A2", "=COUNT(mar:jan!B5:B6)")
+			summary.SetContent("A3", "=MAX('Feb:Mar'!B5)")
+			assert.Equal([][]string{{"6"}, {"3"}, {"3"}}, contentBlock(summary, "A1:A3"))
+
+			book.Sheet("Feb").SetContent("B5", "5")
+			assert.Equal([][]string{{"9"}, {"3"}, {"5"}}, contentBlock(summary, "A1:A3"))
+
+			// A sheet inserted between the first and last sheets is included.
+			extra, err := book.InsertSheet(3, "Extra")
+			assert.NoError(err)
+			setup(extra)
+			extra.SetContent("B5", "10")
+			after, _ := book.AddSheet("Apr")
+			after.SetContent("B5", "100")
+			assert.Equal([][]string{{"19"}, {"4"}, {"10"}}, contentBlock(summary, "A1:A3"))
+
+			// Renaming the first sheet rewrites the references to it.
+			assert.NoError(book.RenameSheet("Jan", "January 1"))
+			edits := make([]string, 3)
+			for i, a := range []string{"A1", "A2", "A3"} {
+				edits[i], _ = summary.EditAt(a)
+			}
+			assert.Equal([]string{"=SUM('January 1:Mar'!B5)", "=COUNT('mar:January 1'!B5:B6)", "=MAX('Feb:Mar'!B5)"}, edits)
+			assert.Equal([][]string{{"19"}}, contentBlock(summary, "A1:A1"))
+
+			// Deleting a sheet in between leaves it out. Deleting the last sheet is an error.
+			assert.NoError(book.DeleteSheet("Extra"))
+			assert.Equal([][]string{{"9"}, {"3"}, {"5"}}, contentBlock(summary, "A1:A3"))
+			assert.NoError(book.DeleteSheet("Mar"))
+			_, err = summary.ValueAt("A1")
+			assert.True(errors.Is(err, ErrRef))
+		})
+	}
+}